@@ -0,0 +1,269 @@
+// Package admin implements local http endpoint for inspecting and controlling a running node.
+//
+// Endpoints, keys are hex encoded:
+//
+//	GET  /buckets              routing table buckets with peers
+//	GET  /keys                 locally stored keys with value sizes
+//	POST /refresh              refresh all buckets
+//	POST /join?addr=host:port  join network through node listening on addr
+//	GET  /value?key=...        network lookup of value
+//	PUT  /value?key=...&ttl=1h store request body in the network
+//	GET  /calls                pending rpc calls
+package admin
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/mduszyk/gopeers/dht"
+	"github.com/mduszyk/gopeers/rpc"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Node is satisfied by node returned from dht.StartUdpProtocolNode.
+type Node interface {
+	KadNode() *dht.KadNode
+	RpcNode() *rpc.UdpNode
+	Discover(ctx context.Context, addr *net.UDPAddr) (*dht.Peer, error)
+}
+
+type handler struct {
+	node    Node
+	timeout time.Duration
+}
+
+// NewHandler returns handler serving admin endpoints, network operations are bounded by timeout.
+func NewHandler(node Node, timeout time.Duration) http.Handler {
+	h := &handler{node: node, timeout: timeout}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/buckets", h.method(http.MethodGet, h.buckets))
+	mux.HandleFunc("/keys", h.method(http.MethodGet, h.keys))
+	mux.HandleFunc("/refresh", h.method(http.MethodPost, h.refresh))
+	mux.HandleFunc("/join", h.method(http.MethodPost, h.join))
+	mux.HandleFunc("/value", h.value)
+	mux.HandleFunc("/calls", h.method(http.MethodGet, h.calls))
+	return mux
+}
+
+// ErrNotLoopback is returned by Listen for tcp addresses reachable from other hosts.
+var ErrNotLoopback = errors.New("admin address is not loopback, remote access has to be allowed explicitly")
+
+// Listen listens on unix socket given as unix:<path>, on tcp address otherwise,
+// tcp addresses have to be loopback unless remote is set.
+func Listen(address string, remote bool) (net.Listener, error) {
+	if strings.HasPrefix(address, "unix:") {
+		path := strings.TrimPrefix(address, "unix:")
+		// remove socket left by previous run
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		return net.Listen("unix", path)
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	// check bound address, host names and empty host resolve only here
+	if addr, ok := listener.Addr().(*net.TCPAddr); !remote && (!ok || !addr.IP.IsLoopback()) {
+		listener.Close()
+		return nil, ErrNotLoopback
+	}
+	return listener, nil
+}
+
+type peerInfo struct {
+	Id       string    `json:"id"`
+	Addr     string    `json:"addr,omitempty"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type bucketInfo struct {
+	Depth        int        `json:"depth"`
+	Lo           string     `json:"lo"`
+	Hi           string     `json:"hi"`
+	LastLookup   time.Time  `json:"last_lookup"`
+	Peers        []peerInfo `json:"peers"`
+	Replacements []peerInfo `json:"replacements"`
+}
+
+type keyInfo struct {
+	Key    string     `json:"key"`
+	Size   int        `json:"size"`
+	Expiry *time.Time `json:"expiry,omitempty"`
+}
+
+type callInfo struct {
+	CallId    rpc.CallId    `json:"call_id"`
+	ServiceId rpc.ServiceId `json:"service_id"`
+	Addr      string        `json:"addr"`
+	Started   time.Time     `json:"started"`
+}
+
+func (h *handler) method(method string, f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		f(w, r)
+	}
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func peerInfos(peers []dht.Peer) []peerInfo {
+	infos := make([]peerInfo, len(peers))
+	for i := range peers {
+		infos[i] = peerInfo{Id: hex.EncodeToString(peers[i].Id.Bytes()), LastSeen: peers[i].LastSeen}
+		if addr := dht.UdpAddr(&peers[i]); addr != nil {
+			infos[i].Addr = addr.String()
+		}
+	}
+	return infos
+}
+
+func (h *handler) buckets(w http.ResponseWriter, _ *http.Request) {
+	buckets := h.node.KadNode().Buckets()
+	infos := make([]bucketInfo, len(buckets))
+	for i, b := range buckets {
+		infos[i] = bucketInfo{
+			Depth:        b.Depth,
+			Lo:           hex.EncodeToString(b.Lo.Bytes()),
+			Hi:           hex.EncodeToString(b.Hi.Bytes()),
+			LastLookup:   b.LastLookup,
+			Peers:        peerInfos(b.Peers),
+			Replacements: peerInfos(b.Replacements),
+		}
+	}
+	writeJson(w, infos)
+}
+
+func (h *handler) keys(w http.ResponseWriter, _ *http.Request) {
+	storage := h.node.KadNode().Storage
+	keys, err := storage.Keys()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	infos := make([]keyInfo, 0, len(keys))
+	for _, key := range keys {
+		size, err := storage.Size(key)
+		if err != nil {
+			// expired in the meantime
+			continue
+		}
+		info := keyInfo{Key: hex.EncodeToString(key), Size: size}
+		if expiry, err := storage.Expiry(key); err == nil && !expiry.IsZero() {
+			info.Expiry = &expiry
+		}
+		infos = append(infos, info)
+	}
+	writeJson(w, infos)
+}
+
+func (h *handler) refresh(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+	err := h.node.KadNode().RefreshContext(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) join(w http.ResponseWriter, r *http.Request) {
+	addr, err := net.ResolveUDPAddr("udp", r.URL.Query().Get("addr"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+	peer, err := h.node.Discover(ctx, addr)
+	if err == nil {
+		err = h.node.KadNode().JoinContext(ctx, peer)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parseKey(r *http.Request) ([]byte, error) {
+	key, err := hex.DecodeString(r.URL.Query().Get("key"))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 || len(key)*8 > dht.IdBits {
+		return nil, errors.New("invalid key length")
+	}
+	return key, nil
+}
+
+func (h *handler) value(w http.ResponseWriter, r *http.Request) {
+	key, err := parseKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+	switch r.Method {
+	case http.MethodGet:
+		value, err := h.node.KadNode().GetContext(ctx, key)
+		if err == dht.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(value)
+	case http.MethodPut:
+		var ttl time.Duration
+		if s := r.URL.Query().Get("ttl"); s != "" {
+			ttl, err = time.ParseDuration(s)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		value, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = h.node.KadNode().SetTTLContext(ctx, key, value, ttl)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *handler) calls(w http.ResponseWriter, _ *http.Request) {
+	calls := h.node.RpcNode().PendingCalls()
+	infos := make([]callInfo, len(calls))
+	for i, call := range calls {
+		infos[i] = callInfo{call.CallId, call.ServiceId, call.Addr.String(), call.Started}
+	}
+	writeJson(w, infos)
+}
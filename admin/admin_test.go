@@ -0,0 +1,145 @@
+package admin
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/mduszyk/gopeers/dht"
+	"github.com/mduszyk/gopeers/store"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startNode(t *testing.T) Node {
+	node, err := dht.StartUdpProtocolNode(
+		20, 5, 3, dht.MathRandIdentity(), store.NewMemStorage(), "localhost:", time.Second, 10240)
+	if err != nil {
+		t.Fatalf("failed creating node: %v\n", err)
+	}
+	t.Cleanup(func() { node.Close() })
+	return node
+}
+
+func request(t *testing.T, server *httptest.Server, method, path, body string) (int, string) {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed creating request: %v\n", err)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v\n", err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestAdmin(t *testing.T) {
+	node1 := startNode(t)
+	node2 := startNode(t)
+	server := httptest.NewServer(NewHandler(node1, 5*time.Second))
+	defer server.Close()
+
+	status, _ := request(t, server, http.MethodPost, "/join?addr="+node2.RpcNode().Addr.String(), "")
+	if status != http.StatusNoContent {
+		t.Errorf("join failed with status: %d\n", status)
+	}
+	status, body := request(t, server, http.MethodGet, "/buckets", "")
+	var buckets []bucketInfo
+	err := json.Unmarshal([]byte(body), &buckets)
+	if status != http.StatusOK || err != nil || len(buckets) == 0 {
+		t.Fatalf("invalid buckets response: %d, %s, %v\n", status, body, err)
+	}
+	id2 := hex.EncodeToString(node2.KadNode().Peer.Id.Bytes())
+	if !strings.Contains(body, id2) || !strings.Contains(body, node2.RpcNode().Addr.String()) {
+		t.Errorf("buckets should contain joined peer: %s\n", body)
+	}
+
+	key := hex.EncodeToString(dht.Sha1Id([]byte("key")).Bytes())
+	status, body = request(t, server, http.MethodPut, "/value?ttl=1h&key="+key, "value")
+	if status != http.StatusNoContent {
+		t.Errorf("put failed: %d, %s\n", status, body)
+	}
+	status, body = request(t, server, http.MethodGet, "/value?key="+key, "")
+	if status != http.StatusOK || body != "value" {
+		t.Errorf("get failed: %d, %s\n", status, body)
+	}
+	status, _ = request(t, server, http.MethodGet, "/value?key=00ff", "")
+	if status != http.StatusNotFound {
+		t.Errorf("missing value should not be found, got status: %d\n", status)
+	}
+	status, _ = request(t, server, http.MethodGet, "/value?key=zz", "")
+	if status != http.StatusBadRequest {
+		t.Errorf("invalid key should be rejected, got status: %d\n", status)
+	}
+
+	status, body = request(t, server, http.MethodGet, "/keys", "")
+	var keys []keyInfo
+	err = json.Unmarshal([]byte(body), &keys)
+	if status != http.StatusOK || err != nil {
+		t.Fatalf("invalid keys response: %d, %s, %v\n", status, body, err)
+	}
+	found := false
+	for _, k := range keys {
+		if k.Key == key && k.Size == len("value") && k.Expiry != nil {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("stored key should be listed: %s\n", body)
+	}
+
+	status, _ = request(t, server, http.MethodPost, "/refresh", "")
+	if status != http.StatusNoContent {
+		t.Errorf("refresh failed with status: %d\n", status)
+	}
+	status, body = request(t, server, http.MethodGet, "/calls", "")
+	if status != http.StatusOK || strings.TrimSpace(body) != "[]" {
+		t.Errorf("no calls should be pending: %d, %s\n", status, body)
+	}
+	status, _ = request(t, server, http.MethodGet, "/refresh", "")
+	if status != http.StatusMethodNotAllowed {
+		t.Errorf("refresh should require post, got status: %d\n", status)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	for i := 0; i < 2; i++ {
+		// second listen replaces socket left behind
+		listener, err := Listen("unix:"+path, false)
+		if err != nil {
+			t.Fatalf("failed listening on unix socket: %v\n", err)
+		}
+		if listener.Addr().Network() != "unix" {
+			t.Errorf("expected unix listener, got: %s\n", listener.Addr().Network())
+		}
+		if i == 0 {
+			// simulate crash, socket file is left behind
+			listener.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+		}
+		listener.Close()
+	}
+}
+
+func TestListenLoopback(t *testing.T) {
+	listener, err := Listen("localhost:0", false)
+	if err != nil {
+		t.Fatalf("failed listening on loopback: %v\n", err)
+	}
+	listener.Close()
+
+	_, err = Listen(":0", false)
+	if err != ErrNotLoopback {
+		t.Errorf("listening on all interfaces should be rejected, got: %v\n", err)
+	}
+	listener, err = Listen(":0", true)
+	if err != nil {
+		t.Fatalf("remote listening should be allowed explicitly: %v\n", err)
+	}
+	listener.Close()
+}
@@ -0,0 +1,266 @@
+// Command peerctl is a short-lived client of a running network.
+//
+// Usage:
+//
+//	peerctl [flags] ping
+//	peerctl [flags] put <key> <value>
+//	peerctl [flags] get <key>
+//	peerctl [flags] find-node <id>
+//	peerctl [flags] providers <key>
+//	peerctl -admin <addr> dump
+//
+// Keys and ids are SHA-1 hashes of given text unless prefixed with hex:.
+// ping talks only to the target node, other commands look up through it without joining the network.
+// dump reads routing table from admin endpoint of the node, host:port or unix:<path>.
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/mduszyk/gopeers/dht"
+	"github.com/mduszyk/gopeers/rpc"
+	"github.com/mduszyk/gopeers/store"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type options struct {
+	target      string
+	listen      string
+	timeout     time.Duration
+	callTimeout time.Duration
+	ttl         time.Duration
+	k           int
+	alpha       int
+	bufferSize  uint
+	// larger payloads go over tcp, zero disables tcp
+	tcpThreshold int
+	encrypt      bool
+	admin        string
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	var opts options
+	fs := flag.NewFlagSet("peerctl", flag.ContinueOnError)
+	fs.StringVar(&opts.target, "target", "localhost:4000", "address of target node")
+	fs.StringVar(&opts.listen, "listen", "localhost:", "udp listen address of the client")
+	fs.DurationVar(&opts.timeout, "timeout", 10*time.Second, "command timeout")
+	fs.DurationVar(&opts.callTimeout, "call-timeout", 2*time.Second, "rpc call timeout")
+	fs.DurationVar(&opts.ttl, "ttl", 0, "ttl of put value, zero means no expiration")
+	fs.IntVar(&opts.k, "k", 20, "bucket size and replication factor")
+	fs.IntVar(&opts.alpha, "alpha", 3, "lookup parallelism")
+	fs.UintVar(&opts.bufferSize, "read-buffer-size", 65536, "udp read buffer size")
+	fs.IntVar(&opts.tcpThreshold, "tcp-threshold", 10112, "payloads larger than this go over tcp, zero disables tcp")
+	fs.BoolVar(&opts.encrypt, "encrypt", false, "encrypt udp traffic, target has to enable it too, disables tcp")
+	fs.StringVar(&opts.admin, "admin", "", "admin endpoint of the node dumped, host:port or unix:<path>")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return errors.New("command required: ping, put, get, providers, find-node or dump")
+	}
+	command, params := fs.Arg(0), fs.Args()[1:]
+	expected := map[string]int{"ping": 0, "put": 2, "get": 1, "providers": 1, "find-node": 1, "dump": 0}
+	n, ok := expected[command]
+	if !ok {
+		return fmt.Errorf("unknown command: %s", command)
+	}
+	if len(params) != n {
+		return fmt.Errorf("%s expects %d arguments", command, n)
+	}
+	if command == "dump" {
+		if opts.admin == "" {
+			return errors.New("dump requires -admin")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+		defer cancel()
+		buckets, err := fetchBuckets(ctx, opts.admin)
+		if err != nil {
+			return err
+		}
+		dump(out, buckets)
+		return nil
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", opts.target)
+	if err != nil {
+		return err
+	}
+	identity, err := dht.NewIdentity()
+	if err != nil {
+		return err
+	}
+	id := identity.Id()
+	rpcNode, err := rpc.NewUdpNode(opts.listen, nil, opts.callTimeout, uint32(opts.bufferSize))
+	if err != nil {
+		return err
+	}
+	if opts.encrypt {
+		rpcNode.EnableEncryption(identity.PrivateKey)
+	}
+	node, err := dht.NewUdpProtocolNode(rpcNode, dht.NewKadNode(opts.k, 5, opts.alpha, id, store.NewMemStorage()), identity)
+	if err != nil {
+		rpcNode.Close()
+		return err
+	}
+	if opts.tcpThreshold > 0 && !opts.encrypt {
+		tcpNode, err := rpc.NewTcpNode(rpcNode.Addr.String(), nil, opts.callTimeout)
+		if err != nil {
+			rpcNode.Close()
+			return err
+		}
+		node.EnableTcp(tcpNode, opts.tcpThreshold)
+		go tcpNode.Run()
+	}
+	go rpcNode.Run()
+	defer node.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+	defer cancel()
+
+	start := time.Now()
+	target, err := node.Discover(ctx, addr)
+	if err != nil {
+		return err
+	}
+
+	if command == "ping" {
+		fmt.Fprintf(out, "%040x %v %v\n", target.Id, addr, time.Since(start))
+		return nil
+	}
+
+	node.KadNode().Seed(target)
+	key, err := parseKey(params[0])
+	if err != nil {
+		return err
+	}
+	switch command {
+	case "put":
+		return node.KadNode().SetTTLContext(ctx, key.Bytes(), []byte(params[1]), opts.ttl)
+	case "get":
+		value, err := node.KadNode().GetContext(ctx, key.Bytes())
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			return errors.New("value not found")
+		}
+		fmt.Fprintf(out, "%s\n", value)
+		return nil
+	case "providers":
+		providers, err := node.KadNode().GetProvidersContext(ctx, key.Bytes())
+		if err != nil {
+			return err
+		}
+		for _, peer := range providers {
+			fmt.Fprintf(out, "%040x %v\n", peer.Id, dht.UdpAddr(peer))
+		}
+		return nil
+	default:
+		result, err := node.KadNode().LookupContext(ctx, key, false)
+		if err != nil {
+			return err
+		}
+		for _, peer := range result.Peers() {
+			if peer.Id.Cmp(id) == 0 {
+				// skip the client itself
+				continue
+			}
+			distance := new(big.Int).Xor(peer.Id, key)
+			fmt.Fprintf(out, "%040x %v %040x\n", peer.Id, dht.UdpAddr(peer), distance)
+		}
+		return nil
+	}
+}
+
+func parseKey(s string) (dht.Id, error) {
+	if strings.HasPrefix(s, "hex:") {
+		bytes, err := hex.DecodeString(strings.TrimPrefix(s, "hex:"))
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes)*8 > dht.IdBits {
+			return nil, fmt.Errorf("id longer than %d bits", dht.IdBits)
+		}
+		return dht.BytesId(bytes), nil
+	}
+	return dht.Sha1Id([]byte(s)), nil
+}
+
+type peerInfo struct {
+	Id       string    `json:"id"`
+	Addr     string    `json:"addr"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type bucketInfo struct {
+	Depth int        `json:"depth"`
+	Lo    string     `json:"lo"`
+	Hi    string     `json:"hi"`
+	Peers []peerInfo `json:"peers"`
+}
+
+// fetchBuckets gets routing table from /buckets of admin endpoint.
+func fetchBuckets(ctx context.Context, address string) ([]bucketInfo, error) {
+	client := &http.Client{}
+	url := "http://" + address + "/buckets"
+	if strings.HasPrefix(address, "unix:") {
+		path := strings.TrimPrefix(address, "unix:")
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		}
+		url = "http://admin/buckets"
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("admin endpoint returned %s", response.Status)
+	}
+	var buckets []bucketInfo
+	err = json.NewDecoder(response.Body).Decode(&buckets)
+	return buckets, err
+}
+
+func hexId(s string) dht.Id {
+	bytes, _ := hex.DecodeString(s)
+	return dht.BytesId(bytes)
+}
+
+func dump(out io.Writer, buckets []bucketInfo) {
+	for _, b := range buckets {
+		fmt.Fprintf(out, "bucket depth %d [%040x, %040x) peers %d\n", b.Depth, hexId(b.Lo), hexId(b.Hi), len(b.Peers))
+		for _, p := range b.Peers {
+			fmt.Fprintf(out, "  %040x %s %s\n", hexId(p.Id), p.Addr, p.LastSeen.Format(time.RFC3339))
+		}
+	}
+}
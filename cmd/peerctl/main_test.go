@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/mduszyk/gopeers/admin"
+	"github.com/mduszyk/gopeers/dht"
+	"github.com/mduszyk/gopeers/store"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommands(t *testing.T) {
+	nodes := make([]string, 3)
+	kadNodes := make([]*dht.KadNode, 3)
+	var adminServer *httptest.Server
+	var first *net.UDPAddr
+	for i := range nodes {
+		node, err := dht.StartUdpProtocolNode(
+			20, 5, 3, dht.MathRandIdentity(), store.NewMemStorage(), "localhost:", time.Second, 65536)
+		if err != nil {
+			t.Fatalf("failed creating node: %v\n", err)
+		}
+		defer node.Close()
+		nodes[i] = node.RpcNode().Addr.String()
+		if i == 1 {
+			adminServer = httptest.NewServer(admin.NewHandler(node, time.Second))
+			defer adminServer.Close()
+		}
+		kadNodes[i] = node.KadNode()
+		if i > 0 {
+			peer, err := node.Discover(context.Background(), first)
+			if err != nil {
+				t.Fatalf("failed discovering first node: %v\n", err)
+			}
+			err = node.KadNode().Join(peer)
+			if err != nil {
+				t.Fatalf("failed joining: %v\n", err)
+			}
+		} else {
+			first = node.RpcNode().Addr
+		}
+	}
+	target := "-target=" + nodes[1]
+	for _, node := range kadNodes {
+		err := node.Provide(dht.Sha1Id([]byte("key")).Bytes(), time.Hour)
+		if err != nil {
+			t.Fatalf("failed providing: %v\n", err)
+		}
+	}
+
+	var out bytes.Buffer
+	err := run([]string{target, "ping"}, &out)
+	if err != nil || !strings.Contains(out.String(), nodes[1]) {
+		t.Errorf("ping failed: %q, %v\n", out.String(), err)
+	}
+
+	err = run([]string{target, "put", "key", "value"}, &out)
+	if err != nil {
+		t.Errorf("put failed: %v\n", err)
+	}
+	out.Reset()
+	err = run([]string{"-target=" + nodes[2], "get", "key"}, &out)
+	if err != nil || out.String() != "value\n" {
+		t.Errorf("get failed: %q, %v\n", out.String(), err)
+	}
+
+	out.Reset()
+	err = run([]string{target, "providers", "key"}, &out)
+	if err != nil || len(strings.Split(strings.TrimSpace(out.String()), "\n")) != len(kadNodes) {
+		t.Errorf("providers failed: %q, %v\n", out.String(), err)
+	}
+
+	out.Reset()
+	key := fmt.Sprintf("hex:%x", dht.Sha1Id([]byte("key")).Bytes())
+	err = run([]string{target, "find-node", key}, &out)
+	if err != nil || len(strings.Split(strings.TrimSpace(out.String()), "\n")) < 3 {
+		t.Errorf("find-node failed: %q, %v\n", out.String(), err)
+	}
+
+	out.Reset()
+	err = run([]string{"-admin=" + adminServer.Listener.Addr().String(), "dump"}, &out)
+	if err != nil || !strings.Contains(out.String(), "bucket depth 0") {
+		t.Errorf("dump failed: %q, %v\n", out.String(), err)
+	}
+
+	err = run([]string{target, "get"}, &out)
+	if err == nil {
+		t.Errorf("missing argument should be rejected\n")
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/mduszyk/gopeers/dht"
+	"github.com/mduszyk/gopeers/logging"
+	"github.com/mduszyk/gopeers/store"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	K int `json:"k"`
+	B int `json:"b"`
+	Alpha int `json:"alpha"`
+	Listen string `json:"listen"`
+	Bootstrap stringList `json:"bootstrap"`
+	// source of ed25519 key the node id is derived from: random, hex:<seed> or file:<path>,
+	// file is created with random seed if missing
+	Id string `json:"id"`
+	// memory or file:<path>
+	Storage string `json:"storage"`
+	// routing table snapshot file, empty disables snapshots
+	Snapshot string `json:"snapshot"`
+	SnapshotInterval Duration `json:"snapshot_interval"`
+	CallTimeout Duration `json:"call_timeout"`
+	ReadBufferSize uint `json:"read_buffer_size"`
+	// larger payloads go over tcp on listen port, it should fit into read buffer of every node
+	// with room for rpc envelope, zero disables tcp
+	TcpThreshold int `json:"tcp_threshold"`
+	// udp traffic goes over sessions encrypted with node key, all peers have to enable it,
+	// tcp is not used then and large payloads are fragmented
+	Encrypt bool `json:"encrypt"`
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+	// admin http endpoint, host:port or unix:<path>, empty disables it
+	Admin string `json:"admin"`
+	// admin endpoint may listen on addresses other than loopback, it has no authentication
+	AdminRemote bool `json:"admin_remote"`
+	// debug, info, warn or error
+	LogLevel string `json:"log_level"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		K: 20,
+		B: 5,
+		Alpha: 3,
+		Listen: "localhost:4000",
+		Id: "random",
+		Storage: "memory",
+		SnapshotInterval: Duration{10 * time.Minute},
+		CallTimeout: Duration{5 * time.Second},
+		ReadBufferSize: 10240,
+		TcpThreshold: 10112,
+		ShutdownTimeout: Duration{10 * time.Second},
+		LogLevel: "info",
+	}
+}
+
+// Duration is time.Duration encoded in json as string, e.g. "1m30s".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = nil
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+func bindFlags(fs *flag.FlagSet, c *Config) {
+	fs.IntVar(&c.K, "k", c.K, "bucket size and replication factor")
+	fs.IntVar(&c.B, "b", c.B, "bucket split depth modulus")
+	fs.IntVar(&c.Alpha, "alpha", c.Alpha, "lookup parallelism")
+	fs.StringVar(&c.Listen, "listen", c.Listen, "udp listen address")
+	fs.Var(&c.Bootstrap, "bootstrap", "comma separated bootstrap node addresses")
+	fs.StringVar(&c.Id, "id", c.Id, "node key source: random, hex:<seed> or file:<path>")
+	fs.StringVar(&c.Storage, "storage", c.Storage, "storage backend: memory or file:<path>")
+	fs.StringVar(&c.Snapshot, "snapshot", c.Snapshot, "routing table snapshot file")
+	fs.DurationVar(&c.SnapshotInterval.Duration, "snapshot-interval", c.SnapshotInterval.Duration, "routing table snapshot interval")
+	fs.DurationVar(&c.CallTimeout.Duration, "call-timeout", c.CallTimeout.Duration, "rpc call timeout")
+	fs.UintVar(&c.ReadBufferSize, "read-buffer-size", c.ReadBufferSize, "udp read buffer size")
+	fs.IntVar(&c.TcpThreshold, "tcp-threshold", c.TcpThreshold, "payloads larger than this go over tcp, zero disables tcp")
+	fs.BoolVar(&c.Encrypt, "encrypt", c.Encrypt, "encrypt udp traffic, all peers have to enable it, disables tcp")
+	fs.StringVar(&c.Admin, "admin", c.Admin, "admin http endpoint address, host:port or unix:<path>")
+	fs.BoolVar(&c.AdminRemote, "admin-remote", c.AdminRemote, "allow admin endpoint on non-loopback address, it has no authentication")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "log level: debug, info, warn or error")
+	fs.DurationVar(&c.ShutdownTimeout.Duration, "shutdown-timeout", c.ShutdownTimeout.Duration, "graceful shutdown timeout")
+}
+
+// parseConfig applies config file on top of defaults and explicitly set flags on top of config file.
+func parseConfig(args []string) (*Config, error) {
+	config := defaultConfig()
+	fs := flag.NewFlagSet("peerd", flag.ContinueOnError)
+	configPath := fs.String("config", "", "json config file")
+	bindFlags(fs, config)
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	if *configPath == "" {
+		return config, config.validate()
+	}
+
+	data, err := ioutil.ReadFile(*configPath)
+	if err != nil {
+		return nil, err
+	}
+	fileConfig := defaultConfig()
+	err = json.Unmarshal(data, fileConfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file: %v", err)
+	}
+	fileFlags := flag.NewFlagSet("peerd", flag.ContinueOnError)
+	bindFlags(fileFlags, fileConfig)
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "config" && err == nil {
+			err = fileFlags.Set(f.Name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fileConfig, fileConfig.validate()
+}
+
+func (c *Config) validate() error {
+	if c.K < 1 || c.B < 1 || c.Alpha < 1 {
+		return errors.New("k, b and alpha must be positive")
+	}
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if c.TcpThreshold < 0 {
+		return errors.New("tcp threshold must not be negative")
+	}
+	if c.Snapshot != "" && c.SnapshotInterval.Duration <= 0 {
+		return errors.New("snapshot interval must be positive")
+	}
+	return nil
+}
+
+func nodeIdentity(source string) (*dht.Identity, error) {
+	kind, arg := source, ""
+	if i := strings.Index(source, ":"); i > -1 {
+		kind, arg = source[:i], source[i+1:]
+	}
+	switch kind {
+	case "random":
+		return dht.NewIdentity()
+	case "hex":
+		return hexIdentity(arg)
+	case "file":
+		data, err := ioutil.ReadFile(arg)
+		if err == nil {
+			return hexIdentity(strings.TrimSpace(string(data)))
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		identity, err := dht.NewIdentity()
+		if err != nil {
+			return nil, err
+		}
+		err = ioutil.WriteFile(arg, []byte(hex.EncodeToString(identity.Seed())+"\n"), 0600)
+		return identity, err
+	}
+	return nil, fmt.Errorf("unknown id source: %s", source)
+}
+
+func hexIdentity(s string) (*dht.Identity, error) {
+	seed, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return dht.SeedIdentity(seed)
+}
+
+// openStorage returns storage and function releasing it, corrupted records dropped from log are logged.
+func openStorage(backend string, logger logging.Logger) (store.Storage, func() error, error) {
+	if backend == "memory" {
+		return store.NewMemStorage(), func() error { return nil }, nil
+	}
+	if strings.HasPrefix(backend, "file:") {
+		storage, err := store.NewFileStorage(strings.TrimPrefix(backend, "file:"), store.SyncPeriodic, time.Second)
+		if err != nil {
+			return nil, nil, err
+		}
+		if skipped, truncated := storage.Recovered(); skipped > 0 || truncated > 0 {
+			logger.Log(logging.Warn, "dropped corrupted storage records",
+				logging.F("skipped", skipped), logging.F("truncated", truncated))
+		}
+		return storage, storage.Close, nil
+	}
+	return nil, nil, fmt.Errorf("unknown storage backend: %s", backend)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peerd.json")
+	data := `{"k": 10, "listen": "localhost:5000", "bootstrap": ["a:1", "b:2"], "call_timeout": "2s"}`
+	err := ioutil.WriteFile(path, []byte(data), 0644)
+	if err != nil {
+		t.Fatalf("failed writing config: %v\n", err)
+	}
+
+	config, err := parseConfig([]string{"-config", path, "-listen", "localhost:6000", "-alpha", "5"})
+	if err != nil {
+		t.Fatalf("failed parsing config: %v\n", err)
+	}
+	if config.K != 10 || config.CallTimeout.Duration != 2*time.Second {
+		t.Errorf("config file values not applied: %+v\n", config)
+	}
+	if config.Listen != "localhost:6000" || config.Alpha != 5 {
+		t.Errorf("flags should override config file: %+v\n", config)
+	}
+	if config.B != 5 || config.Storage != "memory" {
+		t.Errorf("defaults should be kept: %+v\n", config)
+	}
+	if !reflect.DeepEqual([]string(config.Bootstrap), []string{"a:1", "b:2"}) {
+		t.Errorf("invalid bootstrap: %v\n", config.Bootstrap)
+	}
+
+	config, err = parseConfig([]string{"-bootstrap", "c:3, d:4", "-k", "0"})
+	if err == nil {
+		t.Errorf("invalid k should be rejected\n")
+	}
+}
+
+func TestNodeIdentity(t *testing.T) {
+	seed := strings.Repeat("0a", 32)
+	identity, err := nodeIdentity("hex:" + seed)
+	if err != nil || hex.EncodeToString(identity.Seed()) != seed {
+		t.Errorf("invalid hex identity: %v\n", err)
+	}
+	_, err = nodeIdentity("hex:0a0b")
+	if err == nil {
+		t.Errorf("short seed should be rejected\n")
+	}
+	_, err = nodeIdentity("unknown")
+	if err == nil {
+		t.Errorf("unknown id source should be rejected\n")
+	}
+
+	path := filepath.Join(t.TempDir(), "id")
+	identity1, err := nodeIdentity("file:" + path)
+	if err != nil {
+		t.Fatalf("failed creating id file: %v\n", err)
+	}
+	identity2, err := nodeIdentity("file:" + path)
+	if err != nil || identity1.Id().Cmp(identity2.Id()) != 0 {
+		t.Errorf("identity should be persisted: %x != %x, %v\n", identity1.Id(), identity2.Id(), err)
+	}
+}
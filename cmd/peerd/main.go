@@ -0,0 +1,167 @@
+// Command peerd runs a Kademlia node over udp.
+//
+// Usage:
+//
+//	peerd -listen localhost:4001 -bootstrap localhost:4000 -storage file:peerd.log
+//
+// Settings may also be read from json file passed with -config, flags given
+// explicitly take precedence over the file.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/mduszyk/gopeers/admin"
+	"github.com/mduszyk/gopeers/dht"
+	"github.com/mduszyk/gopeers/logging"
+	"github.com/mduszyk/gopeers/metrics"
+	"github.com/mduszyk/gopeers/rpc"
+	"github.com/mduszyk/gopeers/store"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// bounds network operations requested through admin endpoint
+const adminTimeout = 30 * time.Second
+
+func main() {
+	config, err := parseConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	err = run(config)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+func run(config *Config) error {
+	identity, err := nodeIdentity(config.Id)
+	if err != nil {
+		return err
+	}
+	id := identity.Id()
+	level, _ := logging.ParseLevel(config.LogLevel)
+	logger := logging.NewStdLogger(log.New(os.Stderr, "", log.LstdFlags), level)
+	storage, closeStorage, err := openStorage(config.Storage, logger)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	registry := metrics.NewRegistry()
+	dhtNode := dht.NewKadNode(config.K, config.B, config.Alpha, id, store.NewMeteredStorage(storage, registry))
+	dhtNode.SetMetrics(registry)
+	dhtNode.Logger = logger
+	rpcNode, err := rpc.NewUdpNode(config.Listen, nil, config.CallTimeout.Duration, uint32(config.ReadBufferSize))
+	if err != nil {
+		return err
+	}
+	rpcNode.SetMetrics(registry)
+	rpcNode.Logger = logger
+	if config.Encrypt {
+		rpcNode.EnableEncryption(identity.PrivateKey)
+	}
+	node, err := dht.NewUdpProtocolNode(rpcNode, dhtNode, identity)
+	if err != nil {
+		rpcNode.Close()
+		return err
+	}
+	if config.TcpThreshold > 0 && !config.Encrypt {
+		tcpNode, err := rpc.NewTcpNode(rpcNode.Addr.String(), nil, config.CallTimeout.Duration)
+		if err != nil {
+			rpcNode.Close()
+			return err
+		}
+		tcpNode.SetMetrics(registry)
+		tcpNode.Logger = logger
+		node.EnableTcp(tcpNode, config.TcpThreshold)
+		go tcpNode.Run()
+	}
+	go rpcNode.Run()
+	dhtNode.Start()
+	logger.Log(logging.Info, "node started", logging.F("id", fmt.Sprintf("%040x", id)), logging.F("addr", rpcNode.Addr))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var adminServer *http.Server
+	if config.Admin != "" {
+		listener, err := admin.Listen(config.Admin, config.AdminRemote)
+		if err != nil {
+			node.Close()
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/", admin.NewHandler(node, adminTimeout))
+		mux.Handle("/metrics", registry.Handler())
+		adminServer = &http.Server{Handler: mux}
+		go adminServer.Serve(listener)
+		logger.Log(logging.Info, "admin endpoint listening", logging.F("addr", listener.Addr()))
+	}
+
+	restored := 0
+	if config.Snapshot != "" {
+		restored, err = node.RestoreFile(ctx, config.Snapshot)
+		if err != nil && !os.IsNotExist(err) {
+			logger.Log(logging.Warn, "failed restoring routing table", logging.F("error", err))
+		}
+		logger.Log(logging.Info, "routing table restored", logging.F("peers", restored), logging.F("path", config.Snapshot))
+		node.StartSnapshots(config.Snapshot, config.SnapshotInterval.Duration)
+	}
+
+	joined := 0
+	for _, address := range config.Bootstrap {
+		addr, err := net.ResolveUDPAddr("udp", address)
+		if err == nil {
+			var peer *dht.Peer
+			peer, err = node.Discover(ctx, addr)
+			if err == nil {
+				err = node.KadNode().JoinContext(ctx, peer)
+			}
+		}
+		if err != nil {
+			logger.Log(logging.Warn, "failed joining", logging.F("addr", address), logging.F("error", err))
+			continue
+		}
+		joined++
+	}
+
+	err = nil
+	if len(config.Bootstrap) > 0 && joined == 0 && restored == 0 && ctx.Err() == nil {
+		err = errors.New("failed joining network")
+	} else {
+		<-ctx.Done()
+		logger.Log(logging.Info, "shutting down")
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), config.ShutdownTimeout.Duration)
+	defer shutdownCancel()
+	if adminServer != nil {
+		_ = adminServer.Shutdown(shutdownCtx)
+	}
+	shutdownErr := node.Shutdown(shutdownCtx)
+	if err != nil {
+		return err
+	}
+	return shutdownErr
+}
@@ -0,0 +1,96 @@
+// Command peersim runs a scenario on simulated network and prints the report,
+// it helps choosing k, b and alpha.
+//
+// Usage:
+//
+//	peersim -nodes 1000 -k 20 -alpha 3 -loss 0.01 -churn 1h:100:100,1h:100:100
+//
+// Churn steps are given as after:leave:join, see sim.Churn.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/mduszyk/gopeers/sim"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	var s sim.Scenario
+	var churn string
+	fs := flag.NewFlagSet("peersim", flag.ContinueOnError)
+	fs.IntVar(&s.Nodes, "nodes", 1000, "number of bootstrapped nodes")
+	fs.IntVar(&s.K, "k", 20, "bucket size and replication factor")
+	fs.IntVar(&s.B, "b", 5, "buckets far from own id split until depth is a multiple of b")
+	fs.IntVar(&s.Alpha, "alpha", 3, "lookup parallelism")
+	fs.IntVar(&s.Keys, "keys", 100, "number of stored values")
+	fs.DurationVar(&s.TTL, "ttl", 24*time.Hour, "ttl of stored values")
+	fs.IntVar(&s.Gets, "gets", 1000, "number of value lookups")
+	fs.IntVar(&s.Lookups, "lookups", 100, "number of node lookups")
+	fs.DurationVar(&s.Config.Latency, "latency", 50*time.Millisecond, "one way latency")
+	fs.DurationVar(&s.Config.Jitter, "jitter", 50*time.Millisecond, "random extra latency")
+	fs.Float64Var(&s.Config.Loss, "loss", 0, "probability of losing a message")
+	fs.DurationVar(&s.Config.Timeout, "timeout", time.Second, "rpc call timeout")
+	fs.Int64Var(&s.Config.Seed, "seed", 1, "random seed")
+	fs.StringVar(&churn, "churn", "", "comma separated churn steps after:leave:join")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	s.Churn, err = parseChurn(churn)
+	if err != nil {
+		return err
+	}
+	if s.Nodes < 1 || s.K < 1 || s.B < 1 || s.Alpha < 1 {
+		return fmt.Errorf("nodes, k, b and alpha must be positive")
+	}
+
+	start := time.Now()
+	report, err := sim.Run(context.Background(), s)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(out, report)
+	fmt.Fprintf(out, "elapsed: %v\n", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func parseChurn(s string) ([]sim.Churn, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var steps []sim.Churn
+	for _, step := range strings.Split(s, ",") {
+		parts := strings.Split(step, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid churn step: %s", step)
+		}
+		after, err := time.ParseDuration(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid churn step: %s", step)
+		}
+		leave, err1 := strconv.Atoi(parts[1])
+		join, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil || leave < 0 || join < 0 {
+			return nil, fmt.Errorf("invalid churn step: %s", step)
+		}
+		steps = append(steps, sim.Churn{After: after, Leave: leave, Join: join})
+	}
+	return steps, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"github.com/mduszyk/gopeers/sim"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseChurn(t *testing.T) {
+	steps, err := parseChurn("1h:10:5,30m:0:20")
+	if err != nil {
+		t.Fatalf("failed parsing churn: %v\n", err)
+	}
+	expected := []sim.Churn{{After: time.Hour, Leave: 10, Join: 5}, {After: 30 * time.Minute, Join: 20}}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("invalid churn: %v, expected: %v\n", steps, expected)
+	}
+	for _, invalid := range []string{"1h:10", "x:1:1", "1h:-1:0", "1h:1:a"} {
+		if _, err := parseChurn(invalid); err == nil {
+			t.Errorf("invalid churn should be rejected: %s\n", invalid)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	var out bytes.Buffer
+	err := run([]string{"-nodes", "50", "-k", "5", "-keys", "5", "-gets", "10", "-lookups", "5", "-churn", "1h:5:5"}, &out)
+	if err != nil {
+		t.Fatalf("run failed: %v\n", err)
+	}
+	for _, line := range []string{"nodes: 55 online: 50", "gets: 10", "table completeness:"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("missing %q in:\n%s\n", line, out.String())
+		}
+	}
+}
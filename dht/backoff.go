@@ -0,0 +1,79 @@
+package dht
+
+import (
+	"context"
+	"time"
+)
+
+type peerFailures struct {
+	count int
+	until time.Time
+}
+
+func backoff(base, max time.Duration, failures int) time.Duration {
+	d := base
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// markFailure records failed rpc and returns true if peer became stale.
+func (node *KadNode) markFailure(peer *Peer) bool {
+	key := string(peer.Id.Bytes())
+	node.failuresMutex.Lock()
+	defer node.failuresMutex.Unlock()
+	f, ok := node.failures[key]
+	if !ok {
+		f = &peerFailures{}
+		node.failures[key] = f
+	}
+	f.count++
+	f.until = node.Clock().Add(backoff(node.BackoffBase, node.BackoffMax, f.count))
+	if f.count >= node.StaleFailures {
+		delete(node.failures, key)
+		return true
+	}
+	return false
+}
+
+func (node *KadNode) rpcFailed(peer *Peer) {
+	if node.markFailure(peer) {
+		node.evict(peer)
+	}
+}
+
+func (node *KadNode) rpcSucceeded(peer *Peer) {
+	node.failuresMutex.Lock()
+	delete(node.failures, string(peer.Id.Bytes()))
+	node.failuresMutex.Unlock()
+}
+
+func (node *KadNode) backedOff(peer *Peer, now time.Time) bool {
+	node.failuresMutex.Lock()
+	defer node.failuresMutex.Unlock()
+	f, ok := node.failures[string(peer.Id.Bytes())]
+	return ok && now.Before(f.until)
+}
+
+// pruneFailures forgets peers whose backoff elapsed longer than BackoffMax ago,
+// peers failing again right after backoff keep their count and become stale,
+// while peers which are never contacted again don't stay in the map forever.
+func (node *KadNode) pruneFailures(now time.Time) {
+	node.failuresMutex.Lock()
+	for key, f := range node.failures {
+		if now.Sub(f.until) > node.BackoffMax {
+			delete(node.failures, key)
+		}
+	}
+	node.failuresMutex.Unlock()
+}
+
+// seen adds sender of incoming request, receiving a request proves peer is alive.
+func (node *KadNode) seen(ctx context.Context, sender *Peer) {
+	node.rpcSucceeded(sender)
+	node.addContext(ctx, sender)
+}
@@ -0,0 +1,140 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"github.com/mduszyk/gopeers/store"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type unresponsiveProtocol struct {
+	Protocol
+	calls int32
+}
+
+func (p *unresponsiveProtocol) PingContext(ctx context.Context, sender *Peer, randomId Id) (Id, error) {
+	atomic.AddInt32(&p.calls, 1)
+	return nil, errors.New("ping timeout")
+}
+
+func (p *unresponsiveProtocol) FindNodeContext(ctx context.Context, sender *Peer, id Id) (*FindResult, error) {
+	atomic.AddInt32(&p.calls, 1)
+	return nil, errors.New("find node timeout")
+}
+
+func TestBackoff(t *testing.T) {
+	base := time.Second
+	max := time.Minute
+	expected := []time.Duration{base, 2 * base, 4 * base, 8 * base, 16 * base, 32 * base, max, max}
+	for i, d := range expected {
+		if b := backoff(base, max, i + 1); b != d {
+			t.Errorf("got invalid backoff for %d failures: %v\n", i + 1, b)
+		}
+	}
+}
+
+func TestStalePeerEviction(t *testing.T) {
+	node := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	node.StaleFailures = 3
+	peer := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage()).Peer
+	node.add(peer)
+
+	node.rpcFailed(peer)
+	if !node.backedOff(peer, time.Now()) {
+		t.Errorf("failed peer should be backed off\n")
+	}
+	node.rpcSucceeded(peer)
+	if node.backedOff(peer, time.Now()) {
+		t.Errorf("responding peer should not be backed off\n")
+	}
+
+	for i := 0; i < node.StaleFailures - 1; i++ {
+		node.rpcFailed(peer)
+		if !node.Tree.Find(peer.Id).Bucket.Contains(peer.Id) {
+			t.Errorf("peer should not be removed before becoming stale\n")
+		}
+	}
+	node.rpcFailed(peer)
+	if node.Tree.Find(peer.Id).Bucket.Contains(peer.Id) {
+		t.Errorf("stale peer should be removed\n")
+	}
+}
+
+func TestUnresponsiveLeastSeen(t *testing.T) {
+	k := 2
+	half := new(big.Int).Rsh(maxId, 1)
+	node := NewKadNode(k, 1, 3, big.NewInt(0), store.NewMemStorage())
+	node.StaleFailures = 3
+	node.BackoffBase = 0
+	proto := &unresponsiveProtocol{}
+	unresponsive := &Peer{half, proto, time.Now()}
+	node.add(unresponsive)
+	node.add(NewKadNode(k, 1, 3, new(big.Int).Add(half, big.NewInt(1)), store.NewMemStorage()).Peer)
+
+	for i := 0; i < node.StaleFailures - 1; i++ {
+		newcomer := &Peer{new(big.Int).Add(half, big.NewInt(int64(2 + i))), nil, time.Now()}
+		if node.add(newcomer) {
+			t.Errorf("newcomer should not replace peer before it is stale\n")
+		}
+		if !node.Tree.Find(half).Bucket.Contains(half) {
+			t.Errorf("unresponsive peer should be kept until stale\n")
+		}
+	}
+	newcomer := &Peer{new(big.Int).Add(half, big.NewInt(100)), nil, time.Now()}
+	if !node.add(newcomer) {
+		t.Errorf("newcomer should replace stale peer\n")
+	}
+	if node.Tree.Find(half).Bucket.Contains(half) {
+		t.Errorf("stale peer should be removed\n")
+	}
+	if calls := atomic.LoadInt32(&proto.calls); calls != int32(node.StaleFailures) {
+		t.Errorf("got invalid number of pings: %d\n", calls)
+	}
+}
+
+func TestLookupSkipsBackedOffPeers(t *testing.T) {
+	node1 := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	node2 := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	proto := &unresponsiveProtocol{}
+	unresponsive := &Peer{MathRandId(), proto, time.Now()}
+	node1.add(node2.Peer)
+	node1.add(unresponsive)
+
+	_, err := node1.Lookup(MathRandId(), false)
+	if err != nil {
+		t.Errorf("lookup failed: %v\n", err)
+	}
+	if calls := atomic.LoadInt32(&proto.calls); calls != 1 {
+		t.Errorf("unresponsive peer should be queried once, got: %d\n", calls)
+	}
+	if !node1.Tree.Find(unresponsive.Id).Bucket.Contains(unresponsive.Id) {
+		t.Errorf("single failure should not remove peer\n")
+	}
+
+	_, err = node1.Lookup(MathRandId(), false)
+	if err != nil {
+		t.Errorf("lookup failed: %v\n", err)
+	}
+	if calls := atomic.LoadInt32(&proto.calls); calls != 1 {
+		t.Errorf("backed off peer should be skipped, got: %d\n", calls)
+	}
+}
+
+func TestPruneFailures(t *testing.T) {
+	node := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	peer := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage()).Peer
+	node.rpcFailed(peer)
+
+	now := time.Now()
+	node.sweep(context.Background(), now.Add(node.BackoffMax))
+	if !node.backedOff(peer, now) {
+		t.Errorf("failure should be kept within BackoffMax after backoff\n")
+	}
+	node.sweep(context.Background(), now.Add(node.BackoffBase + 2 * node.BackoffMax))
+	if len(node.failures) != 0 {
+		t.Errorf("failure of peer not contacted again should be pruned\n")
+	}
+}
@@ -2,17 +2,21 @@ package dht
 
 import (
 	"math/big"
+	"time"
 )
 
 type bucket struct {
 	k, depth int
 	lo, hi Id
 	peers []*Peer
+	// recently seen peers which didn't fit into full bucket, most recent last
+	replacements []*Peer
+	lastLookup time.Time
 }
 
 func NewBucket(k, depth int, lo Id, hi Id) *bucket {
 	peers := make([]*Peer, 0, k)
-	return &bucket{k:k, depth: depth, lo: lo, hi: hi, peers: peers}
+	return &bucket{k:k, depth: depth, lo: lo, hi: hi, peers: peers, lastLookup: time.Now()}
 }
 
 func (b *bucket) inRange(id Id) bool {
@@ -57,6 +61,9 @@ func (b *bucket) split() (*bucket, *bucket) {
 	middle := new(big.Int).Div(new(big.Int).Add(b.lo, b.hi), big.NewInt(2))
 	b1 := NewBucket(b.k, b.depth + 1, b.lo, middle)
 	b2 := NewBucket(b.k, b.depth + 1, middle, b.hi)
+	// split doesn't count as lookup
+	b1.lastLookup = b.lastLookup
+	b2.lastLookup = b.lastLookup
 	for _, peer := range b.peers {
 		if b1.inRange(peer.Id) {
 			b1.add(peer)
@@ -64,9 +71,50 @@ func (b *bucket) split() (*bucket, *bucket) {
 			b2.add(peer)
 		}
 	}
+	for _, peer := range b.replacements {
+		if b1.inRange(peer.Id) {
+			b1.addReplacement(peer)
+		} else {
+			b2.addReplacement(peer)
+		}
+	}
 	return b1, b2
 }
 
+func (b *bucket) findReplacement(id Id) int {
+	for i, peer := range b.replacements {
+		if eq(id, peer.Id) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (b *bucket) addReplacement(peer *Peer) {
+	if i := b.findReplacement(peer.Id); i > -1 {
+		b.removeReplacement(i)
+	} else if len(b.replacements) >= b.k {
+		b.removeReplacement(0)
+	}
+	b.replacements = append(b.replacements, peer)
+}
+
+func (b *bucket) removeReplacement(i int) {
+	b.replacements = append(b.replacements[:i], b.replacements[i+1:]...)
+}
+
+// promote moves most recently seen replacement into the bucket.
+func (b *bucket) promote() *Peer {
+	n := len(b.replacements)
+	if n == 0 || b.isFull() {
+		return nil
+	}
+	peer := b.replacements[n-1]
+	b.replacements = b.replacements[:n-1]
+	b.add(peer)
+	return peer
+}
+
 func (b *bucket) leastSeen() (int, *Peer) {
 	if len(b.peers) > 0 {
 		return 0, b.peers[0]
@@ -74,6 +122,14 @@ func (b *bucket) leastSeen() (int, *Peer) {
 	return -1, nil
 }
 
+func (b *bucket) touch(now time.Time) {
+	b.lastLookup = now
+}
+
+func (b *bucket) idle(now time.Time, interval time.Duration) bool {
+	return now.Sub(b.lastLookup) >= interval
+}
+
 func (b *bucket) closest(id Id, n int) []*Peer {
 	peers := make([]*Peer, len(b.peers))
 	copy(peers, b.peers)
@@ -173,3 +173,45 @@ func TestSplit(t *testing.T) {
 		}
 	}
 }
+
+func TestReplacements(t *testing.T) {
+	k := 2
+	bucket := NewBucket(k, 0, big.NewInt(0), maxId)
+	peers := make([]*Peer, 3)
+	for i := range peers {
+		peers[i] = &Peer{big.NewInt(int64(i)), nil, time.Now()}
+		bucket.addReplacement(peers[i])
+	}
+	if len(bucket.replacements) != k {
+		t.Errorf("replacement cache should be bounded by k\n")
+	}
+	if bucket.findReplacement(peers[0].Id) > -1 {
+		t.Errorf("oldest replacement should be dropped\n")
+	}
+	bucket.addReplacement(peers[1])
+	if len(bucket.replacements) != k || !eq(bucket.replacements[k-1].Id, peers[1].Id) {
+		t.Errorf("seen replacement should become most recent\n")
+	}
+	promoted := bucket.promote()
+	if promoted == nil || !eq(promoted.Id, peers[1].Id) {
+		t.Errorf("most recently seen replacement should be promoted\n")
+	}
+	if !bucket.Contains(peers[1].Id) || bucket.findReplacement(peers[1].Id) > -1 {
+		t.Errorf("promoted peer should move into bucket\n")
+	}
+}
+
+func TestSplitReplacements(t *testing.T) {
+	bucket := NewBucket(20, 0, big.NewInt(0), maxId)
+	lo := &Peer{big.NewInt(1), nil, time.Now()}
+	hi := &Peer{new(big.Int).Sub(maxId, big.NewInt(1)), nil, time.Now()}
+	bucket.addReplacement(lo)
+	bucket.addReplacement(hi)
+	b1, b2 := bucket.split()
+	if b1.findReplacement(lo.Id) < 0 || b2.findReplacement(hi.Id) < 0 {
+		t.Errorf("replacements should be split by range\n")
+	}
+	if len(b1.replacements) != 1 || len(b2.replacements) != 1 {
+		t.Errorf("replacements should not be duplicated\n")
+	}
+}
@@ -0,0 +1,57 @@
+package dht
+
+import (
+	"context"
+	"github.com/mduszyk/gopeers/logging"
+)
+
+// cacheInBackground caches found value without delaying the result, caching is best-effort
+// and it gets its own timeout instead of the context of the get. Stop and Wait wait for it.
+func (node *KadNode) cacheInBackground(key Id, result *FindResult, queried []*Peer) {
+	// adding under the mutex keeps Add from racing with Wait of Stop
+	node.runMutex.Lock()
+	node.tasks.Add(1)
+	node.runMutex.Unlock()
+	go func() {
+		defer node.tasks.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), node.CacheTimeout)
+		defer cancel()
+		node.cache(ctx, key, result, queried)
+	}()
+}
+
+// cache stores found value at the closest queried peer which didn't return it,
+// the cached copy expires sooner the more peers are closer to the key.
+func (node *KadNode) cache(ctx context.Context, key Id, result *FindResult, queried []*Peer) {
+	if len(queried) == 0 {
+		return
+	}
+	target := queried[0]
+	for _, peer := range queried[1:] {
+		if lt(xor(key, peer.Id), xor(key, target.Id)) {
+			target = peer
+		}
+	}
+
+	var closer int
+	if result.holder != nil {
+		closer = node.closerPeers(key, target.Id, result.holder)
+	} else {
+		closer = node.closerPeers(key, target.Id)
+	}
+	ttl := distanceScaledTTL(node.CacheTTL, closer, 1)
+	if result.ttl > 0 && result.ttl < ttl {
+		ttl = result.ttl
+	}
+
+	err := target.Proto.StoreContext(ctx, node.Peer, key, result.value, ttl)
+	if err != nil {
+		node.Logger.Log(logging.Warn, "cache failed",
+			logging.F("peer", hexId(target.Id)), logging.F("key", hexId(key)), logging.F("error", err))
+		if ctx.Err() == nil {
+			node.rpcFailed(target)
+		}
+		return
+	}
+	node.rpcSucceeded(target)
+}
@@ -0,0 +1,102 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	nodes := joinedNodes(t, 30, 5)
+	key := MathRandId()
+	value := []byte("cached")
+	peers := []*Peer{nodes[1].Peer, nodes[2].Peer, nodes[3].Peer}
+	sortByDistance(peers, key)
+	holder, near, far := peers[0].Proto.(*KadNode), peers[1].Proto.(*KadNode), peers[2].Proto.(*KadNode)
+
+	result := &FindResult{value: value, holder: holder.Peer}
+	nodes[0].cache(context.Background(), key, result, []*Peer{far.Peer, near.Peer})
+
+	if _, err := far.Storage.Get(key.Bytes()); err == nil {
+		t.Errorf("value should be cached only at closest queried peer\n")
+	}
+	cached, err := near.Storage.Get(key.Bytes())
+	if err != nil || string(cached) != string(value) {
+		t.Errorf("value should be cached at closest queried peer\n")
+	}
+	expiry, err := near.Storage.Expiry(key.Bytes())
+	if err != nil || expiry.IsZero() {
+		t.Errorf("cached value should expire\n")
+	}
+	if d := time.Until(expiry); d > nodes[0].CacheTTL / 2 {
+		t.Errorf("cached value ttl should be scaled by distance, got: %v\n", d)
+	}
+}
+
+func TestCacheValueTTL(t *testing.T) {
+	nodes := joinedNodes(t, 10, 5)
+	key := MathRandId()
+	ttl := time.Minute
+
+	result := &FindResult{value: []byte("cached"), holder: nodes[1].Peer, ttl: ttl}
+	nodes[0].cache(context.Background(), key, result, []*Peer{nodes[2].Peer})
+
+	expiry, err := nodes[2].Storage.Expiry(key.Bytes())
+	if err != nil {
+		t.Errorf("value should be cached: %v\n", err)
+	}
+	if d := time.Until(expiry); d > ttl {
+		t.Errorf("cached value should not outlive original, got: %v\n", d)
+	}
+}
+
+// blockingStore never completes store until context of the call is done.
+type blockingStore struct {
+	*KadNode
+	done chan error
+}
+
+func (b *blockingStore) StoreContext(ctx context.Context, sender *Peer, key Id, value []byte, ttl time.Duration) error {
+	<-ctx.Done()
+	b.done <- ctx.Err()
+	return ctx.Err()
+}
+
+func TestCacheInBackground(t *testing.T) {
+	nodes := joinedNodes(t, 2, 5)
+	nodes[0].CacheTimeout = 50 * time.Millisecond
+	target := &blockingStore{nodes[1], make(chan error, 1)}
+	peer := &Peer{nodes[1].Peer.Id, target, time.Now()}
+
+	result := &FindResult{value: []byte("cached")}
+	start := time.Now()
+	nodes[0].cacheInBackground(MathRandId(), result, []*Peer{peer})
+	if time.Since(start) >= nodes[0].CacheTimeout {
+		t.Errorf("caching should not block caller\n")
+	}
+	nodes[0].Stop()
+	select {
+	case err := <-target.done:
+		if err != context.DeadlineExceeded {
+			t.Errorf("caching should be cut by its own timeout, got: %v\n", err)
+		}
+	default:
+		t.Errorf("stop should wait for caching\n")
+	}
+}
+
+func TestFindValueTTL(t *testing.T) {
+	nodes := joinedNodes(t, 2, 5)
+	key := MathRandId()
+	err := nodes[0].Store(nodes[1].Peer, key, []byte("value"), time.Hour)
+	if err != nil {
+		t.Errorf("failed storing value: %v\n", err)
+	}
+	result, err := nodes[0].FindValue(nodes[1].Peer, key)
+	if err != nil {
+		t.Errorf("failed finding value: %v\n", err)
+	}
+	if result.ttl <= 0 || result.ttl > time.Hour {
+		t.Errorf("got invalid ttl: %v\n", result.ttl)
+	}
+}
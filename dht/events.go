@@ -0,0 +1,76 @@
+package dht
+
+import (
+	"time"
+)
+
+type EventType int
+
+const (
+	PeerAdded EventType = iota
+	PeerRemoved
+	BucketSplit
+	ValueStored
+	ValueExpired
+)
+
+var eventTypeNames = []string{"PeerAdded", "PeerRemoved", "BucketSplit", "ValueStored", "ValueExpired"}
+
+func (t EventType) String() string {
+	if t >= PeerAdded && t <= ValueExpired {
+		return eventTypeNames[t]
+	}
+	return "EventType(?)"
+}
+
+type Event struct {
+	Type EventType
+	Time time.Time
+	// added or removed peer, sender of stored value
+	Peer *Peer
+	// range of bucket which was split
+	Lo, Hi Id
+	// stored or expired key
+	Key Id
+}
+
+type subscribers struct {
+	next     int
+	channels map[int]chan Event
+}
+
+// Subscribe returns channel receiving node events and function cancelling the subscription.
+// Events are dropped when channel buffer is full, so slow subscriber doesn't block the node.
+func (node *KadNode) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	node.subscribersMutex.Lock()
+	id := node.subscribers.next
+	node.subscribers.next++
+	if node.subscribers.channels == nil {
+		node.subscribers.channels = make(map[int]chan Event)
+	}
+	node.subscribers.channels[id] = ch
+	node.subscribersMutex.Unlock()
+
+	unsubscribe := func() {
+		node.subscribersMutex.Lock()
+		defer node.subscribersMutex.Unlock()
+		if _, ok := node.subscribers.channels[id]; ok {
+			delete(node.subscribers.channels, id)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe
+}
+
+func (node *KadNode) emit(event Event) {
+	event.Time = node.Clock()
+	node.subscribersMutex.RLock()
+	defer node.subscribersMutex.RUnlock()
+	for _, ch := range node.subscribers.channels {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package dht
+
+import (
+	"context"
+	"github.com/mduszyk/gopeers/store"
+	"testing"
+	"time"
+)
+
+func drain(events <-chan Event) []Event {
+	var received []Event
+	for {
+		select {
+		case event := <-events:
+			received = append(received, event)
+		default:
+			return received
+		}
+	}
+}
+
+func countEvents(events []Event, eventType EventType) int {
+	count := 0
+	for _, event := range events {
+		if event.Type == eventType {
+			count++
+		}
+	}
+	return count
+}
+
+func TestPeerEvents(t *testing.T) {
+	node := NewKadNode(2, 5, 3, MathRandId(), store.NewMemStorage())
+	events, unsubscribe := node.Subscribe(100)
+	defer unsubscribe()
+
+	peer := NewKadNode(2, 5, 3, MathRandId(), store.NewMemStorage()).Peer
+	node.add(peer)
+	received := drain(events)
+	if len(received) != 1 || received[0].Type != PeerAdded || !eq(received[0].Peer.Id, peer.Id) {
+		t.Errorf("expected peer added event, got: %v\n", received)
+	}
+
+	for i := 0; i < 10; i++ {
+		node.add(NewKadNode(2, 5, 3, MathRandId(), store.NewMemStorage()).Peer)
+	}
+	if countEvents(drain(events), BucketSplit) == 0 {
+		t.Errorf("adding peers should emit bucket split\n")
+	}
+
+	node.evict(peer)
+	received = drain(events)
+	if countEvents(received, PeerRemoved) != 1 || !eq(received[0].Peer.Id, peer.Id) {
+		t.Errorf("expected peer removed event, got: %v\n", received)
+	}
+}
+
+func TestValueEvents(t *testing.T) {
+	node1 := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	node2 := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	events, unsubscribe := node1.Subscribe(10)
+	defer unsubscribe()
+	key := MathRandId()
+
+	err := node1.Store(node2.Peer, key, []byte("short"), time.Millisecond)
+	if err != nil {
+		t.Errorf("failed storing value: %v\n", err)
+	}
+	node1.sweep(context.Background(), time.Now().Add(time.Second))
+	received := drain(events)
+	if countEvents(received, ValueStored) != 1 || countEvents(received, ValueExpired) != 1 {
+		t.Fatalf("expected stored and expired events, got: %v\n", received)
+	}
+	for _, event := range received {
+		if (event.Type == ValueStored || event.Type == ValueExpired) && !eq(event.Key, key) {
+			t.Errorf("invalid event key: %v\n", event)
+		}
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	node := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	events, unsubscribe := node.Subscribe(1)
+	// full buffer should not block the node
+	node.add(NewPeer(MathRandId()))
+	node.add(NewPeer(MathRandId()))
+	unsubscribe()
+	unsubscribe()
+	node.add(NewPeer(MathRandId()))
+	count := 0
+	for range events {
+		count++
+	}
+	if count != 1 {
+		t.Errorf("expected 1 buffered event, got: %d\n", count)
+	}
+}
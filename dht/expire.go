@@ -0,0 +1,61 @@
+package dht
+
+import (
+	"context"
+	"github.com/mduszyk/gopeers/logging"
+	"time"
+)
+
+// distanceScaledTTL halves ttl for every peer beyond k which is closer to
+// the key, so that copies stored far from the key expire sooner.
+func distanceScaledTTL(ttl time.Duration, closer, k int) time.Duration {
+	if closer < k {
+		return ttl
+	}
+	shift := uint(closer - k + 1)
+	if shift >= 63 {
+		return 1
+	}
+	scaled := ttl >> shift
+	if scaled <= 0 {
+		return 1
+	}
+	return scaled
+}
+
+// closerPeers counts known and extra peers closer to key than given id.
+func (node *KadNode) closerPeers(key Id, id Id, extra ...*Peer) int {
+	node.Tree.mutex.RLock()
+	peers := node.Tree.closest(key, 2 * node.k)
+	node.Tree.mutex.RUnlock()
+	d := xor(key, id)
+	counted := make(map[string]bool)
+	for _, peer := range append(peers, extra...) {
+		if lt(xor(key, peer.Id), d) {
+			counted[string(peer.Id.Bytes())] = true
+		}
+	}
+	return len(counted)
+}
+
+func (node *KadNode) scaleTTL(key Id, ttl time.Duration) time.Duration {
+	return distanceScaledTTL(ttl, node.closerPeers(key, node.Peer.Id), node.k)
+}
+
+func (node *KadNode) sweep(_ context.Context, now time.Time) {
+	node.expireProviders(now)
+	node.pruneFailures(now)
+	keys, err := node.Storage.Expire(now)
+	if err != nil {
+		node.Logger.Log(logging.Warn, "sweep failed", logging.F("error", err))
+		return
+	}
+	node.keysMutex.Lock()
+	for _, key := range keys {
+		delete(node.stored, string(key))
+	}
+	node.keysMutex.Unlock()
+	for _, key := range keys {
+		node.emit(Event{Type: ValueExpired, Key: BytesId(key)})
+	}
+}
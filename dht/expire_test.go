@@ -0,0 +1,113 @@
+package dht
+
+import (
+	"context"
+	"github.com/mduszyk/gopeers/store"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestDistanceScaledTTL(t *testing.T) {
+	ttl := time.Hour
+	if distanceScaledTTL(ttl, 0, 20) != ttl {
+		t.Errorf("ttl should not be scaled for closest peer\n")
+	}
+	if distanceScaledTTL(ttl, 19, 20) != ttl {
+		t.Errorf("ttl should not be scaled within k closest\n")
+	}
+	if distanceScaledTTL(ttl, 20, 20) != ttl / 2 {
+		t.Errorf("ttl should be halved just beyond k closest\n")
+	}
+	if distanceScaledTTL(ttl, 23, 20) != ttl / 16 {
+		t.Errorf("ttl should decay exponentially\n")
+	}
+	if distanceScaledTTL(ttl, 200, 20) <= 0 {
+		t.Errorf("scaled ttl should be positive\n")
+	}
+}
+
+func TestStoreTTL(t *testing.T) {
+	nodes := joinedNodes(t, 30, 5)
+	node := nodes[0]
+	ttl := time.Hour
+	value := []byte("ttl")
+
+	near := node.Peer.Id
+	err := node.Store(nodes[1].Peer, near, value, ttl)
+	if err != nil {
+		t.Errorf("failed storing value: %v\n", err)
+	}
+	expiry, err := node.Storage.Expiry(near.Bytes())
+	if err != nil {
+		t.Errorf("failed getting expiry: %v\n", err)
+	}
+	if d := time.Until(expiry); d < ttl - time.Minute || d > ttl {
+		t.Errorf("value closest to node should get full ttl, got: %v\n", d)
+	}
+
+	far := xor(node.Peer.Id, maxIdMinusOne())
+	err = node.Store(nodes[1].Peer, far, value, ttl)
+	if err != nil {
+		t.Errorf("failed storing value: %v\n", err)
+	}
+	expiry, err = node.Storage.Expiry(far.Bytes())
+	if err != nil {
+		t.Errorf("failed getting expiry: %v\n", err)
+	}
+	if d := time.Until(expiry); d > ttl / 2 {
+		t.Errorf("value far from node should get scaled ttl, got: %v\n", d)
+	}
+
+	err = node.Store(nodes[1].Peer, MathRandId(), value, 0)
+	if err != nil {
+		t.Errorf("failed storing value: %v\n", err)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	node1 := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	node2 := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	key := MathRandId()
+
+	err := node1.Store(node2.Peer, key, []byte("short"), time.Millisecond)
+	if err != nil {
+		t.Errorf("failed storing value: %v\n", err)
+	}
+	node1.sweep(context.Background(), time.Now().Add(time.Second))
+	if _, err := node1.Storage.Get(key.Bytes()); err == nil {
+		t.Errorf("expired value should be removed\n")
+	}
+	if _, ok := node1.stored[string(key.Bytes())]; ok {
+		t.Errorf("expired key should not be replicated\n")
+	}
+}
+
+func TestRepublishSkipsExpired(t *testing.T) {
+	nodes := joinedNodes(t, 30, 5)
+	key := MathRandId().Bytes()
+	value := []byte("expiring")
+
+	err := nodes[0].SetTTL(key, value, time.Hour)
+	if err != nil {
+		t.Errorf("set failed: %v\n", err)
+	}
+	for _, node := range nodes {
+		if expiry, err := node.Storage.Expiry(key); err == nil && expiry.IsZero() {
+			t.Errorf("stored value should expire\n")
+		}
+		node.Storage = store.NewMemStorage()
+	}
+
+	nodes[0].republish(context.Background(), time.Now().Add(2 * time.Hour))
+	if count := countStored(nodes, key, value); count != 0 {
+		t.Errorf("expired value should not be republished, got: %d\n", count)
+	}
+	if len(nodes[0].published) != 0 {
+		t.Errorf("expired publication should be dropped\n")
+	}
+}
+
+func maxIdMinusOne() Id {
+	return new(big.Int).Sub(maxId, big.NewInt(1))
+}
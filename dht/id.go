@@ -3,6 +3,7 @@ package dht
 import (
 	"crypto/rand"
 	"crypto/sha1"
+	"fmt"
 	"math/big"
 	"math/bits"
 	mathRand "math/rand"
@@ -95,3 +96,7 @@ func lte(a Id, b Id) bool {
 	r := a.Cmp(b)
 	return r == 0 || r == -1
 }
+
+func hexId(id Id) string {
+	return fmt.Sprintf("%040x", id)
+}
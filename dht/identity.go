@@ -0,0 +1,72 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	mathRand "math/rand"
+	"time"
+)
+
+var ErrIdentity = errors.New("peer id doesn't match public key")
+
+// Identity is Ed25519 key pair of a node, id of the node is derived from the public key,
+// so that peers can't claim ids they don't hold keys of.
+type Identity struct {
+	PublicKey  ed25519.PublicKey
+	PrivateKey ed25519.PrivateKey
+}
+
+func NewIdentity() (*Identity, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{publicKey, privateKey}, nil
+}
+
+// SeedIdentity derives identity from ed25519.SeedSize bytes of seed.
+func SeedIdentity(seed []byte) (*Identity, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.New("invalid seed size")
+	}
+	privateKey := ed25519.NewKeyFromSeed(seed)
+	return &Identity{privateKey.Public().(ed25519.PublicKey), privateKey}, nil
+}
+
+// MathRandIdentity is insecure, it is meant for tests and simulations.
+func MathRandIdentity() *Identity {
+	rnd := mathRand.New(mathRand.NewSource(time.Now().UnixNano()))
+	seed := make([]byte, ed25519.SeedSize)
+	rnd.Read(seed)
+	identity, _ := SeedIdentity(seed)
+	return identity
+}
+
+func (i *Identity) Id() Id {
+	return KeyId(i.PublicKey)
+}
+
+func (i *Identity) Seed() []byte {
+	return i.PrivateKey.Seed()
+}
+
+func (i *Identity) Sign(message []byte) []byte {
+	return ed25519.Sign(i.PrivateKey, message)
+}
+
+// KeyId returns id of node holding key.
+func KeyId(key ed25519.PublicKey) Id {
+	return Sha1Id(key)
+}
+
+// Verify checks signature of message and returns id of the signer.
+func Verify(key ed25519.PublicKey, message, signature []byte) (Id, error) {
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid public key")
+	}
+	if !ed25519.Verify(key, message, signature) {
+		return nil, errors.New("invalid signature")
+	}
+	return KeyId(key), nil
+}
@@ -0,0 +1,42 @@
+package dht
+
+import (
+	"testing"
+)
+
+func TestIdentity(t *testing.T) {
+	identity, err := NewIdentity()
+	if err != nil {
+		t.Fatalf("failed generating identity: %v\n", err)
+	}
+	if !eq(identity.Id(), Sha1Id(identity.PublicKey)) {
+		t.Errorf("id should be derived from public key\n")
+	}
+	restored, err := SeedIdentity(identity.Seed())
+	if err != nil || !eq(restored.Id(), identity.Id()) {
+		t.Errorf("identity should be restored from seed: %v\n", err)
+	}
+	_, err = SeedIdentity([]byte("short"))
+	if err == nil {
+		t.Errorf("invalid seed should be rejected\n")
+	}
+
+	message := []byte("message")
+	signature := identity.Sign(message)
+	id, err := Verify(identity.PublicKey, message, signature)
+	if err != nil || !eq(id, identity.Id()) {
+		t.Errorf("failed verifying signature: %v\n", err)
+	}
+	_, err = Verify(identity.PublicKey, []byte("tampered"), signature)
+	if err == nil {
+		t.Errorf("signature of different message should be rejected\n")
+	}
+	_, err = Verify(MathRandIdentity().PublicKey, message, signature)
+	if err == nil {
+		t.Errorf("signature of different key should be rejected\n")
+	}
+	_, err = Verify([]byte("key"), message, signature)
+	if err == nil {
+		t.Errorf("invalid key should be rejected\n")
+	}
+}
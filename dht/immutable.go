@@ -0,0 +1,56 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"github.com/mduszyk/gopeers/logging"
+	"time"
+)
+
+var ErrImmutable = errors.New("value stored under its hash can't be replaced")
+
+// ImmutableKey returns key of content addressed value.
+func ImmutableKey(value []byte) Id {
+	return Sha1Id(value)
+}
+
+// immutable tells whether value is stored under its own hash.
+func immutable(key Id, value []byte) bool {
+	return eq(ImmutableKey(value), key)
+}
+
+func (node *KadNode) PutImmutable(value []byte, ttl time.Duration) (Id, error) {
+	return node.PutImmutableContext(context.Background(), value, ttl)
+}
+
+// PutImmutableContext stores value under its SHA-1 and returns the key, zero ttl means no expiration.
+// Storing nodes refuse to replace it with different value.
+func (node *KadNode) PutImmutableContext(ctx context.Context, value []byte, ttl time.Duration) (Id, error) {
+	key := ImmutableKey(value)
+	node.publish(key, value, ttl)
+	return key, node.storeClosest(ctx, key, value, ttl)
+}
+
+func (node *KadNode) GetImmutable(key Id) ([]byte, error) {
+	return node.GetImmutableContext(context.Background(), key)
+}
+
+// GetImmutableContext returns value hashing to key, values which don't are discarded and lookup continues.
+func (node *KadNode) GetImmutableContext(ctx context.Context, key Id) ([]byte, error) {
+	findResult, queried, err := node.lookup(ctx, key, true, func(value []byte) bool {
+		if immutable(key, value) {
+			return true
+		}
+		node.metrics.invalidValues.Add(1)
+		node.Logger.Log(logging.Warn, "lookup discarded invalid value", logging.F("key", hexId(key)))
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if findResult.value == nil {
+		return nil, ErrNotFound
+	}
+	node.cacheInBackground(key, findResult, queried)
+	return findResult.value, nil
+}
@@ -0,0 +1,62 @@
+package dht
+
+import (
+	"github.com/mduszyk/gopeers/store"
+	"testing"
+)
+
+func TestStoreImmutable(t *testing.T) {
+	node := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	sender := NewKadNode(20, 5, 3, MathRandId(), nil).Peer
+	value := []byte("immutable")
+	key := ImmutableKey(value)
+	if err := node.Store(sender, key, value, 0); err != nil {
+		t.Errorf("failed storing value: %v\n", err)
+	}
+	if err := node.Store(sender, key, value, 0); err != nil {
+		t.Errorf("same value should be stored again: %v\n", err)
+	}
+	if err := node.Store(sender, key, []byte("bogus"), 0); err != ErrImmutable {
+		t.Errorf("value stored under its hash should not be replaced, got: %v\n", err)
+	}
+}
+
+func TestPutGetImmutable(t *testing.T) {
+	nodes := joinedNodes(t, 30, 20)
+	value := []byte("content")
+	key, err := nodes[0].PutImmutable(value, 0)
+	if err != nil {
+		t.Fatalf("failed putting value: %v\n", err)
+	}
+	if !eq(key, Sha1Id(value)) {
+		t.Errorf("key should be hash of the value\n")
+	}
+
+	// closest holders serve bogus data
+	peers := make([]*Peer, len(nodes))
+	for i, node := range nodes {
+		peers[i] = node.Peer
+	}
+	sortByDistance(peers, key)
+	for _, peer := range peers[:3] {
+		err = peer.Proto.(*KadNode).Storage.Set(key.Bytes(), []byte("bogus"))
+		if err != nil {
+			t.Fatalf("failed corrupting value: %v\n", err)
+		}
+	}
+	found, err := peers[len(peers)-1].Proto.(*KadNode).GetImmutable(key)
+	if err != nil || string(found) != string(value) {
+		t.Errorf("value matching key should be found: %q, %v\n", found, err)
+	}
+	// caching runs in background
+	peers[len(peers)-1].Proto.(*KadNode).Wait()
+	healed, err := peers[0].Proto.(*KadNode).Storage.Get(key.Bytes())
+	if err != nil || string(healed) != string(value) {
+		t.Errorf("bogus value should be replaced by cached one: %q, %v\n", healed, err)
+	}
+
+	_, err = nodes[1].GetImmutable(ImmutableKey([]byte("missing")))
+	if err != ErrNotFound {
+		t.Errorf("missing value should not be found, got: %v\n", err)
+	}
+}
@@ -0,0 +1,34 @@
+package dht
+
+import (
+	"github.com/mduszyk/gopeers/metrics"
+)
+
+type kadMetrics struct {
+	lookups        metrics.Counter
+	lookupErrors   metrics.Counter
+	lookupHops     metrics.Histogram
+	lookupDuration metrics.Histogram
+	bucketSplits   metrics.Counter
+	evictions      metrics.Counter
+	promotions     metrics.Counter
+	invalidValues  metrics.Counter
+}
+
+func newKadMetrics(m metrics.Metrics) *kadMetrics {
+	return &kadMetrics{
+		lookups:        m.Counter("dht_lookups_total", "Node and value lookups."),
+		lookupErrors:   m.Counter("dht_lookup_errors_total", "Lookups which failed, including values not found."),
+		lookupHops:     m.Histogram("dht_lookup_hops", "Hops of successful lookups.", metrics.CountBuckets),
+		lookupDuration: m.Histogram("dht_lookup_duration_seconds", "Duration of successful lookups.", metrics.DurationBuckets),
+		bucketSplits:   m.Counter("dht_bucket_splits_total", "Routing table bucket splits."),
+		evictions:      m.Counter("dht_evictions_total", "Peers evicted from routing table."),
+		promotions:     m.Counter("dht_promotions_total", "Replacement peers promoted into routing table."),
+		invalidValues:  m.Counter("dht_invalid_values_total", "Values discarded by lookups because they failed verification."),
+	}
+}
+
+// SetMetrics makes node report into m, it should be called before node is used.
+func (node *KadNode) SetMetrics(m metrics.Metrics) {
+	node.metrics = newKadMetrics(m)
+}
@@ -0,0 +1,50 @@
+package dht
+
+import (
+	"bytes"
+	"github.com/mduszyk/gopeers/metrics"
+	"strings"
+	"testing"
+)
+
+func TestLookupMetrics(t *testing.T) {
+	nodes := joinedNodes(t, 30, 4)
+	registry := metrics.NewRegistry()
+	node := nodes[len(nodes)-1]
+	node.SetMetrics(registry)
+
+	result, err := node.Lookup(MathRandId(), false)
+	if err != nil {
+		t.Fatalf("lookup failed: %v\n", err)
+	}
+	if result.Hops() < 1 {
+		t.Errorf("lookup should take at least one hop, got: %d\n", result.Hops())
+	}
+	_, err = node.Get(MathRandId().Bytes())
+	if err != ErrNotFound {
+		t.Errorf("missing value should not be found, got: %v\n", err)
+	}
+
+	var buf bytes.Buffer
+	registry.WriteText(&buf)
+	for _, line := range []string{"dht_lookups_total 2", "dht_lookup_errors_total 1", "dht_lookup_hops_count 1"} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s\n", line, buf.String())
+		}
+	}
+}
+
+func TestSplitMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	node := NewKadNode(2, 5, 3, MathRandId(), nil)
+	node.SetMetrics(registry)
+	for i := 0; i < 10; i++ {
+		// full bucket pings least seen peer, it has to be reachable
+		node.add(NewKadNode(2, 5, 3, MathRandId(), nil).Peer)
+	}
+	var buf bytes.Buffer
+	registry.WriteText(&buf)
+	if strings.Contains(buf.String(), "dht_bucket_splits_total 0\n") {
+		t.Errorf("adding peers should split buckets:\n%s\n", buf.String())
+	}
+}
@@ -1,33 +1,167 @@
 package dht
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"github.com/mduszyk/gopeers/logging"
+	"github.com/mduszyk/gopeers/metrics"
 	"github.com/mduszyk/gopeers/store"
-	"log"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+var ErrNotFound = errors.New("not found")
+
 type KadNode struct {
 	k, b, alpha    int
 	Peer *Peer
 	Tree *bucketTree
 	Storage store.Storage
+	RefreshInterval time.Duration
+	RefreshHook RefreshHook
+	ReplicateInterval time.Duration
+	RepublishInterval time.Duration
+	SweepInterval time.Duration
+	CacheTTL time.Duration
+	// limits caching store made in background after successful get
+	CacheTimeout time.Duration
+	// providers kept per key and upper bound of announcement ttl
+	MaxProviders int
+	// announcements are rejected when they add key beyond these limits of all keys
+	// and keys provided by single peer
+	MaxProviderKeys int
+	MaxPeerProviderKeys int
+	ProviderTTL time.Duration
+	StaleFailures int
+	BackoffBase time.Duration
+	BackoffMax time.Duration
+	failures map[string]*peerFailures
+	failuresMutex sync.Mutex
+	stored map[string]time.Time
+	published map[string]*publication
+	keysMutex sync.Mutex
+	// serializes checking stored record and replacing it
+	storeMutex sync.Mutex
+	providers map[string][]*provider
+	// number of keys provided by peer
+	provided map[string]int
+	providersMutex sync.Mutex
+	cancel context.CancelFunc
+	loops sync.WaitGroup
+	// background work started by operations, like caching found values
+	tasks sync.WaitGroup
+	runMutex sync.Mutex
+	metrics *kadMetrics
+	Logger logging.Logger
+	// Clock returns current time, simulations replace it with virtual clock.
+	Clock func() time.Time
+	subscribers subscribers
+	subscribersMutex sync.RWMutex
 }
 
 func NewKadNode(k, b, alpha int, id Id, storage store.Storage) *KadNode {
-	node := &KadNode{k: k, b: b, alpha: alpha, Tree: NewBucketTree(k), Storage: storage}
+	node := &KadNode{
+		k: k, b: b, alpha: alpha,
+		Tree: NewBucketTree(k),
+		Storage: storage,
+		RefreshInterval: time.Hour,
+		ReplicateInterval: time.Hour,
+		RepublishInterval: 24 * time.Hour,
+		SweepInterval: time.Minute,
+		CacheTTL: 24 * time.Hour,
+		CacheTimeout: 10 * time.Second,
+		MaxProviders: 20,
+		MaxProviderKeys: 1 << 16,
+		MaxPeerProviderKeys: 1 << 10,
+		ProviderTTL: 24 * time.Hour,
+		StaleFailures: 5,
+		BackoffBase: time.Second,
+		BackoffMax: 10 * time.Minute,
+		failures: make(map[string]*peerFailures),
+		stored: make(map[string]time.Time),
+		published: make(map[string]*publication),
+		providers: make(map[string][]*provider),
+		provided: make(map[string]int),
+		metrics: newKadMetrics(metrics.Nop),
+		Logger: logging.Nop,
+		Clock: time.Now,
+	}
 	node.Peer = &Peer{id, node, time.Now()}
 	return node
 }
 
-func (node *KadNode) callPing(peer *Peer) error {
+// Start launches background maintenance, Stop terminates it.
+func (node *KadNode) Start() {
+	node.runMutex.Lock()
+	defer node.runMutex.Unlock()
+	if node.cancel != nil {
+		return
+	}
+	var ctx context.Context
+	ctx, node.cancel = context.WithCancel(context.Background())
+	node.every(ctx, checkPeriod(node.RefreshInterval), node.refreshIdle)
+	node.every(ctx, checkPeriod(node.ReplicateInterval), node.replicate)
+	node.every(ctx, checkPeriod(node.RepublishInterval), node.republish)
+	node.every(ctx, node.SweepInterval, node.sweep)
+}
+
+// Stop terminates background maintenance and waits for background work of operations.
+func (node *KadNode) Stop() {
+	node.runMutex.Lock()
+	defer node.runMutex.Unlock()
+	if node.cancel != nil {
+		node.cancel()
+		node.loops.Wait()
+		node.cancel = nil
+	}
+	node.tasks.Wait()
+}
+
+// Wait blocks until background work started by operations, like caching found values, is done.
+func (node *KadNode) Wait() {
+	node.runMutex.Lock()
+	defer node.runMutex.Unlock()
+	node.tasks.Wait()
+}
+
+// Tick runs all maintenance tasks once at given time, it lets simulations
+// drive the node from virtual clock instead of Start.
+func (node *KadNode) Tick(ctx context.Context, now time.Time) {
+	node.refreshIdle(ctx, now)
+	node.replicate(ctx, now)
+	node.republish(ctx, now)
+	node.sweep(ctx, now)
+}
+
+// every runs f periodically until ctx is done, zero or negative period disables it.
+func (node *KadNode) every(ctx context.Context, period time.Duration, f func(ctx context.Context, now time.Time)) {
+	if period <= 0 {
+		return
+	}
+	node.loops.Add(1)
+	go func() {
+		defer node.loops.Done()
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				f(ctx, node.Clock())
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (node *KadNode) callPing(ctx context.Context, peer *Peer) error {
 	randomId, err := CryptoRandId()
 	if err != nil {
 		return err
 	}
-	echoId, err := peer.Proto.Ping(node.Peer, randomId)
+	echoId, err := peer.Proto.PingContext(ctx, node.Peer, randomId)
 	if err != nil {
 		return err
 	}
@@ -39,7 +173,13 @@ func (node *KadNode) callPing(peer *Peer) error {
 }
 
 func (node *KadNode) add(peer *Peer) bool {
-	peer.touch()
+	return node.addContext(context.Background(), peer)
+}
+
+// addContext bounds liveness check of least seen peer with ctx when bucket is full.
+func (node *KadNode) addContext(ctx context.Context, peer *Peer) bool {
+	// table keeps its own copy, peers passed in may be shared with other nodes running in process
+	peer = &Peer{Id: peer.Id, Proto: peer.Proto, LastSeen: node.Clock()}
 	node.Tree.mutex.Lock()
 	n := node.Tree.Find(peer.Id)
 	if i := n.Bucket.find(peer.Id); i > -1 {
@@ -49,44 +189,108 @@ func (node *KadNode) add(peer *Peer) bool {
 		return added
 	} else if n.Bucket.isFull() {
 		if n.Bucket.inRange(node.Peer.Id) || n.Bucket.depth % node.b != 0 {
+			lo, hi := n.Bucket.lo, n.Bucket.hi
 			node.Tree.split(n)
 			node.Tree.mutex.Unlock()
-			return node.add(peer)
+			node.metrics.bucketSplits.Add(1)
+			node.emit(Event{Type: BucketSplit, Lo: lo, Hi: hi})
+			return node.addContext(ctx, peer)
 		} else {
-			if j, leastSeenPeer := n.Bucket.leastSeen(); j > -1 {
+			if j, leastSeenPeer := n.Bucket.leastSeen(); j > -1 && !node.backedOff(leastSeenPeer, node.Clock()) {
 				node.Tree.mutex.Unlock()
-				err := node.callPing(leastSeenPeer)
+				err := node.callPing(ctx, leastSeenPeer)
+				stale := false
+				if err != nil {
+					// cancelled check says nothing about the peer
+					if ctx.Err() == nil {
+						stale = node.markFailure(leastSeenPeer)
+					}
+				} else {
+					node.rpcSucceeded(leastSeenPeer)
+				}
 				node.Tree.mutex.Lock()
-				if k := n.Bucket.find(leastSeenPeer.Id); k > -1 {
-					n.Bucket.remove(k)
+				if n.Bucket == nil {
+					// bucket was split while pinging
+					node.Tree.mutex.Unlock()
+					return node.addContext(ctx, peer)
 				}
-				if err != nil {
+				if stale {
+					removed := false
+					if k := n.Bucket.find(leastSeenPeer.Id); k > -1 {
+						n.Bucket.remove(k)
+						node.metrics.evictions.Add(1)
+						removed = true
+					}
 					node.Tree.mutex.Unlock()
-					return node.add(peer)
-				} else {
-					leastSeenPeer.touch()
+					if removed {
+						node.emit(Event{Type: PeerRemoved, Peer: leastSeenPeer})
+					}
+					return node.addContext(ctx, peer)
+				} else if err == nil {
+					if k := n.Bucket.find(leastSeenPeer.Id); k > -1 {
+						n.Bucket.remove(k)
+					}
+					leastSeenPeer.touch(node.Clock())
 					n.Bucket.add(leastSeenPeer)
 				}
 			}
+			n.Bucket.addReplacement(peer)
 			node.Tree.mutex.Unlock()
 			return false
 		}
 	} else {
+		if j := n.Bucket.findReplacement(peer.Id); j > -1 {
+			n.Bucket.removeReplacement(j)
+		}
 		added := n.Bucket.add(peer)
 		node.Tree.mutex.Unlock()
+		if added {
+			node.emit(Event{Type: PeerAdded, Peer: peer})
+		}
 		return added
 	}
 }
 
+// evict removes peer from routing table and promotes a replacement in its place.
+func (node *KadNode) evict(peer *Peer) {
+	node.Tree.mutex.Lock()
+	n := node.Tree.Find(peer.Id)
+	var removed, promoted *Peer
+	if i := n.Bucket.find(peer.Id); i > -1 {
+		removed = n.Bucket.peers[i]
+		n.Bucket.remove(i)
+		node.metrics.evictions.Add(1)
+		promoted = n.Bucket.promote()
+		if promoted != nil {
+			node.metrics.promotions.Add(1)
+		}
+	} else if j := n.Bucket.findReplacement(peer.Id); j > -1 {
+		n.Bucket.removeReplacement(j)
+	}
+	node.Tree.mutex.Unlock()
+	if removed != nil {
+		node.emit(Event{Type: PeerRemoved, Peer: removed})
+	}
+	if promoted != nil {
+		node.emit(Event{Type: PeerAdded, Peer: promoted})
+	}
+}
+
 func (node *KadNode) Join(peer *Peer) error {
-	node.add(peer)
+	return node.JoinContext(context.Background(), peer)
+}
+
+// JoinContext adds peer and looks up own id and buckets further than peer through it,
+// lookups are abandoned when ctx is done.
+func (node *KadNode) JoinContext(ctx context.Context, peer *Peer) error {
+	node.addContext(ctx, peer)
 
-	result, err := peer.Proto.FindNode(node.Peer, node.Peer.Id)
+	result, err := peer.Proto.FindNodeContext(ctx, node.Peer, node.Peer.Id)
 	if err != nil {
 		return err
 	}
 	for _, p := range result.peers {
-		node.add(p)
+		node.addContext(ctx, p)
 	}
 
 	node.Tree.mutex.RLock()
@@ -94,7 +298,7 @@ func (node *KadNode) Join(peer *Peer) error {
 	node.Tree.mutex.RUnlock()
 	if len(buckets) > 1 {
 		// skip bucket containing our id
-		err = node.refreshBuckets(buckets[1:])
+		err = node.refreshBuckets(ctx, buckets[1:])
 		if err != nil {
 			return err
 		}
@@ -103,25 +307,50 @@ func (node *KadNode) Join(peer *Peer) error {
 	return nil
 }
 
-func (node *KadNode) refreshBucket(b *bucket) error {
+// Seed adds peer to routing table without contacting it, lookups of short-lived clients start from it
+// without announcing the client to the buckets Join would refresh.
+func (node *KadNode) Seed(peer *Peer) {
+	node.add(peer)
+}
+
+func (node *KadNode) refreshBucket(ctx context.Context, b *bucket) error {
 	id := MathRandIdRange(b.lo, b.hi)
 
-	for _, peer := range b.peers {
-		result, err := peer.Proto.FindNode(node.Peer, id)
+	node.Tree.mutex.Lock()
+	b.touch(node.Clock())
+	peers := make([]*Peer, len(b.peers))
+	copy(peers, b.peers)
+	node.Tree.mutex.Unlock()
+
+	// failing peer doesn't stop refresh, the others may still know the range
+	var failed int
+	var lastErr error
+	for _, peer := range peers {
+		result, err := peer.Proto.FindNodeContext(ctx, node.Peer, id)
 		if err != nil {
-			return err
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			node.rpcFailed(peer)
+			failed++
+			lastErr = err
+			continue
 		}
+		node.rpcSucceeded(peer)
 		for _, p := range result.peers {
 			node.add(p)
 		}
 	}
 
+	if failed > 0 {
+		return fmt.Errorf("find node failed at %d of %d peers: %w", failed, len(peers), lastErr)
+	}
 	return nil
 }
 
-func (node *KadNode) refreshBuckets(buckets []*bucket) error {
+func (node *KadNode) refreshBuckets(ctx context.Context, buckets []*bucket) error {
 	for _, b := range buckets {
-		err := node.refreshBucket(b)
+		err := node.refreshBucket(ctx, b)
 		if err != nil {
 			return err
 		}
@@ -130,36 +359,119 @@ func (node *KadNode) refreshBuckets(buckets []*bucket) error {
 }
 
 func (node *KadNode) Refresh() error {
+	return node.RefreshContext(context.Background())
+}
+
+// RefreshContext refreshes all buckets, refresh is abandoned when ctx is done.
+func (node *KadNode) RefreshContext(ctx context.Context) error {
 	node.Tree.mutex.RLock()
 	buckets := node.Tree.buckets(node.Peer.Id)
 	node.Tree.mutex.RUnlock()
-	return node.refreshBuckets(buckets)
+	return node.refreshBuckets(ctx, buckets)
+}
+
+// BucketInfo is a point in time copy of a bucket.
+type BucketInfo struct {
+	Depth int
+	Lo, Hi Id
+	Peers []Peer
+	Replacements []Peer
+	LastLookup time.Time
+}
+
+// Buckets returns routing table buckets starting from the one containing own id.
+func (node *KadNode) Buckets() []BucketInfo {
+	node.Tree.mutex.RLock()
+	defer node.Tree.mutex.RUnlock()
+	buckets := node.Tree.buckets(node.Peer.Id)
+	infos := make([]BucketInfo, len(buckets))
+	for i, b := range buckets {
+		infos[i] = BucketInfo{Depth: b.depth, Lo: b.lo, Hi: b.hi, LastLookup: b.lastLookup}
+		for _, peer := range b.peers {
+			infos[i].Peers = append(infos[i].Peers, *peer)
+		}
+		for _, peer := range b.replacements {
+			infos[i].Replacements = append(infos[i].Replacements, *peer)
+		}
+	}
+	return infos
 }
 
 func (node *KadNode) Lookup(id Id, findValue bool) (*FindResult, error) {
-	peers := node.Tree.closest(id, node.k)
+	return node.LookupContext(context.Background(), id, findValue)
+}
+
+func (node *KadNode) LookupContext(ctx context.Context, id Id, findValue bool) (*FindResult, error) {
+	result, _, err := node.lookup(ctx, id, findValue, nil)
+	return result, err
+}
+
+// lookup returns also peers queried without finding the value,
+// values rejected by accept are discarded and lookup continues, nil accept takes any value.
+func (node *KadNode) lookup(ctx context.Context, id Id, findValue bool, accept func(value []byte) bool) (*FindResult, []*Peer, error) {
+	start := time.Now()
+	node.metrics.lookups.Add(1)
+	result, queried, err := node.iterate(ctx, id, findValue, accept)
+	if err != nil {
+		node.metrics.lookupErrors.Add(1)
+	} else {
+		node.metrics.lookupHops.Observe(float64(result.hops))
+		node.metrics.lookupDuration.Observe(time.Since(start).Seconds())
+	}
+	return result, queried, err
+}
+
+func (node *KadNode) iterate(ctx context.Context, id Id, findValue bool, accept func(value []byte) bool) (*FindResult, []*Peer, error) {
+	// abort outstanding requests once lookup returns
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	node.Tree.mutex.Lock()
+	now := node.Clock()
+	node.Tree.Find(id).Bucket.touch(now)
+	closest := node.Tree.closest(id, node.k)
+	node.Tree.mutex.Unlock()
 
 	seen := make(map[string]bool)
-	for _, peer := range peers {
+	// number of hops needed to reach a peer
+	hops := make(map[string]int)
+	maxHops := 0
+	peers := make([]*Peer, 0, len(closest))
+	for _, peer := range closest {
 		key := string(peer.Id.Bytes())
 		seen[key] = true
+		hops[key] = 1
+		if !node.backedOff(peer, now) {
+			peers = append(peers, peer)
+		}
 	}
 
 	queried := make([]*Peer, 0, node.k)
+	// providers aggregated across value lookup
+	var providers []*Peer
+	seenProviders := make(map[string]bool)
 
 	type poolResult struct {
 		peer *Peer
 		findResult *FindResult
 	}
 
-	input, output := Pool(node.alpha, func(payload Payload) (Payload, error) {
+	input, output := PoolContext(ctx, node.alpha, func(payload Payload) (Payload, error) {
 		peer := payload.(*Peer)
 		var findResult *FindResult
 		var err error
 		if findValue {
-			findResult, err = peer.Proto.FindValue(node.Peer, id)
+			findResult, err = peer.Proto.FindValueContext(ctx, node.Peer, id)
+			if err == nil && findResult.value != nil && accept != nil && !accept(findResult.value) {
+				// holder of rejected value still knows peers closer to the key
+				providers := findResult.providers
+				findResult, err = peer.Proto.FindNodeContext(ctx, node.Peer, id)
+				if err == nil {
+					findResult.providers = providers
+				}
+			}
 		} else {
-			findResult, err = peer.Proto.FindNode(node.Peer, id)
+			findResult, err = peer.Proto.FindNodeContext(ctx, node.Peer, id)
 		}
 		return poolResult{peer: peer, findResult: findResult}, err
 	})
@@ -174,23 +486,45 @@ func (node *KadNode) Lookup(id Id, findValue bool) (*FindResult, error) {
 	out := 0
 
 	for out < in {
-		result := <-output
+		var result ProcessResult
+		select {
+		case result = <-output:
+		case <-ctx.Done():
+			return nil, queried, ctx.Err()
+		}
 		out += 1
 		peer := result.value.(poolResult).peer
 
 		if result.err != nil {
-			log.Printf("FindNode failed: %v\n", result.err)
+			if ctx.Err() != nil {
+				return nil, queried, ctx.Err()
+			}
+			node.Logger.Log(logging.Debug, "lookup query failed",
+				logging.F("peer", hexId(peer.Id)), logging.F("error", result.err))
+			node.rpcFailed(peer)
 		} else {
+			node.rpcSucceeded(peer)
 			findResult := result.value.(poolResult).findResult
+			peerHops := hops[string(peer.Id.Bytes())]
+			if peerHops > maxHops {
+				maxHops = peerHops
+			}
+			providers = mergeProviders(providers, seenProviders, findResult.providers)
 			if findResult.value != nil {
-				return findResult, nil
+				findResult.holder = peer
+				findResult.hops = peerHops
+				findResult.providers = providers
+				return findResult, queried, nil
 			} else {
 				queried = append(queried, peer)
 				for _, p := range findResult.peers {
 					key := string(p.Id.Bytes())
 					if _, ok := seen[key]; !ok && !eq(node.Peer.Id, p.Id) {
-						peers = insertSorted(peers, p, id)
 						seen[key] = true
+						hops[key] = peerHops + 1
+						if !node.backedOff(p, now) {
+							peers = insertSorted(peers, p, id)
+						}
 					}
 				}
 			}
@@ -205,23 +539,41 @@ func (node *KadNode) Lookup(id Id, findValue bool) (*FindResult, error) {
 		}
 	}
 
-	if findValue {
-		return nil, errors.New("not found")
+	if findValue && len(providers) == 0 {
+		return nil, queried, ErrNotFound
 	}
 
 	for _, p := range queried {
 		peers = insertSorted(peers, p, id)
 	}
 	peers = peers[:min(node.k, len(peers))]
-	result := &FindResult{peers: peers, value: nil}
-	return result, nil
+	result := &FindResult{peers: peers, value: nil, providers: providers, hops: maxHops}
+	return result, queried, nil
 }
 
 // Storage interface
 
 func (node *KadNode) Set(key []byte, value []byte) error {
+	return node.SetTTLContext(context.Background(), key, value, 0)
+}
+
+func (node *KadNode) SetContext(ctx context.Context, key []byte, value []byte) error {
+	return node.SetTTLContext(ctx, key, value, 0)
+}
+
+// SetTTL stores value which expires after ttl, zero ttl means no expiration.
+func (node *KadNode) SetTTL(key []byte, value []byte, ttl time.Duration) error {
+	return node.SetTTLContext(context.Background(), key, value, ttl)
+}
+
+func (node *KadNode) SetTTLContext(ctx context.Context, key []byte, value []byte, ttl time.Duration) error {
 	id := BytesId(key)
-	findResult, err := node.Lookup(id, false)
+	node.publish(id, value, ttl)
+	return node.storeClosest(ctx, id, value, ttl)
+}
+
+func (node *KadNode) storeClosest(ctx context.Context, id Id, value []byte, ttl time.Duration) error {
+	findResult, err := node.LookupContext(ctx, id, false)
 	if err != nil {
 		return err
 	}
@@ -229,14 +581,23 @@ func (node *KadNode) Set(key []byte, value []byte) error {
 	wg.Add(len(findResult.peers))
 	var failures int32
 	parallelize(findResult.peers, func(peer *Peer) {
-		err := peer.Proto.Store(node.Peer, id, value)
+		err := peer.Proto.StoreContext(ctx, node.Peer, id, value, ttl)
 		if err != nil {
 			atomic.AddInt32(&failures, 1)
-			log.Printf("Store failed, peer: %v, error: %v\n", peer, err)
+			node.Logger.Log(logging.Debug, "store failed",
+				logging.F("peer", hexId(peer.Id)), logging.F("key", hexId(id)), logging.F("error", err))
+			if ctx.Err() == nil {
+				node.rpcFailed(peer)
+			}
+		} else {
+			node.rpcSucceeded(peer)
 		}
 		wg.Done()
 	})
 	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if failures >= int32(len(findResult.peers) / 2) {
 		return errors.New("store failed")
 	}
@@ -244,23 +605,55 @@ func (node *KadNode) Set(key []byte, value []byte) error {
 }
 
 func (node *KadNode) Get(key []byte) ([]byte, error) {
-	id := BytesId(key)
-	findResult, err := node.Lookup(id, true)
+	return node.GetContext(context.Background(), key)
+}
+
+func (node *KadNode) GetContext(ctx context.Context, key []byte) ([]byte, error) {
+	findResult, err := node.GetResultContext(ctx, key)
 	if err != nil {
 		return nil, err
 	}
 	return findResult.value, nil
 }
 
+// GetResultContext is GetContext returning whole result of the value lookup, like its hops.
+func (node *KadNode) GetResultContext(ctx context.Context, key []byte) (*FindResult, error) {
+	id := BytesId(key)
+	findResult, queried, err := node.lookup(ctx, id, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	if findResult.value == nil {
+		// only providers were found
+		return nil, ErrNotFound
+	}
+	node.cacheInBackground(id, findResult, queried)
+	return findResult, nil
+}
+
 // Protocol interface
 
 func (node *KadNode) Ping(sender *Peer, randomId Id) (Id, error) {
-	node.add(sender)
+	return node.PingContext(context.Background(), sender, randomId)
+}
+
+func (node *KadNode) PingContext(ctx context.Context, sender *Peer, randomId Id) (Id, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	node.seen(ctx, sender)
 	return randomId, nil
 }
 
 func (node *KadNode) FindNode(sender *Peer, id Id) (*FindResult, error) {
-	node.add(sender)
+	return node.FindNodeContext(context.Background(), sender, id)
+}
+
+func (node *KadNode) FindNodeContext(ctx context.Context, sender *Peer, id Id) (*FindResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	node.seen(ctx, sender)
 	node.Tree.mutex.RLock()
 	peers := node.Tree.closest(id, node.Tree.k)
 	node.Tree.mutex.RUnlock()
@@ -268,21 +661,73 @@ func (node *KadNode) FindNode(sender *Peer, id Id) (*FindResult, error) {
 }
 
 func (node *KadNode) FindValue(sender *Peer, key Id) (*FindResult, error) {
-	node.add(sender)
+	return node.FindValueContext(context.Background(), sender, key)
+}
+
+func (node *KadNode) FindValueContext(ctx context.Context, sender *Peer, key Id) (*FindResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	node.seen(ctx, sender)
 	value, err := node.Storage.Get(key.Bytes())
 	if err != nil {
 		node.Tree.mutex.RLock()
 		peers := node.Tree.closest(key, node.Tree.k)
 		node.Tree.mutex.RUnlock()
-		result := &FindResult{value: nil, peers: peers}
+		result := &FindResult{value: nil, peers: peers, providers: node.providersOf(key)}
 		return result, nil
 	}
-	result := &FindResult{value: value, peers: nil}
+	expiry, err := node.Storage.Expiry(key.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	ttl, ok := remaining(expiry, node.Clock())
+	if !ok {
+		return nil, errors.New("value expired")
+	}
+	result := &FindResult{value: value, peers: nil, ttl: ttl, providers: node.providersOf(key)}
 	return result, nil
 }
 
-func (node *KadNode) Store(sender *Peer, key Id, value []byte) error {
-	node.add(sender)
-	log.Printf("Store, peer: %d, key: %d\n", node.Peer.Id, key)
-	return node.Storage.Set(key.Bytes(), value)
+func (node *KadNode) Store(sender *Peer, key Id, value []byte, ttl time.Duration) error {
+	return node.StoreContext(context.Background(), sender, key, value, ttl)
+}
+
+func (node *KadNode) StoreContext(ctx context.Context, sender *Peer, key Id, value []byte, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	node.seen(ctx, sender)
+	if node.Logger.Enabled(logging.Debug) {
+		node.Logger.Log(logging.Debug, "store", logging.F("peer", hexId(sender.Id)), logging.F("key", hexId(key)))
+	}
+	var expiry time.Time
+	if ttl > 0 {
+		expiry = node.Clock().Add(node.scaleTTL(key, ttl))
+	}
+	node.storeMutex.Lock()
+	err := node.checkStore(key, value)
+	if err == nil {
+		err = node.Storage.SetWithExpiry(key.Bytes(), value, expiry)
+	}
+	node.storeMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	node.received(key)
+	node.emit(Event{Type: ValueStored, Peer: sender, Key: key})
+	return nil
+}
+
+func (node *KadNode) Announce(sender *Peer, key Id, ttl time.Duration) error {
+	return node.AnnounceContext(context.Background(), sender, key, ttl)
+}
+
+// AnnounceContext adds sender to providers of key until ttl passes.
+func (node *KadNode) AnnounceContext(ctx context.Context, sender *Peer, key Id, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	node.seen(ctx, sender)
+	return node.addProvider(key, sender, ttl)
 }
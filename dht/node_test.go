@@ -1,7 +1,9 @@
 package dht
 
 import (
+	"context"
 	"fmt"
+	"github.com/mduszyk/gopeers/logging"
 	"github.com/mduszyk/gopeers/store"
 	"log"
 	"math/big"
@@ -93,6 +95,34 @@ func TestNodeTrivialJoin(t *testing.T) {
 	}
 }
 
+func TestNodeSeed(t *testing.T) {
+	storage := store.NewMemStorage()
+	node1 := NewKadNode(20, 5, 3, MathRandId(), storage)
+	node2 := NewKadNode(20, 5, 3, MathRandId(), storage)
+	node1.Seed(node2.Peer)
+	if n := node1.Tree.Find(node2.Peer.Id); !n.Bucket.Contains(node2.Peer.Id) {
+		t.Errorf("seeded peer not added to bucket\n")
+	}
+	if n := node2.Tree.Find(node1.Peer.Id); n.Bucket.Contains(node1.Peer.Id) {
+		t.Errorf("seeded peer should not be contacted\n")
+	}
+}
+
+func TestNodeJoinCanceled(t *testing.T) {
+	storage := store.NewMemStorage()
+	node1 := NewKadNode(20, 5, 3, MathRandId(), storage)
+	node2 := NewKadNode(20, 5, 3, MathRandId(), storage)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := node1.JoinContext(ctx, node2.Peer)
+	if err != context.Canceled {
+		t.Errorf("join should be abandoned with canceled context: %v\n", err)
+	}
+	if n := node2.Tree.Find(node1.Peer.Id); n.Bucket.Contains(node1.Peer.Id) {
+		t.Errorf("canceled join should not reach bootstrap node\n")
+	}
+}
+
 func TestNodeJoin(t *testing.T) {
 	n := 400
 	k := 20
@@ -230,3 +260,102 @@ func TestLookupSetGet(t *testing.T) {
 
 	log.Printf("Done")
 }
+
+func TestReplacementCache(t *testing.T) {
+	k := 2
+	half := new(big.Int).Rsh(maxId, 1)
+	node := NewKadNode(k, 1, 3, big.NewInt(0), store.NewMemStorage())
+	peers := make([]*Peer, 3)
+	for i := range peers {
+		id := new(big.Int).Add(half, big.NewInt(int64(i)))
+		peers[i] = NewKadNode(k, 1, 3, id, store.NewMemStorage()).Peer
+	}
+	node.add(peers[0])
+	node.add(peers[1])
+	if node.add(peers[2]) {
+		t.Errorf("full bucket with responsive peers should not add peer\n")
+	}
+	n := node.Tree.Find(peers[2].Id)
+	if n.Bucket.findReplacement(peers[2].Id) < 0 {
+		t.Errorf("peer should be kept in replacement cache\n")
+	}
+
+	node.evict(peers[1])
+	if n.Bucket.Contains(peers[1].Id) {
+		t.Errorf("evicted peer should be removed\n")
+	}
+	if !n.Bucket.Contains(peers[2].Id) {
+		t.Errorf("replacement should be promoted\n")
+	}
+	if len(n.Bucket.replacements) != 0 {
+		t.Errorf("promoted peer should leave replacement cache\n")
+	}
+}
+
+type blockingProtocol struct {
+	Protocol
+}
+
+func (p blockingProtocol) FindNodeContext(ctx context.Context, sender *Peer, id Id) (*FindResult, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (p blockingProtocol) FindValueContext(ctx context.Context, sender *Peer, key Id) (*FindResult, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestLookupContext(t *testing.T) {
+	node := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	blocking := &Peer{MathRandId(), blockingProtocol{}, time.Now()}
+	node.add(blocking)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+	defer cancel()
+	_, err := node.LookupContext(ctx, MathRandId(), false)
+	if err != context.DeadlineExceeded {
+		t.Errorf("lookup should fail with context error, got: %v\n", err)
+	}
+	if node.backedOff(blocking, time.Now()) {
+		t.Errorf("cancelled request should not count as peer failure\n")
+	}
+
+	err = node.SetContext(ctx, MathRandId().Bytes(), []byte("value"))
+	if err != context.DeadlineExceeded {
+		t.Errorf("set should fail with context error, got: %v\n", err)
+	}
+	_, err = node.GetContext(ctx, MathRandId().Bytes())
+	if err != context.DeadlineExceeded {
+		t.Errorf("get should fail with context error, got: %v\n", err)
+	}
+}
+
+type recordingLogger struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Log(level logging.Level, msg string, fields ...logging.Field) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.messages = append(l.messages, level.String()+" "+msg)
+}
+
+func (l *recordingLogger) Enabled(level logging.Level) bool {
+	return true
+}
+
+func TestLogger(t *testing.T) {
+	node1 := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	node2 := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	logger := &recordingLogger{}
+	node2.Logger = logger
+	err := node2.Store(node1.Peer, MathRandId(), []byte("value"), 0)
+	if err != nil {
+		t.Errorf("failed storing: %v\n", err)
+	}
+	if len(logger.messages) != 1 || logger.messages[0] != "debug store" {
+		t.Errorf("store should be logged at debug level: %v\n", logger.messages)
+	}
+}
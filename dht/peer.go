@@ -24,8 +24,8 @@ func NewRandomIdPeer() (*Peer, error){
 	return peer, nil
 }
 
-func (p *Peer) touch() {
-	p.LastSeen = time.Now()
+func (p *Peer) touch(now time.Time) {
+	p.LastSeen = now
 }
 
 func sortByDistance(peers []*Peer, id Id) {
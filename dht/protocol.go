@@ -1,42 +1,117 @@
 package dht
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"github.com/golang/protobuf/proto"
 	"github.com/mduszyk/gopeers/rpc"
 	"github.com/mduszyk/gopeers/store"
 	"net"
+	"sync"
 	"time"
 )
 
+var (
+	ErrReceiver = errors.New("request addressed to other node")
+	ErrReplay   = errors.New("request replayed or signed outside of allowed clock skew")
+	ErrCaller   = errors.New("caller not reachable at claimed address")
+)
+
+const (
+	nonceSize = 16
+	// requests signed earlier or later are rejected, nonces are remembered for this long
+	maxClockSkew = time.Minute
+	maxNonces    = 1 << 16
+)
+
 type FindResult struct {
 	peers []*Peer
 	value []byte
+	// announced providers of the key, aggregated across lookup
+	providers []*Peer
+	// remaining ttl of the value, zero means no expiration
+	ttl time.Duration
+	// peer which returned the value
+	holder *Peer
+	// number of hops lookup needed, zero for results of single rpc
+	hops int
+}
+
+// NewFindResult lets Protocol implementations outside of the package build results.
+func NewFindResult(peers []*Peer, value []byte, ttl time.Duration, providers []*Peer) *FindResult {
+	return &FindResult{peers: peers, value: value, ttl: ttl, providers: providers}
+}
+
+func (r *FindResult) Peers() []*Peer {
+	return r.peers
+}
+
+func (r *FindResult) Value() []byte {
+	return r.value
+}
+
+func (r *FindResult) Providers() []*Peer {
+	return r.providers
+}
+
+func (r *FindResult) TTL() time.Duration {
+	return r.ttl
+}
+
+func (r *FindResult) Hops() int {
+	return r.hops
 }
 
 type Protocol interface {
 	Ping(sender *Peer, randomId Id) (Id, error)
 	FindNode(sender *Peer, id Id) (*FindResult, error)
 	FindValue(sender *Peer, key Id) (*FindResult, error)
-	Store(sender *Peer, key Id, value []byte) error
+	Store(sender *Peer, key Id, value []byte, ttl time.Duration) error
+	PingContext(ctx context.Context, sender *Peer, randomId Id) (Id, error)
+	FindNodeContext(ctx context.Context, sender *Peer, id Id) (*FindResult, error)
+	FindValueContext(ctx context.Context, sender *Peer, key Id) (*FindResult, error)
+	StoreContext(ctx context.Context, sender *Peer, key Id, value []byte, ttl time.Duration) error
+	Announce(sender *Peer, key Id, ttl time.Duration) error
+	AnnounceContext(ctx context.Context, sender *Peer, key Id, ttl time.Duration) error
 }
 
 type udpProtocolNode struct {
 	rpcNode            *rpc.UdpNode
 	dhtNode            *KadNode
+	identity           *Identity
 	pingServiceId      rpc.ServiceId
 	findNodeServiceId  rpc.ServiceId
 	findValueServiceId rpc.ServiceId
 	storeServiceId     rpc.ServiceId
+	announceServiceId  rpc.ServiceId
+	replays            *replays
+	snapshotPath       string
+	snapshotStop       chan struct{}
+	snapshots          sync.WaitGroup
+	snapshotMutex      sync.Mutex
+	// optional transport for payloads larger than tcpThreshold
+	tcpNode      *rpc.TcpNode
+	tcpThreshold int
 }
 
-func NewUdpProtocolNode(rpcNode *rpc.UdpNode, dhtNode *KadNode) *udpProtocolNode {
+// NewUdpProtocolNode signs messages with identity, id of dhtNode has to be derived from it.
+func NewUdpProtocolNode(rpcNode *rpc.UdpNode, dhtNode *KadNode, identity *Identity) (*udpProtocolNode, error) {
+	if !eq(dhtNode.Peer.Id, identity.Id()) {
+		return nil, ErrIdentity
+	}
 	protocolNode := &udpProtocolNode{
 		rpcNode:            rpcNode,
 		dhtNode:            dhtNode,
+		identity:           identity,
 		pingServiceId:      rpc.ServiceId(0),
 		findNodeServiceId:  rpc.ServiceId(1),
 		findValueServiceId: rpc.ServiceId(2),
 		storeServiceId:     rpc.ServiceId(3),
+		announceServiceId:  rpc.ServiceId(4),
+		replays:            newReplays(),
 	}
 	// register rpc services
 	rpcNode.Services = []rpc.Service{
@@ -44,124 +119,468 @@ func NewUdpProtocolNode(rpcNode *rpc.UdpNode, dhtNode *KadNode) *udpProtocolNode
 		protocolNode.FindNodeRpc,
 		protocolNode.FindValueRpc,
 		protocolNode.StoreRpc,
+		protocolNode.AnnounceRpc,
 	}
-	return protocolNode
+	return protocolNode, nil
+}
+
+// EnableTcp registers services on tcpNode and makes payloads larger than threshold bytes go over tcp.
+// Threshold should leave room for rpc envelope within read buffer of udp nodes in the network.
+// The tcp node is expected to listen on the same port as udp node, it is shut down with the node.
+// It should be called before rpc nodes run.
+func (n *udpProtocolNode) EnableTcp(tcpNode *rpc.TcpNode, threshold int) {
+	tcpNode.Services = []rpc.Service{
+		n.pingTcpRpc,
+		n.findNodeTcpRpc,
+		n.findValueTcpRpc,
+		n.storeTcpRpc,
+		n.announceTcpRpc,
+	}
+	n.tcpNode = tcpNode
+	n.tcpThreshold = threshold
+}
+
+func (n *udpProtocolNode) TcpNode() *rpc.TcpNode {
+	return n.tcpNode
 }
 
 func StartUdpProtocolNode(
 	k, b, alpha int,
-	nodeId Id,
+	identity *Identity,
 	storage store.Storage,
 	address string,
 	rpcCallTimeout time.Duration,
 	readBufferSize uint32,
 	) (*udpProtocolNode, error) {
 
-	dhtNode := NewKadNode(k, b, alpha, nodeId, storage)
+	dhtNode := NewKadNode(k, b, alpha, identity.Id(), storage)
 	rpcNode, err := rpc.NewUdpNode(address, nil, rpcCallTimeout, readBufferSize)
 	if err != nil {
 		return nil, err
 	}
-	protocolNode := NewUdpProtocolNode(rpcNode, dhtNode)
+	protocolNode, err := NewUdpProtocolNode(rpcNode, dhtNode, identity)
+	if err != nil {
+		rpcNode.Close()
+		return nil, err
+	}
 
 	go rpcNode.Run()
+	dhtNode.Start()
 
 	return protocolNode, nil
 }
 
+// Shutdown stops rpc node draining in-flight requests until ctx is done,
+// then stops dht node background maintenance and takes final snapshot.
+func (n *udpProtocolNode) Shutdown(ctx context.Context) error {
+	err := n.rpcNode.Shutdown(ctx)
+	if n.tcpNode != nil {
+		if tcpErr := n.tcpNode.Shutdown(ctx); err == nil {
+			err = tcpErr
+		}
+	}
+	n.dhtNode.Stop()
+	snapshotErr := n.stopSnapshots()
+	if err != nil {
+		return err
+	}
+	return snapshotErr
+}
+
+func (n *udpProtocolNode) Close() error {
+	err := n.rpcNode.Close()
+	if n.tcpNode != nil {
+		if tcpErr := n.tcpNode.Close(); err == nil {
+			err = tcpErr
+		}
+	}
+	n.dhtNode.Stop()
+	snapshotErr := n.stopSnapshots()
+	if err != nil {
+		return err
+	}
+	return snapshotErr
+}
+
+func (n *udpProtocolNode) KadNode() *KadNode {
+	return n.dhtNode
+}
+
+func (n *udpProtocolNode) RpcNode() *rpc.UdpNode {
+	return n.rpcNode
+}
+
+// Discover pings node listening on addr to learn its id, e.g. of a bootstrap node.
+func (n *udpProtocolNode) Discover(ctx context.Context, addr *net.UDPAddr) (*Peer, error) {
+	randomId, err := CryptoRandId()
+	if err != nil {
+		return nil, err
+	}
+	response, id, err := NewUdpProtocol(addr, n).ping(ctx, randomId)
+	if err != nil {
+		return nil, err
+	}
+	if !eq(BytesId(response.RandomId), randomId) {
+		return nil, errors.New("ping random id not echoed")
+	}
+	peer := NewPeer(id)
+	n.Connect(addr, peer)
+	return peer, nil
+}
+
+// Connect makes peer reachable at peerAddr, responses are accepted only if signed by the key of peer id.
 func (n *udpProtocolNode) Connect(peerAddr *net.UDPAddr, peer *Peer) {
-	peer.Proto = NewUdpProtocol(peerAddr, n)
+	protocol := NewUdpProtocol(peerAddr, n)
+	protocol.id = peer.Id
+	peer.Proto = protocol
 }
 
-func (n *udpProtocolNode) PingRpc(addr *net.UDPAddr, payload rpc.Payload) (rpc.Payload, error) {
-	var request PingRequest
-	err := proto.Unmarshal(payload, &request)
+// signed prefixes payload with service, direction, receiver, timestamp and nonce,
+// so that signature can't be replayed as different message or to other node.
+func signed(serviceId rpc.ServiceId, response bool, envelope *Signed) []byte {
+	direction := byte(0)
+	if response {
+		direction = 1
+	}
+	data := make([]byte, 12, 12+len(envelope.Nonce)+len(envelope.Receiver)+len(envelope.Payload))
+	data[0], data[1] = byte(serviceId), direction
+	binary.BigEndian.PutUint64(data[2:], uint64(envelope.Timestamp))
+	data[10], data[11] = byte(len(envelope.Nonce)), byte(len(envelope.Receiver))
+	data = append(data, envelope.Nonce...)
+	data = append(data, envelope.Receiver...)
+	return append(data, envelope.Payload...)
+}
+
+func (n *udpProtocolNode) seal(serviceId rpc.ServiceId, response bool, envelope *Signed, message proto.Message) (rpc.Payload, error) {
+	payload, err := proto.Marshal(message)
 	if err != nil {
 		return nil, err
 	}
-	peer := NewPeer(BytesId(request.PeerId))
+	envelope.PublicKey = n.identity.PublicKey
+	envelope.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+	envelope.Payload = payload
+	envelope.Signature = n.identity.Sign(signed(serviceId, response, envelope))
+	return proto.Marshal(envelope)
+}
+
+// sealRequest signs request to receiver, nil receiver is allowed only for ping of unknown node.
+// It returns nonce which response has to carry.
+func (n *udpProtocolNode) sealRequest(serviceId rpc.ServiceId, receiver Id, message proto.Message) (rpc.Payload, []byte, error) {
+	nonce := make([]byte, nonceSize)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	envelope := &Signed{Nonce: nonce}
+	if receiver != nil {
+		envelope.Receiver = receiver.Bytes()
+	}
+	payload, err := n.seal(serviceId, false, envelope, message)
+	return payload, nonce, err
+}
+
+// sealResponse binds response to the request it answers.
+func (n *udpProtocolNode) sealResponse(serviceId rpc.ServiceId, request *Signed, message proto.Message) (rpc.Payload, error) {
+	envelope := &Signed{Nonce: request.Nonce, Receiver: KeyId(request.PublicKey).Bytes()}
+	return n.seal(serviceId, true, envelope, message)
+}
+
+// open verifies signature and decodes message, it returns envelope and id of the signer.
+func (n *udpProtocolNode) open(serviceId rpc.ServiceId, response bool, payload rpc.Payload, message proto.Message) (*Signed, Id, error) {
+	var envelope Signed
+	err := proto.Unmarshal(payload, &envelope)
+	if err != nil {
+		return nil, nil, err
+	}
+	id, err := Verify(envelope.PublicKey, signed(serviceId, response, &envelope), envelope.Signature)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = proto.Unmarshal(envelope.Payload, message)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &envelope, id, nil
+}
+
+type peerRequest interface {
+	proto.Message
+	GetPeerId() []byte
+}
+
+// openRequest verifies that sender signed the request with key of the id it claims,
+// that the request is addressed to this node and that it wasn't received before.
+// Returned peer is connected at addr, it is nil if sender didn't know id of this node,
+// such sender must not be added to the routing table, request could have been replayed from other node.
+// Caller over tcp only claims the port it listens on, its address is bound to the id if it's already
+// known for the id or the id answers ping sent to it.
+func (n *udpProtocolNode) openRequest(
+	addr *net.UDPAddr,
+	tcp bool,
+	serviceId rpc.ServiceId,
+	payload rpc.Payload,
+	request peerRequest,
+) (*Signed, *Peer, error) {
+	envelope, id, err := n.open(serviceId, false, payload, request)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !eq(BytesId(request.GetPeerId()), id) {
+		return nil, nil, ErrIdentity
+	}
+	// encrypted session has to be agreed with the signer, not with node relaying its request
+	if n.rpcNode.Encrypted() && !eq(KeyId(n.rpcNode.PeerKey(addr)), id) {
+		return nil, nil, ErrIdentity
+	}
+	if len(envelope.Receiver) > 0 && !eq(BytesId(envelope.Receiver), n.dhtNode.Peer.Id) {
+		return nil, nil, ErrReceiver
+	}
+	err = n.replays.check(envelope, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(envelope.Receiver) == 0 {
+		return envelope, nil, nil
+	}
+	if tcp && !sameAddr(n.knownAddr(id), addr) {
+		discovered, err := n.Discover(context.Background(), addr)
+		if err != nil || !eq(discovered.Id, id) {
+			return nil, nil, ErrCaller
+		}
+	}
+	peer := NewPeer(id)
 	n.Connect(addr, peer)
-	pingId, err := n.dhtNode.Ping(peer, BytesId(request.RandomId))
+	return envelope, peer, nil
+}
+
+// knownAddr returns address of peer with id from routing table, nil if it's not there.
+func (n *udpProtocolNode) knownAddr(id Id) *net.UDPAddr {
+	tree := n.dhtNode.Tree
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+	b := tree.Find(id).Bucket
+	if i := b.find(id); i > -1 {
+		return UdpAddr(b.peers[i])
+	}
+	return nil
+}
+
+func sameAddr(a, b *net.UDPAddr) bool {
+	return a != nil && b != nil && a.IP.Equal(b.IP) && a.Port == b.Port && a.Zone == b.Zone
+}
+
+// replays remembers nonces of requests signed within maxClockSkew, older requests are rejected.
+type replays struct {
+	nonces map[string]time.Time
+	mutex  sync.Mutex
+}
+
+func newReplays() *replays {
+	return &replays{nonces: make(map[string]time.Time)}
+}
+
+func (r *replays) check(envelope *Signed, now time.Time) error {
+	signedAt := time.Unix(0, envelope.Timestamp*int64(time.Millisecond))
+	if signedAt.Before(now.Add(-maxClockSkew)) || signedAt.After(now.Add(maxClockSkew)) {
+		return ErrReplay
+	}
+	if len(envelope.Nonce) != nonceSize {
+		return ErrReplay
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	nonce := string(envelope.Nonce)
+	if _, ok := r.nonces[nonce]; ok {
+		return ErrReplay
+	}
+	if len(r.nonces) >= maxNonces {
+		for k, t := range r.nonces {
+			if t.Before(now.Add(-maxClockSkew)) {
+				delete(r.nonces, k)
+			}
+		}
+		if len(r.nonces) >= maxNonces {
+			return errors.New("too many requests")
+		}
+	}
+	r.nonces[nonce] = signedAt
+	return nil
+}
+
+func (n *udpProtocolNode) PingRpc(addr *net.UDPAddr, payload rpc.Payload) (rpc.Payload, error) {
+	return n.pingRpc(addr, payload, false)
+}
+
+func (n *udpProtocolNode) pingTcpRpc(addr *net.UDPAddr, payload rpc.Payload) (rpc.Payload, error) {
+	return n.pingRpc(addr, payload, true)
+}
+
+func (n *udpProtocolNode) pingRpc(addr *net.UDPAddr, payload rpc.Payload, tcp bool) (rpc.Payload, error) {
+	var request PingRequest
+	envelope, peer, err := n.openRequest(addr, tcp, n.pingServiceId, payload, &request)
 	if err != nil {
 		return nil, err
 	}
-	response := PingResponse{RandomId: pingId.Bytes()}
-	return proto.Marshal(&response)
+	pingId := BytesId(request.RandomId)
+	// sender discovering this node is added once it sends request addressed to it
+	if peer != nil {
+		pingId, err = n.dhtNode.Ping(peer, pingId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	response := PingResponse{RandomId: pingId.Bytes(), PeerId: n.dhtNode.Peer.Id.Bytes()}
+	return n.sealResponse(n.pingServiceId, envelope, &response)
 }
 
 func (n *udpProtocolNode) FindNodeRpc(addr *net.UDPAddr, payload rpc.Payload) (rpc.Payload, error) {
+	return n.findNodeRpc(addr, payload, false)
+}
+
+func (n *udpProtocolNode) findNodeTcpRpc(addr *net.UDPAddr, payload rpc.Payload) (rpc.Payload, error) {
+	return n.findNodeRpc(addr, payload, true)
+}
+
+func (n *udpProtocolNode) findNodeRpc(addr *net.UDPAddr, payload rpc.Payload, tcp bool) (rpc.Payload, error) {
 	var request FindRequest
-	err := proto.Unmarshal(payload, &request)
+	envelope, peer, err := n.openRequest(addr, tcp, n.findNodeServiceId, payload, &request)
 	if err != nil {
 		return nil, err
 	}
-	peer := NewPeer(BytesId(request.PeerId))
-	n.Connect(addr, peer)
-	findResult, err := n.dhtNode.FindNode(peer, BytesId(request.PeerId))
+	if peer == nil {
+		return nil, ErrReceiver
+	}
+	findResult, err := n.dhtNode.FindNode(peer, BytesId(request.Id))
 	if err != nil {
 		return nil, err
 	}
-	nodes := make([]*UdpNode, len(findResult.peers))
-	for i := 0; i< len(findResult.peers); i++ {
-		protocol := (findResult.peers[i].Proto).(*udpProtocol)
-		protoAddr := &UDPAddr{
-			IP:   protocol.addr.IP,
-			Port: int32(protocol.addr.Port),
-			Zone: protocol.addr.Zone,
-		}
-		nodes[i] = &UdpNode{Addr: protoAddr, NodeId: findResult.peers[i].Id.Bytes()}
-	}
-	response := FindNodeResponse{Nodes: nodes}
-	return proto.Marshal(&response)
+	response := FindNodeResponse{Nodes: udpNodes(findResult.peers)}
+	return n.sealResponse(n.findNodeServiceId, envelope, &response)
 }
 
 func (n *udpProtocolNode) FindValueRpc(addr *net.UDPAddr, payload rpc.Payload) (rpc.Payload, error) {
+	return n.findValueRpc(addr, payload, false)
+}
+
+// findValueTcpRpc is FindValueRpc served over tcp, it never truncates the value.
+func (n *udpProtocolNode) findValueTcpRpc(addr *net.UDPAddr, payload rpc.Payload) (rpc.Payload, error) {
+	return n.findValueRpc(addr, payload, true)
+}
+
+// findValueRpc served over udp drops value which doesn't fit into datagram if tcp is enabled,
+// caller fetches it over tcp then.
+func (n *udpProtocolNode) findValueRpc(addr *net.UDPAddr, payload rpc.Payload, tcp bool) (rpc.Payload, error) {
 	var request FindRequest
-	err := proto.Unmarshal(payload, &request)
+	envelope, peer, err := n.openRequest(addr, tcp, n.findValueServiceId, payload, &request)
 	if err != nil {
 		return nil, err
 	}
-	peer := NewPeer(BytesId(request.PeerId))
-	n.Connect(addr, peer)
+	if peer == nil {
+		return nil, ErrReceiver
+	}
 	findResult, err := n.dhtNode.FindValue(peer, BytesId(request.Id))
 	if err != nil {
 		return nil, err
 	}
-	var nodes []*UdpNode
-	if findResult.peers != nil {
-		nodes = make([]*UdpNode, len(findResult.peers))
-		for i := 0; i < len(findResult.peers); i++ {
-			protocol := (findResult.peers[i].Proto).(*udpProtocol)
-			protoAddr := &UDPAddr{
-				IP:   protocol.addr.IP,
-				Port: int32(protocol.addr.Port),
-				Zone: protocol.addr.Zone,
-			}
-			nodes[i] = &UdpNode{Addr: protoAddr, NodeId: findResult.peers[i].Id.Bytes()}
+	response := FindValueResponse{
+		Nodes:     udpNodes(findResult.peers),
+		Value:     findResult.value,
+		TtlMillis: ttlMillis(findResult.ttl),
+		Providers: udpNodes(findResult.providers),
+	}
+	if !tcp && n.tcpNode != nil && proto.Size(&response) > n.tcpThreshold {
+		response = FindValueResponse{Truncated: true}
+	}
+	return n.sealResponse(n.findValueServiceId, envelope, &response)
+}
+
+// udpNodes encodes peers connected over udp, nil peers stay nil.
+func udpNodes(peers []*Peer) []*UdpNode {
+	if peers == nil {
+		return nil
+	}
+	nodes := make([]*UdpNode, 0, len(peers))
+	for _, peer := range peers {
+		addr := UdpAddr(peer)
+		if addr == nil {
+			continue
 		}
+		protoAddr := &UDPAddr{IP: addr.IP, Port: int32(addr.Port), Zone: addr.Zone}
+		nodes = append(nodes, &UdpNode{Addr: protoAddr, NodeId: peer.Id.Bytes()})
 	}
-	response := FindValueResponse{Nodes: nodes, Value: findResult.value}
-	return proto.Marshal(&response)
+	return nodes
 }
 
 func (n *udpProtocolNode) StoreRpc(addr *net.UDPAddr, payload rpc.Payload) (rpc.Payload, error) {
+	return n.storeRpc(addr, payload, false)
+}
+
+func (n *udpProtocolNode) storeTcpRpc(addr *net.UDPAddr, payload rpc.Payload) (rpc.Payload, error) {
+	return n.storeRpc(addr, payload, true)
+}
+
+func (n *udpProtocolNode) storeRpc(addr *net.UDPAddr, payload rpc.Payload, tcp bool) (rpc.Payload, error) {
 	var request StoreRequest
-	err := proto.Unmarshal(payload, &request)
+	envelope, peer, err := n.openRequest(addr, tcp, n.storeServiceId, payload, &request)
 	if err != nil {
 		return nil, err
 	}
-	peer := NewPeer(BytesId(request.PeerId))
-	n.Connect(addr, peer)
-	err = n.dhtNode.Store(peer, BytesId(request.Key), request.Value)
+	if peer == nil {
+		return nil, ErrReceiver
+	}
+	ttl := time.Duration(request.TtlMillis) * time.Millisecond
+	err = n.dhtNode.Store(peer, BytesId(request.Key), request.Value, ttl)
 	if err != nil {
 		return nil, err
 	}
-	return nil, err
+	return n.sealResponse(n.storeServiceId, envelope, &StoreResponse{})
 }
 
+func (n *udpProtocolNode) AnnounceRpc(addr *net.UDPAddr, payload rpc.Payload) (rpc.Payload, error) {
+	return n.announceRpc(addr, payload, false)
+}
+
+func (n *udpProtocolNode) announceTcpRpc(addr *net.UDPAddr, payload rpc.Payload) (rpc.Payload, error) {
+	return n.announceRpc(addr, payload, true)
+}
+
+func (n *udpProtocolNode) announceRpc(addr *net.UDPAddr, payload rpc.Payload, tcp bool) (rpc.Payload, error) {
+	var request AnnounceRequest
+	envelope, peer, err := n.openRequest(addr, tcp, n.announceServiceId, payload, &request)
+	if err != nil {
+		return nil, err
+	}
+	if peer == nil {
+		return nil, ErrReceiver
+	}
+	ttl := time.Duration(request.TtlMillis) * time.Millisecond
+	err = n.dhtNode.Announce(peer, BytesId(request.Key), ttl)
+	if err != nil {
+		return nil, err
+	}
+	return n.sealResponse(n.announceServiceId, envelope, &AnnounceResponse{})
+}
+
+// ttlMillis rounds up so that sub millisecond ttl doesn't turn into no expiry.
+func ttlMillis(ttl time.Duration) uint64 {
+	return uint64((ttl + time.Millisecond - 1) / time.Millisecond)
+}
+
+var errValueTooLarge = errors.New("value too large for udp and tcp is not enabled")
+
 type udpProtocol struct {
 	addr         *net.UDPAddr
 	protocolNode *udpProtocolNode
+	// expected signer of responses, nil accepts any
+	id Id
+}
+
+// UdpAddr returns address of peer connected over udp, nil otherwise.
+func UdpAddr(peer *Peer) *net.UDPAddr {
+	if protocol, ok := peer.Proto.(*udpProtocol); ok {
+		return protocol.addr
+	}
+	return nil
 }
 
 func NewUdpProtocol(addr *net.UDPAddr, server *udpProtocolNode) *udpProtocol {
@@ -171,42 +590,101 @@ func NewUdpProtocol(addr *net.UDPAddr, server *udpProtocolNode) *udpProtocol {
 	}
 }
 
-func (p *udpProtocol) Ping(_ *Peer, randomId Id) (Id, error) {
-	request := PingRequest{
-		PeerId: p.protocolNode.dhtNode.Peer.Id.Bytes(),
-		RandomId: randomId.Bytes(),
+func (p *udpProtocol) tcpAddr() *net.TCPAddr {
+	return &net.TCPAddr{IP: p.addr.IP, Port: p.addr.Port, Zone: p.addr.Zone}
+}
+
+// call signs request and verifies signer of response and that it answers the request,
+// it returns id of the signer.
+// Request goes over tcp when forced or when it exceeds threshold and tcp is enabled.
+func (p *udpProtocol) call(
+	ctx context.Context,
+	serviceId rpc.ServiceId,
+	request, response proto.Message,
+	overTcp bool,
+) (Id, error) {
+	node := p.protocolNode
+	requestPayload, nonce, err := node.sealRequest(serviceId, p.id, request)
+	if err != nil {
+		return nil, err
+	}
+	var responsePayload rpc.Payload
+	overTcp = overTcp || node.tcpNode != nil && len(requestPayload) > node.tcpThreshold
+	encrypted := node.rpcNode.Encrypted() && !overTcp
+	if encrypted && p.id != nil {
+		// nothing is sealed for node other than the expected one
+		key, err := node.rpcNode.Handshake(p.addr)
+		if err != nil {
+			return nil, err
+		}
+		if !eq(KeyId(key), p.id) {
+			return nil, ErrIdentity
+		}
+	}
+	if overTcp {
+		responsePayload, err = node.tcpNode.CallContext(ctx, p.tcpAddr(), serviceId, requestPayload)
+	} else {
+		responsePayload, err = node.rpcNode.CallContext(ctx, p.addr, serviceId, requestPayload)
 	}
-	requestPayload, err := proto.Marshal(&request)
 	if err != nil {
 		return nil, err
 	}
-	responsePayload, err := p.protocolNode.rpcNode.Call(p.addr, p.protocolNode.pingServiceId, requestPayload)
+	envelope, id, err := node.open(serviceId, true, responsePayload, response)
 	if err != nil {
 		return nil, err
 	}
-	var response PingResponse
-	err = proto.Unmarshal(responsePayload, &response)
+	if p.id != nil && !eq(p.id, id) {
+		return nil, ErrIdentity
+	}
+	if encrypted && !eq(KeyId(node.rpcNode.PeerKey(p.addr)), id) {
+		return nil, ErrIdentity
+	}
+	if !bytes.Equal(envelope.Nonce, nonce) || !eq(BytesId(envelope.Receiver), node.dhtNode.Peer.Id) {
+		return nil, ErrReplay
+	}
+	return id, nil
+}
+
+func (p *udpProtocol) Ping(sender *Peer, randomId Id) (Id, error) {
+	return p.PingContext(context.Background(), sender, randomId)
+}
+
+func (p *udpProtocol) PingContext(ctx context.Context, _ *Peer, randomId Id) (Id, error) {
+	response, _, err := p.ping(ctx, randomId)
 	if err != nil {
 		return nil, err
 	}
 	return BytesId(response.RandomId), nil
 }
 
-func (p *udpProtocol) FindNode(_ *Peer, id Id) (*FindResult, error) {
-	request := FindRequest{
+// ping returns response and id of the responder.
+func (p *udpProtocol) ping(ctx context.Context, randomId Id) (*PingResponse, Id, error) {
+	request := PingRequest{
 		PeerId: p.protocolNode.dhtNode.Peer.Id.Bytes(),
-		Id: id.Bytes(),
+		RandomId: randomId.Bytes(),
 	}
-	requestPayload, err := proto.Marshal(&request)
+	var response PingResponse
+	id, err := p.call(ctx, p.protocolNode.pingServiceId, &request, &response, false)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	responsePayload, err := p.protocolNode.rpcNode.Call(p.addr, p.protocolNode.findNodeServiceId, requestPayload)
-	if err != nil {
-		return nil, err
+	if !eq(BytesId(response.PeerId), id) {
+		return nil, nil, ErrIdentity
+	}
+	return &response, id, nil
+}
+
+func (p *udpProtocol) FindNode(sender *Peer, id Id) (*FindResult, error) {
+	return p.FindNodeContext(context.Background(), sender, id)
+}
+
+func (p *udpProtocol) FindNodeContext(ctx context.Context, _ *Peer, id Id) (*FindResult, error) {
+	request := FindRequest{
+		PeerId: p.protocolNode.dhtNode.Peer.Id.Bytes(),
+		Id: id.Bytes(),
 	}
 	var response FindNodeResponse
-	err = proto.Unmarshal(responsePayload, &response)
+	_, err := p.call(ctx, p.protocolNode.findNodeServiceId, &request, &response, false)
 	if err != nil {
 		return nil, err
 	}
@@ -228,54 +706,86 @@ func (p *udpProtocol) FindNode(_ *Peer, id Id) (*FindResult, error) {
 
 
 func (p *udpProtocol) FindValue(sender *Peer, key Id) (*FindResult, error) {
+	return p.FindValueContext(context.Background(), sender, key)
+}
+
+func (p *udpProtocol) FindValueContext(ctx context.Context, sender *Peer, key Id) (*FindResult, error) {
 	request := FindRequest{
 		PeerId: p.protocolNode.dhtNode.Peer.Id.Bytes(),
 		Id: key.Bytes(),
 	}
-	requestPayload, err := proto.Marshal(&request)
-	if err != nil {
-		return nil, err
-	}
-	responsePayload, err := p.protocolNode.rpcNode.Call(
-		p.addr, p.protocolNode.findValueServiceId, requestPayload)
-	if err != nil {
-		return nil, err
-	}
 	var response FindValueResponse
-	err = proto.Unmarshal(responsePayload, &response)
+	_, err := p.call(ctx, p.protocolNode.findValueServiceId, &request, &response, false)
 	if err != nil {
 		return nil, err
 	}
-	var peers []*Peer
-	if response.Nodes != nil {
-		peers = make([]*Peer, len(response.Nodes))
-		for i := 0; i < len(response.Nodes); i++ {
-			n := response.Nodes[i]
-			peer := &Peer{Id: BytesId(n.NodeId), LastSeen: time.Now()}
-			addr := &net.UDPAddr{
-				IP:   n.Addr.IP,
-				Port: int(n.Addr.Port),
-				Zone: n.Addr.Zone,
-			}
-			p.protocolNode.Connect(addr, peer)
-			peers[i] = peer
+	if response.Truncated {
+		if p.protocolNode.tcpNode == nil {
+			return nil, errValueTooLarge
+		}
+		response.Reset()
+		_, err = p.call(ctx, p.protocolNode.findValueServiceId, &request, &response, true)
+		if err != nil {
+			return nil, err
 		}
 	}
-	result := &FindResult{peers: peers, value: response.Value}
+	ttl := time.Duration(response.TtlMillis) * time.Millisecond
+	result := &FindResult{
+		peers:     p.peers(response.Nodes),
+		value:     response.Value,
+		ttl:       ttl,
+		providers: p.peers(response.Providers),
+	}
 	return result, nil
 }
 
-func (p *udpProtocol) Store(sender *Peer, key Id, value []byte) error {
+// peers decodes nodes, nil nodes stay nil.
+func (p *udpProtocol) peers(nodes []*UdpNode) []*Peer {
+	if nodes == nil {
+		return nil
+	}
+	peers := make([]*Peer, 0, len(nodes))
+	for _, n := range nodes {
+		if n.Addr == nil {
+			continue
+		}
+		peer := &Peer{Id: BytesId(n.NodeId), LastSeen: time.Now()}
+		addr := &net.UDPAddr{
+			IP:   n.Addr.IP,
+			Port: int(n.Addr.Port),
+			Zone: n.Addr.Zone,
+		}
+		p.protocolNode.Connect(addr, peer)
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+func (p *udpProtocol) Store(sender *Peer, key Id, value []byte, ttl time.Duration) error {
+	return p.StoreContext(context.Background(), sender, key, value, ttl)
+}
+
+func (p *udpProtocol) StoreContext(ctx context.Context, sender *Peer, key Id, value []byte, ttl time.Duration) error {
 	request := StoreRequest{
 		PeerId: p.protocolNode.dhtNode.Peer.Id.Bytes(),
 		Key: key.Bytes(),
 		Value: value,
+		TtlMillis: ttlMillis(ttl),
 	}
-	requestPayload, err := proto.Marshal(&request)
-	if err != nil {
-		return err
+	_, err := p.call(ctx, p.protocolNode.storeServiceId, &request, &StoreResponse{}, false)
+	return err
+}
+
+func (p *udpProtocol) Announce(sender *Peer, key Id, ttl time.Duration) error {
+	return p.AnnounceContext(context.Background(), sender, key, ttl)
+}
+
+func (p *udpProtocol) AnnounceContext(ctx context.Context, sender *Peer, key Id, ttl time.Duration) error {
+	request := AnnounceRequest{
+		PeerId:    p.protocolNode.dhtNode.Peer.Id.Bytes(),
+		Key:       key.Bytes(),
+		TtlMillis: ttlMillis(ttl),
 	}
-	_, err = p.protocolNode.rpcNode.Call(
-		p.addr, p.protocolNode.storeServiceId, requestPayload)
+	_, err := p.call(ctx, p.protocolNode.announceServiceId, &request, &AnnounceResponse{}, false)
 	return err
 }
@@ -1,12 +1,17 @@
 package dht
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/mduszyk/gopeers/rpc"
 	"github.com/mduszyk/gopeers/store"
 	"log"
 	"math/big"
+	"net"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -18,26 +23,20 @@ func TestUdpPing(t *testing.T) {
 
 	node1ProtoServer, err := StartUdpProtocolNode(
 		20, 5, 3,
-		MathRandId(), store.NewMemStorage(), "localhost:4001", callTimeout, bufferSize)
+		MathRandIdentity(), store.NewMemStorage(), "localhost:4001", callTimeout, bufferSize)
 	if err != nil {
 		t.Errorf("failed creating node: %v\n", err)
 	}
 
 	node2ProtoServer, err := StartUdpProtocolNode(
 		20, 5, 3,
-		MathRandId(), store.NewMemStorage(),"localhost:4002", callTimeout, bufferSize)
+		MathRandIdentity(), store.NewMemStorage(),"localhost:4002", callTimeout, bufferSize)
 	if err != nil {
 		t.Errorf("failed creating node: %v\n", err)
 	}
 
-	node1Peer, err := NewRandomIdPeer()
-	if err != nil {
-		t.Errorf("failed creating peer: %v\n", err)
-	}
-	node2Peer, err := NewRandomIdPeer()
-	if err != nil {
-		t.Errorf("failed creating peer: %v\n", err)
-	}
+	node1Peer := NewPeer(node1ProtoServer.dhtNode.Peer.Id)
+	node2Peer := NewPeer(node2ProtoServer.dhtNode.Peer.Id)
 
 	node1ProtoServer.Connect(node2ProtoServer.rpcNode.Addr, node2Peer)
 	node2ProtoServer.Connect(node1ProtoServer.rpcNode.Addr, node1Peer)
@@ -60,12 +59,12 @@ func TestUdpPing(t *testing.T) {
 
 func TestUdpFindNode(t *testing.T) {
 	node1, err := StartUdpProtocolNode(
-		20, 5, 3, MathRandId(), store.NewMemStorage(), "localhost:5001", callTimeout, bufferSize)
+		20, 5, 3, MathRandIdentity(), store.NewMemStorage(), "localhost:5001", callTimeout, bufferSize)
 	if err != nil {
 		t.Errorf("failed creating node: %v\n", err)
 	}
 
-	node2, err := StartUdpProtocolNode(20, 5, 3, MathRandId(), store.NewMemStorage(),"localhost:5002", callTimeout, bufferSize)
+	node2, err := StartUdpProtocolNode(20, 5, 3, MathRandIdentity(), store.NewMemStorage(),"localhost:5002", callTimeout, bufferSize)
 	if err != nil {
 		t.Errorf("failed creating node: %v\n", err)
 	}
@@ -89,6 +88,32 @@ func TestUdpFindNode(t *testing.T) {
 	}
 }
 
+func TestUdpFindNodeTarget(t *testing.T) {
+	node1 := startUdpNode(t, MathRandIdentity())
+	defer node1.Close()
+	node2 := startUdpNode(t, MathRandIdentity())
+	defer node2.Close()
+	node3 := startUdpNode(t, MathRandIdentity())
+	defer node3.Close()
+	node3Peer := NewPeer(node3.dhtNode.Peer.Id)
+	node1.Connect(node3.rpcNode.Addr, node3Peer)
+	node1.dhtNode.add(node3Peer)
+
+	node1Peer := NewPeer(node1.dhtNode.Peer.Id)
+	node2.Connect(node1.rpcNode.Addr, node1Peer)
+	// node2 is added to node1 by the request, result has to be ordered by distance to target
+	findResult, err := node1Peer.Proto.FindNode(node2.dhtNode.Peer, node3.dhtNode.Peer.Id)
+	if err != nil {
+		t.Fatalf("failed finding nodes: %v\n", err)
+	}
+	if len(findResult.peers) != 2 || !eq(findResult.peers[0].Id, node3.dhtNode.Peer.Id) {
+		t.Errorf("peer closest to target should be found first\n")
+	}
+	if addr := UdpAddr(findResult.peers[0]); addr == nil || addr.Port != node3.rpcNode.Addr.Port {
+		t.Errorf("found peer should have its address: %v\n", addr)
+	}
+}
+
 func TestUdpJoin(t *testing.T) {
 	n := 100
 	k := 20
@@ -102,7 +127,7 @@ func TestUdpJoin(t *testing.T) {
 	for i := 0; i < n; i++ {
 		port := basePort + i
 		address := fmt.Sprintf("localhost:%d", port)
-		protoNode, err := StartUdpProtocolNode(k, b, alpha, MathRandId(), store.NewMemStorage(), address, callTimeout, bufferSize)
+		protoNode, err := StartUdpProtocolNode(k, b, alpha, MathRandIdentity(), store.NewMemStorage(), address, callTimeout, bufferSize)
 		if err != nil {
 			t.Errorf("failed creating node: %v\n", err)
 		}
@@ -174,7 +199,7 @@ func TestUdpLookupSetGet(t *testing.T) {
 	for i := 0; i < n; i++ {
 		port := basePort + i
 		address := fmt.Sprintf("localhost:%d", port)
-		protoNode, err := StartUdpProtocolNode(k, b, alpha, MathRandId(), store.NewMemStorage(), address, callTimeout, bufferSize)
+		protoNode, err := StartUdpProtocolNode(k, b, alpha, MathRandIdentity(), store.NewMemStorage(), address, callTimeout, bufferSize)
 		if err != nil {
 			t.Errorf("failed creating node: %v\n", err)
 		}
@@ -245,4 +270,485 @@ func TestUdpLookupSetGet(t *testing.T) {
 	}
 
 	log.Printf("Done")
-}
\ No newline at end of file
+}
+func TestUdpStoreTTL(t *testing.T) {
+	node1, err := StartUdpProtocolNode(
+		20, 5, 3, MathRandIdentity(), store.NewMemStorage(), "localhost:", callTimeout, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating node: %v\n", err)
+	}
+	defer node1.Close()
+	node2, err := StartUdpProtocolNode(
+		20, 5, 3, MathRandIdentity(), store.NewMemStorage(), "localhost:", callTimeout, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating node: %v\n", err)
+	}
+	defer node2.Close()
+
+	node2Peer := NewPeer(node2.dhtNode.Peer.Id)
+	node1.Connect(node2.rpcNode.Addr, node2Peer)
+
+	key := MathRandId()
+	ttl := time.Hour
+	err = node2Peer.Proto.Store(node1.dhtNode.Peer, key, []byte("ttl"), ttl)
+	if err != nil {
+		t.Errorf("failed storing value: %v\n", err)
+	}
+	expiry, err := node2.dhtNode.Storage.Expiry(key.Bytes())
+	if err != nil {
+		t.Errorf("failed getting expiry: %v\n", err)
+	}
+	if d := time.Until(expiry); d < ttl - time.Minute || d > ttl {
+		t.Errorf("got invalid ttl: %v\n", d)
+	}
+}
+
+func TestUdpShutdown(t *testing.T) {
+	node1, err := StartUdpProtocolNode(
+		20, 5, 3, MathRandIdentity(), store.NewMemStorage(), "localhost:", time.Second, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating node: %v\n", err)
+	}
+	node2, err := StartUdpProtocolNode(
+		20, 5, 3, MathRandIdentity(), store.NewMemStorage(), "localhost:", time.Second, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating node: %v\n", err)
+	}
+	defer node2.Close()
+
+	node1Peer := NewPeer(node1.dhtNode.Peer.Id)
+	node2.Connect(node1.rpcNode.Addr, node1Peer)
+	node2Peer := NewPeer(node2.dhtNode.Peer.Id)
+	node1.Connect(node2.rpcNode.Addr, node2Peer)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = node1.Shutdown(ctx)
+	if err != nil {
+		t.Errorf("failed shutting down: %v\n", err)
+	}
+
+	_, err = node1Peer.Proto.Ping(node2.dhtNode.Peer, MathRandId())
+	if err == nil {
+		t.Errorf("ping to closed node should fail\n")
+	}
+	_, err = node2Peer.Proto.Ping(node1.dhtNode.Peer, MathRandId())
+	if err != rpc.ErrClosed {
+		t.Errorf("ping from closed node should fail with ErrClosed, got: %v\n", err)
+	}
+}
+
+func TestUdpDiscover(t *testing.T) {
+	node1 := startUdpNode(t, MathRandIdentity())
+	defer node1.Close()
+	node2 := startUdpNode(t, MathRandIdentity())
+	defer node2.Close()
+
+	peer, err := node1.Discover(context.Background(), node2.rpcNode.Addr)
+	if err != nil {
+		t.Fatalf("failed discovering: %v\n", err)
+	}
+	if !eq(peer.Id, node2.dhtNode.Peer.Id) {
+		t.Errorf("discovered invalid id: %x\n", peer.Id)
+	}
+	err = node1.dhtNode.Join(peer)
+	if err != nil {
+		t.Errorf("failed joining discovered peer: %v\n", err)
+	}
+	if tableAddr(node2, node1.dhtNode.Peer.Id) == nil {
+		t.Errorf("joining node should be added to discovered node\n")
+	}
+}
+
+func startUdpNode(t *testing.T, identity *Identity) *udpProtocolNode {
+	node, err := StartUdpProtocolNode(
+		20, 5, 3, identity, store.NewMemStorage(), "localhost:", time.Second, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating node: %v\n", err)
+	}
+	return node
+}
+
+// newUdpNode returns node which isn't running yet, its services may be replaced before it runs.
+func newUdpNode(t *testing.T, identity *Identity) *udpProtocolNode {
+	rpcNode, err := rpc.NewUdpNode("localhost:", nil, time.Second, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	node, err := NewUdpProtocolNode(rpcNode, NewKadNode(20, 5, 3, identity.Id(), store.NewMemStorage()), identity)
+	if err != nil {
+		t.Fatalf("failed creating node: %v\n", err)
+	}
+	return node
+}
+
+// tableAddr returns address of peer with id in routing table of node, nil if it's not there.
+func tableAddr(node *udpProtocolNode, id Id) *net.UDPAddr {
+	for _, b := range node.dhtNode.Buckets() {
+		for _, peer := range b.Peers {
+			if eq(peer.Id, id) {
+				return UdpAddr(&peer)
+			}
+		}
+	}
+	return nil
+}
+
+func startTcpNode(t *testing.T) *udpProtocolNode {
+	rpcNode, err := rpc.NewUdpNode("localhost:", nil, time.Second, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	tcpNode, err := rpc.NewTcpNode(rpcNode.Addr.String(), nil, time.Second)
+	if err != nil {
+		t.Fatalf("failed creating tcp node: %v\n", err)
+	}
+	identity := MathRandIdentity()
+	node, err := NewUdpProtocolNode(rpcNode, NewKadNode(20, 5, 3, identity.Id(), store.NewMemStorage()), identity)
+	if err != nil {
+		t.Fatalf("failed creating node: %v\n", err)
+	}
+	node.EnableTcp(tcpNode, int(bufferSize) - 128)
+	go rpcNode.Run()
+	go tcpNode.Run()
+	return node
+}
+
+func TestTcpLargeValue(t *testing.T) {
+	node1 := startTcpNode(t)
+	defer node1.Close()
+	node2 := startTcpNode(t)
+	defer node2.Close()
+	node3 := startUdpNode(t, MathRandIdentity())
+	defer node3.Close()
+
+	node2Peer := NewPeer(node2.dhtNode.Peer.Id)
+	node1.Connect(node2.rpcNode.Addr, node2Peer)
+	key := MathRandId()
+	value := make([]byte, 5 * bufferSize)
+	for i := range value {
+		value[i] = byte(i)
+	}
+	err := node2Peer.Proto.Store(node1.dhtNode.Peer, key, value, time.Hour)
+	if err != nil {
+		t.Fatalf("failed storing large value: %v\n", err)
+	}
+	stored, err := node2.dhtNode.Storage.Get(key.Bytes())
+	if err != nil || !reflect.DeepEqual(stored, value) {
+		t.Errorf("large value not stored: %v\n", err)
+	}
+	callerAddr := tableAddr(node2, node1.dhtNode.Peer.Id)
+	if callerAddr == nil || callerAddr.Port != node1.rpcNode.Addr.Port {
+		t.Errorf("tcp caller should be added with its udp address, got: %v\n", callerAddr)
+	}
+
+	result, err := node2Peer.Proto.FindValue(node1.dhtNode.Peer, key)
+	if err != nil {
+		t.Fatalf("failed finding large value: %v\n", err)
+	}
+	if !reflect.DeepEqual(result.Value(), value) || result.TTL() <= 0 {
+		t.Errorf("large value should be fetched over tcp\n")
+	}
+
+	// small values stay on udp
+	small := MathRandId()
+	err = node2Peer.Proto.Store(node1.dhtNode.Peer, small, []byte("small"), time.Hour)
+	if err != nil {
+		t.Errorf("failed storing small value: %v\n", err)
+	}
+	result, err = node2Peer.Proto.FindValue(node1.dhtNode.Peer, small)
+	if err != nil || string(result.Value()) != "small" {
+		t.Errorf("failed finding small value: %v\n", err)
+	}
+
+	node3Peer := NewPeer(node2.dhtNode.Peer.Id)
+	node3.Connect(node2.rpcNode.Addr, node3Peer)
+	_, err = node3Peer.Proto.FindValue(node3.dhtNode.Peer, key)
+	if err != errValueTooLarge {
+		t.Errorf("node without tcp should fail with errValueTooLarge, got: %v\n", err)
+	}
+}
+
+func TestTcpClaimedAddr(t *testing.T) {
+	target := startTcpNode(t)
+	defer target.Close()
+	victim := startUdpNode(t, MathRandIdentity())
+	defer victim.Close()
+	rpcNode, err := rpc.NewUdpNode("localhost:", nil, time.Second, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	tcpNode, err := rpc.NewTcpNode(rpcNode.Addr.String(), nil, time.Second)
+	if err != nil {
+		t.Fatalf("failed creating tcp node: %v\n", err)
+	}
+	// attacker claims it listens on port of victim
+	tcpNode.Addr = &net.TCPAddr{IP: tcpNode.Addr.IP, Port: victim.rpcNode.Addr.Port}
+	identity := MathRandIdentity()
+	attacker, err := NewUdpProtocolNode(rpcNode, NewKadNode(20, 5, 3, identity.Id(), store.NewMemStorage()), identity)
+	if err != nil {
+		t.Fatalf("failed creating node: %v\n", err)
+	}
+	attacker.EnableTcp(tcpNode, int(bufferSize) - 128)
+	go rpcNode.Run()
+	go tcpNode.Run()
+	defer attacker.Close()
+
+	peer := NewPeer(target.dhtNode.Peer.Id)
+	attacker.Connect(target.rpcNode.Addr, peer)
+	value := make([]byte, 2 * bufferSize)
+	err = peer.Proto.Store(attacker.dhtNode.Peer, MathRandId(), value, time.Hour)
+	if err == nil || err.Error() != ErrCaller.Error() {
+		t.Errorf("caller not answering at claimed address should be rejected, got: %v\n", err)
+	}
+	if addr := tableAddr(target, attacker.dhtNode.Peer.Id); addr != nil {
+		t.Errorf("caller should not be bound to claimed address: %v\n", addr)
+	}
+}
+
+func TestUdpImpersonation(t *testing.T) {
+	target := startUdpNode(t, MathRandIdentity())
+	defer target.Close()
+	victim := startUdpNode(t, MathRandIdentity())
+	defer victim.Close()
+	attacker := newUdpNode(t, MathRandIdentity())
+	// attacker answers at address announced for victim, whoever request is addressed to
+	attacker.rpcNode.Services[attacker.pingServiceId] = func(_ *net.UDPAddr, payload rpc.Payload) (rpc.Payload, error) {
+		var request PingRequest
+		envelope, _, err := attacker.open(attacker.pingServiceId, false, payload, &request)
+		if err != nil {
+			return nil, err
+		}
+		response := PingResponse{RandomId: request.RandomId, PeerId: attacker.dhtNode.Peer.Id.Bytes()}
+		return attacker.sealResponse(attacker.pingServiceId, envelope, &response)
+	}
+	go attacker.rpcNode.Run()
+	defer attacker.Close()
+
+	// attacker claims id of victim
+	request := PingRequest{PeerId: victim.dhtNode.Peer.Id.Bytes(), RandomId: MathRandId().Bytes()}
+	payload, _, err := attacker.sealRequest(attacker.pingServiceId, target.dhtNode.Peer.Id, &request)
+	if err != nil {
+		t.Fatalf("failed sealing request: %v\n", err)
+	}
+	_, err = attacker.rpcNode.Call(target.rpcNode.Addr, target.pingServiceId, payload)
+	if err == nil || err.Error() != ErrIdentity.Error() {
+		t.Errorf("request with id of other node should be rejected, got: %v\n", err)
+	}
+	// signature doesn't cover other service
+	_, err = attacker.rpcNode.Call(target.rpcNode.Addr, target.findNodeServiceId, payload)
+	if err == nil {
+		t.Errorf("request signed for other service should be rejected\n")
+	}
+	for _, b := range target.dhtNode.Buckets() {
+		if len(b.Peers) > 0 {
+			t.Errorf("rejected sender should not be added: %v\n", b.Peers)
+		}
+	}
+
+	peer := NewPeer(victim.dhtNode.Peer.Id)
+	target.Connect(attacker.rpcNode.Addr, peer)
+	_, err = peer.Proto.Ping(target.dhtNode.Peer, MathRandId())
+	if err != ErrIdentity {
+		t.Errorf("response signed by other node should be rejected, got: %v\n", err)
+	}
+	peer = NewPeer(victim.dhtNode.Peer.Id)
+	target.Connect(victim.rpcNode.Addr, peer)
+	_, err = peer.Proto.Ping(target.dhtNode.Peer, MathRandId())
+	if err != nil {
+		t.Errorf("failed pinging: %v\n", err)
+	}
+}
+
+func TestUdpReplay(t *testing.T) {
+	target := startUdpNode(t, MathRandIdentity())
+	defer target.Close()
+	other := startUdpNode(t, MathRandIdentity())
+	defer other.Close()
+	victim := startUdpNode(t, MathRandIdentity())
+	defer victim.Close()
+	attacker := newUdpNode(t, MathRandIdentity())
+	// attacker answers ping with response it captured
+	captured := make(chan rpc.Payload, 1)
+	attacker.rpcNode.Services[attacker.pingServiceId] = func(*net.UDPAddr, rpc.Payload) (rpc.Payload, error) {
+		select {
+		case response := <-captured:
+			return response, nil
+		default:
+			return nil, errors.New("no response captured")
+		}
+	}
+	go attacker.rpcNode.Run()
+	defer attacker.Close()
+
+	// attacker captured request of victim
+	request := FindRequest{PeerId: victim.dhtNode.Peer.Id.Bytes(), Id: MathRandId().Bytes()}
+	payload, _, err := victim.sealRequest(victim.findNodeServiceId, target.dhtNode.Peer.Id, &request)
+	if err != nil {
+		t.Fatalf("failed sealing request: %v\n", err)
+	}
+	_, err = victim.rpcNode.Call(target.rpcNode.Addr, target.findNodeServiceId, payload)
+	if err != nil {
+		t.Fatalf("failed finding node: %v\n", err)
+	}
+	_, err = attacker.rpcNode.Call(target.rpcNode.Addr, target.findNodeServiceId, payload)
+	if err == nil || err.Error() != ErrReplay.Error() {
+		t.Errorf("replayed request should be rejected, got: %v\n", err)
+	}
+	if addr := tableAddr(target, victim.dhtNode.Peer.Id); addr == nil || addr.Port != victim.rpcNode.Addr.Port {
+		t.Errorf("victim should stay at its address\n")
+	}
+	_, err = attacker.rpcNode.Call(other.rpcNode.Addr, other.findNodeServiceId, payload)
+	if err == nil || err.Error() != ErrReceiver.Error() {
+		t.Errorf("request addressed to other node should be rejected, got: %v\n", err)
+	}
+	for _, b := range other.dhtNode.Buckets() {
+		if len(b.Peers) > 0 {
+			t.Errorf("replayed sender should not be added: %v\n", b.Peers)
+		}
+	}
+
+	// attacker answers at address announced for victim with captured response of victim
+	ping := PingRequest{PeerId: target.dhtNode.Peer.Id.Bytes(), RandomId: MathRandId().Bytes()}
+	payload, _, err = target.sealRequest(target.pingServiceId, victim.dhtNode.Peer.Id, &ping)
+	if err != nil {
+		t.Fatalf("failed sealing request: %v\n", err)
+	}
+	response, err := target.rpcNode.Call(victim.rpcNode.Addr, victim.pingServiceId, payload)
+	if err != nil {
+		t.Fatalf("failed pinging: %v\n", err)
+	}
+	captured <- response
+	peer := NewPeer(victim.dhtNode.Peer.Id)
+	target.Connect(attacker.rpcNode.Addr, peer)
+	_, err = peer.Proto.Ping(target.dhtNode.Peer, BytesId(ping.RandomId))
+	if err != ErrReplay {
+		t.Errorf("response to other request should be rejected, got: %v\n", err)
+	}
+}
+
+func startEncryptedNode(t *testing.T, identity *Identity) *udpProtocolNode {
+	rpcNode, err := rpc.NewUdpNode("localhost:", nil, time.Second, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	rpcNode.EnableEncryption(identity.PrivateKey)
+	node, err := NewUdpProtocolNode(rpcNode, NewKadNode(20, 5, 3, identity.Id(), store.NewMemStorage()), identity)
+	if err != nil {
+		t.Fatalf("failed creating node: %v\n", err)
+	}
+	go rpcNode.Run()
+	return node
+}
+
+func TestUdpEncrypted(t *testing.T) {
+	identity1 := MathRandIdentity()
+	node1 := startEncryptedNode(t, identity1)
+	defer node1.Close()
+	identity2 := MathRandIdentity()
+	node2 := startEncryptedNode(t, identity2)
+	defer node2.Close()
+
+	node2Peer := NewPeer(node2.dhtNode.Peer.Id)
+	node1.Connect(node2.rpcNode.Addr, node2Peer)
+	_, err := node2Peer.Proto.Ping(node1.dhtNode.Peer, MathRandId())
+	if err != nil {
+		t.Fatalf("failed pinging: %v\n", err)
+	}
+	if !identity2.PublicKey.Equal(node1.rpcNode.PeerKey(node2.rpcNode.Addr)) {
+		t.Errorf("session should be bound to node key\n")
+	}
+	key := MathRandId()
+	value := make([]byte, 5 * bufferSize)
+	err = node2Peer.Proto.Store(node1.dhtNode.Peer, key, value, time.Hour)
+	if err != nil {
+		t.Fatalf("failed storing: %v\n", err)
+	}
+	result, err := node2Peer.Proto.FindValue(node1.dhtNode.Peer, key)
+	if err != nil || !reflect.DeepEqual(result.Value(), value) {
+		t.Errorf("failed finding value: %v\n", err)
+	}
+
+	plain := startUdpNode(t, MathRandIdentity())
+	defer plain.Close()
+	peer := NewPeer(node1.dhtNode.Peer.Id)
+	plain.Connect(node1.rpcNode.Addr, peer)
+	_, err = peer.Proto.Ping(plain.dhtNode.Peer, MathRandId())
+	if err == nil {
+		t.Errorf("plaintext ping should be dropped\n")
+	}
+}
+
+func TestUdpEncryptedImpersonation(t *testing.T) {
+	target := startEncryptedNode(t, MathRandIdentity())
+	defer target.Close()
+	victim := startEncryptedNode(t, MathRandIdentity())
+	defer victim.Close()
+	attacker := startEncryptedNode(t, MathRandIdentity())
+	defer attacker.Close()
+
+	// attacker answers at address announced for victim
+	var calls int32
+	ping := attacker.rpcNode.Services[attacker.pingServiceId]
+	attacker.rpcNode.Services[attacker.pingServiceId] = func(addr *net.UDPAddr, payload rpc.Payload) (rpc.Payload, error) {
+		atomic.AddInt32(&calls, 1)
+		return ping(addr, payload)
+	}
+	peer := NewPeer(victim.dhtNode.Peer.Id)
+	target.Connect(attacker.rpcNode.Addr, peer)
+	_, err := peer.Proto.Ping(target.dhtNode.Peer, MathRandId())
+	if err != ErrIdentity {
+		t.Errorf("session with other key should be rejected, got: %v\n", err)
+	}
+	if atomic.LoadInt32(&calls) != 0 {
+		t.Errorf("request should not be sent over session with other key\n")
+	}
+
+	// attacker relays request of victim over its own session
+	request := FindRequest{PeerId: victim.dhtNode.Peer.Id.Bytes(), Id: MathRandId().Bytes()}
+	payload, _, err := victim.sealRequest(victim.findNodeServiceId, target.dhtNode.Peer.Id, &request)
+	if err != nil {
+		t.Fatalf("failed sealing request: %v\n", err)
+	}
+	_, err = attacker.rpcNode.Call(target.rpcNode.Addr, target.findNodeServiceId, payload)
+	if err == nil || err.Error() != ErrIdentity.Error() {
+		t.Errorf("request relayed over session with other key should be rejected, got: %v\n", err)
+	}
+	if target.dhtNode.Tree.Find(victim.dhtNode.Peer.Id).Bucket.Contains(victim.dhtNode.Peer.Id) {
+		t.Errorf("relayed sender should not be added\n")
+	}
+}
+
+func TestUdpAnnounce(t *testing.T) {
+	node1 := startUdpNode(t, MathRandIdentity())
+	defer node1.Close()
+	node2 := startUdpNode(t, MathRandIdentity())
+	defer node2.Close()
+	node3 := startUdpNode(t, MathRandIdentity())
+	defer node3.Close()
+
+	key := MathRandId()
+	for _, node := range []*udpProtocolNode{node2, node3} {
+		peer := NewPeer(node1.dhtNode.Peer.Id)
+		node.Connect(node1.rpcNode.Addr, peer)
+		err := peer.Proto.Announce(node.dhtNode.Peer, key, time.Hour)
+		if err != nil {
+			t.Fatalf("failed announcing: %v\n", err)
+		}
+	}
+
+	peer := NewPeer(node1.dhtNode.Peer.Id)
+	node3.Connect(node1.rpcNode.Addr, peer)
+	result, err := peer.Proto.FindValue(node3.dhtNode.Peer, key)
+	if err != nil {
+		t.Fatalf("failed finding value: %v\n", err)
+	}
+	if len(result.Providers()) != 2 {
+		t.Fatalf("expected 2 providers, got: %d\n", len(result.Providers()))
+	}
+	for _, provider := range result.Providers() {
+		if eq(provider.Id, node2.dhtNode.Peer.Id) && UdpAddr(provider).Port != node2.rpcNode.Addr.Port {
+			t.Errorf("provider should be returned with its address: %v\n", UdpAddr(provider))
+		}
+	}
+}
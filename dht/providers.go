@@ -0,0 +1,179 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"github.com/mduszyk/gopeers/logging"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNoPeers = errors.New("no peers to announce to")
+
+var ErrProviderLimit = errors.New("too many provided keys")
+
+type provider struct {
+	peer   *Peer
+	expiry time.Time
+}
+
+// addProvider refreshes expiry of known provider, when key has MaxProviders
+// the one expiring soonest is replaced. New provider is rejected with ErrProviderLimit
+// if the key would exceed MaxProviderKeys or the peer MaxPeerProviderKeys.
+func (node *KadNode) addProvider(key Id, peer *Peer, ttl time.Duration) error {
+	if ttl <= 0 || ttl > node.ProviderTTL {
+		ttl = node.ProviderTTL
+	}
+	entry := &provider{peer, node.Clock().Add(ttl)}
+	k := string(key.Bytes())
+	node.providersMutex.Lock()
+	defer node.providersMutex.Unlock()
+	entries, known := node.providers[k]
+	soonest := -1
+	for i, e := range entries {
+		if eq(e.peer.Id, peer.Id) {
+			entries[i] = entry
+			return nil
+		}
+		if soonest < 0 || e.expiry.Before(entries[soonest].expiry) {
+			soonest = i
+		}
+	}
+	p := string(peer.Id.Bytes())
+	if !known && len(node.providers) >= node.MaxProviderKeys || node.provided[p] >= node.MaxPeerProviderKeys {
+		return ErrProviderLimit
+	}
+	if len(entries) < node.MaxProviders {
+		node.providers[k] = append(entries, entry)
+	} else if soonest > -1 {
+		node.releaseProvider(entries[soonest].peer)
+		entries[soonest] = entry
+	} else {
+		return nil
+	}
+	node.provided[p]++
+	return nil
+}
+
+// releaseProvider decrements number of keys provided by peer, caller holds providersMutex.
+func (node *KadNode) releaseProvider(peer *Peer) {
+	p := string(peer.Id.Bytes())
+	if node.provided[p] <= 1 {
+		delete(node.provided, p)
+	} else {
+		node.provided[p]--
+	}
+}
+
+func (node *KadNode) providersOf(key Id) []*Peer {
+	now := node.Clock()
+	node.providersMutex.Lock()
+	defer node.providersMutex.Unlock()
+	var peers []*Peer
+	for _, e := range node.providers[string(key.Bytes())] {
+		if now.Before(e.expiry) {
+			peers = append(peers, e.peer)
+		}
+	}
+	return peers
+}
+
+func (node *KadNode) expireProviders(now time.Time) {
+	node.providersMutex.Lock()
+	defer node.providersMutex.Unlock()
+	for k, entries := range node.providers {
+		live := entries[:0]
+		for _, e := range entries {
+			if now.Before(e.expiry) {
+				live = append(live, e)
+			} else {
+				node.releaseProvider(e.peer)
+			}
+		}
+		if len(live) == 0 {
+			delete(node.providers, k)
+		} else {
+			node.providers[k] = live
+		}
+	}
+}
+
+// mergeProviders appends providers not seen yet.
+func mergeProviders(providers []*Peer, seen map[string]bool, found []*Peer) []*Peer {
+	for _, p := range found {
+		key := string(p.Id.Bytes())
+		if !seen[key] {
+			seen[key] = true
+			providers = append(providers, p)
+		}
+	}
+	return providers
+}
+
+func (node *KadNode) Provide(key []byte, ttl time.Duration) error {
+	return node.ProvideContext(context.Background(), key, ttl)
+}
+
+// ProvideContext announces the node as provider of key to the k closest peers,
+// announcement expires after ttl capped by ProviderTTL of the peers, so it has to be repeated.
+// It returns ErrNoPeers if the node doesn't know any peer.
+func (node *KadNode) ProvideContext(ctx context.Context, key []byte, ttl time.Duration) error {
+	id := BytesId(key)
+	findResult, err := node.LookupContext(ctx, id, false)
+	if err != nil {
+		return err
+	}
+	if len(findResult.peers) == 0 {
+		return ErrNoPeers
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(findResult.peers))
+	var failures int32
+	parallelize(findResult.peers, func(peer *Peer) {
+		defer wg.Done()
+		err := peer.Proto.AnnounceContext(ctx, node.Peer, id, ttl)
+		if err != nil {
+			atomic.AddInt32(&failures, 1)
+			node.Logger.Log(logging.Debug, "announce failed",
+				logging.F("peer", hexId(peer.Id)), logging.F("key", hexId(id)), logging.F("error", err))
+			if ctx.Err() == nil {
+				node.rpcFailed(peer)
+			}
+		} else {
+			node.rpcSucceeded(peer)
+		}
+	})
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// fails when more than half of the announcements failed
+	if 2*failures > int32(len(findResult.peers)) {
+		return errors.New("announce failed")
+	}
+	return nil
+}
+
+func (node *KadNode) GetProviders(key []byte) ([]*Peer, error) {
+	return node.GetProvidersContext(context.Background(), key)
+}
+
+// GetProvidersContext returns providers of key collected from all peers queried by value lookup.
+func (node *KadNode) GetProvidersContext(ctx context.Context, key []byte) ([]*Peer, error) {
+	id := BytesId(key)
+	seen := make(map[string]bool)
+	providers := mergeProviders(nil, seen, node.providersOf(id))
+	// values don't end the lookup, providers are collected from all queried peers
+	findResult, _, err := node.lookup(ctx, id, true, func([]byte) bool { return false })
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	if err == nil {
+		providers = mergeProviders(providers, seen, findResult.providers)
+	}
+	if len(providers) == 0 {
+		return nil, ErrNotFound
+	}
+	return providers, nil
+}
@@ -0,0 +1,172 @@
+package dht
+
+import (
+	"context"
+	"github.com/mduszyk/gopeers/store"
+	"testing"
+	"time"
+)
+
+func TestProviderSet(t *testing.T) {
+	now := time.Now()
+	node := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	node.MaxProviders = 3
+	node.Clock = func() time.Time { return now }
+	key := MathRandId()
+	peers := make([]*Peer, 4)
+	for i := range peers {
+		peers[i] = NewKadNode(20, 5, 3, MathRandId(), nil).Peer
+	}
+
+	for i, peer := range peers[:3] {
+		err := node.Announce(peer, key, time.Duration(i+1)*time.Minute)
+		if err != nil {
+			t.Errorf("failed announcing: %v\n", err)
+		}
+	}
+	// refreshed provider is not duplicated
+	_ = node.Announce(peers[0], key, 10*time.Minute)
+	if n := len(node.providersOf(key)); n != 3 {
+		t.Errorf("expected 3 providers, got: %d\n", n)
+	}
+	// provider expiring soonest is replaced when set is full
+	_ = node.Announce(peers[3], key, 5*time.Minute)
+	providers := node.providersOf(key)
+	if len(providers) != 3 {
+		t.Errorf("provider set should be bounded, got: %d\n", len(providers))
+	}
+	for _, p := range providers {
+		if eq(p.Id, peers[1].Id) {
+			t.Errorf("provider expiring soonest should be replaced\n")
+		}
+	}
+
+	result, err := node.FindValue(peers[0], key)
+	if err != nil || len(result.Providers()) != 3 {
+		t.Errorf("find value should return providers: %v\n", err)
+	}
+
+	now = now.Add(6 * time.Minute)
+	if n := len(node.providersOf(key)); n != 1 {
+		t.Errorf("expired providers should not be returned, got: %d\n", n)
+	}
+	node.sweep(context.Background(), now.Add(time.Hour))
+	if _, ok := node.providers[string(key.Bytes())]; ok {
+		t.Errorf("expired providers should be swept\n")
+	}
+
+	_ = node.Announce(peers[0], key, 0)
+	if n := len(node.providersOf(key)); n != 1 {
+		t.Errorf("zero ttl should expire after ProviderTTL, got: %d\n", n)
+	}
+}
+
+func TestProviderLimits(t *testing.T) {
+	now := time.Now()
+	node := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	node.MaxProviderKeys = 2
+	node.MaxPeerProviderKeys = 1
+	node.Clock = func() time.Time { return now }
+	keys := []Id{MathRandId(), MathRandId(), MathRandId()}
+	peers := make([]*Peer, 3)
+	for i := range peers {
+		peers[i] = NewKadNode(20, 5, 3, MathRandId(), nil).Peer
+	}
+
+	if err := node.Announce(peers[0], keys[0], time.Minute); err != nil {
+		t.Errorf("failed announcing: %v\n", err)
+	}
+	if err := node.Announce(peers[0], keys[1], time.Minute); err != ErrProviderLimit {
+		t.Errorf("key beyond limit of peer should be rejected, got: %v\n", err)
+	}
+	if err := node.Announce(peers[0], keys[0], time.Hour); err != nil {
+		t.Errorf("provided key should be refreshed: %v\n", err)
+	}
+	if err := node.Announce(peers[1], keys[1], time.Minute); err != nil {
+		t.Errorf("failed announcing: %v\n", err)
+	}
+	if err := node.Announce(peers[2], keys[2], time.Minute); err != ErrProviderLimit {
+		t.Errorf("key beyond total limit should be rejected, got: %v\n", err)
+	}
+	if err := node.Announce(peers[2], keys[1], time.Minute); err != nil {
+		t.Errorf("provider of known key should be added: %v\n", err)
+	}
+
+	// expired providers free their keys
+	node.sweep(context.Background(), now.Add(2 * time.Minute))
+	if err := node.Announce(peers[1], keys[2], time.Minute); err != nil {
+		t.Errorf("key should be accepted after expiry: %v\n", err)
+	}
+	if n := len(node.provided); n != 2 {
+		t.Errorf("expected keys counted for 2 peers, got: %d\n", n)
+	}
+}
+
+func TestProvideGetProviders(t *testing.T) {
+	nodes := joinedNodes(t, 30, 20)
+	key := []byte("content")
+	for _, node := range nodes[:3] {
+		err := node.Provide(key, time.Hour)
+		if err != nil {
+			t.Fatalf("failed providing: %v\n", err)
+		}
+	}
+	// repeated announcement
+	_ = nodes[0].Provide(key, time.Hour)
+	// announcements which reached single peers
+	peers := make([]*Peer, len(nodes))
+	for i, node := range nodes {
+		peers[i] = node.Peer
+	}
+	sortByDistance(peers, BytesId(key))
+	for i, node := range nodes[3:5] {
+		err := peers[i].Proto.Announce(node.Peer, BytesId(key), time.Hour)
+		if err != nil {
+			t.Fatalf("failed announcing: %v\n", err)
+		}
+	}
+
+	getter := peers[len(peers)-1].Proto.(*KadNode)
+	providers, err := getter.GetProviders(key)
+	if err != nil {
+		t.Fatalf("failed getting providers: %v\n", err)
+	}
+	if len(providers) != 5 {
+		t.Errorf("expected 5 distinct providers, got: %d\n", len(providers))
+	}
+	for _, node := range nodes[:5] {
+		found := false
+		for _, p := range providers {
+			found = found || eq(p.Id, node.Peer.Id)
+		}
+		if !found {
+			t.Errorf("provider %s missing\n", hexId(node.Peer.Id))
+		}
+	}
+	if _, err := getter.Get(key); err != ErrNotFound {
+		t.Errorf("providers should not be returned as value, got: %v\n", err)
+	}
+
+	_, err = getter.GetProviders([]byte("missing"))
+	if err != ErrNotFound {
+		t.Errorf("missing providers should not be found, got: %v\n", err)
+	}
+}
+
+func TestProvideSmallNetwork(t *testing.T) {
+	node := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	key := MathRandId().Bytes()
+	if err := node.Provide(key, time.Hour); err != ErrNoPeers {
+		t.Errorf("announce without peers should fail with ErrNoPeers, got: %v\n", err)
+	}
+
+	nodes := joinedNodes(t, 2, 20)
+	err := nodes[1].Provide(key, time.Hour)
+	if err != nil {
+		t.Fatalf("announce to single peer should succeed: %v\n", err)
+	}
+	providers := nodes[0].providersOf(BytesId(key))
+	if len(providers) != 1 || !eq(providers[0].Id, nodes[1].Peer.Id) {
+		t.Errorf("peer should store the provider\n")
+	}
+}
@@ -0,0 +1,193 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"github.com/mduszyk/gopeers/logging"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidRecord = errors.New("invalid record")
+	ErrStaleRecord   = errors.New("stale record")
+)
+
+var recordPrefix = []byte("gopeers record")
+
+// NewRecord signs value to be stored under RecordKey of identity key and salt,
+// seq of every update has to be higher than of the previous one.
+func NewRecord(identity *Identity, salt []byte, seq uint64, value []byte) *Record {
+	record := &Record{PublicKey: identity.PublicKey, Salt: salt, Seq: seq, Value: value}
+	record.Signature = identity.Sign(record.signed())
+	return record
+}
+
+// RecordKey returns key of records signed with publicKey, salt lets one key sign many records.
+func RecordKey(publicKey ed25519.PublicKey, salt []byte) Id {
+	return Sha1Id(append(append([]byte{}, publicKey...), salt...))
+}
+
+func (r *Record) Key() Id {
+	return RecordKey(r.PublicKey, r.Salt)
+}
+
+func (r *Record) Verify() error {
+	_, err := Verify(r.PublicKey, r.signed(), r.Signature)
+	if err != nil {
+		return ErrInvalidRecord
+	}
+	return nil
+}
+
+func (r *Record) signed() []byte {
+	data := make([]byte, 0, len(recordPrefix)+len(r.Salt)+len(r.Value)+12)
+	data = append(data, recordPrefix...)
+	data = append(data, make([]byte, 12)...)
+	binary.BigEndian.PutUint32(data[len(recordPrefix):], uint32(len(r.Salt)))
+	binary.BigEndian.PutUint64(data[len(recordPrefix)+4:], r.Seq)
+	data = append(data, r.Salt...)
+	return append(data, r.Value...)
+}
+
+// decodeRecord returns nil if value stored under key isn't a record.
+func decodeRecord(key Id, value []byte) (*Record, error) {
+	var record Record
+	err := proto.Unmarshal(value, &record)
+	if err != nil || len(record.PublicKey) != ed25519.PublicKeySize || !eq(record.Key(), key) {
+		return nil, nil
+	}
+	err = record.Verify()
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// checkStore rejects forged records, records older than the stored one
+// and values replacing content addressed ones, it must be called with storeMutex held.
+func (node *KadNode) checkStore(key Id, value []byte) error {
+	record, err := decodeRecord(key, value)
+	if err != nil {
+		return err
+	}
+	stored, err := node.Storage.Get(key.Bytes())
+	if err != nil {
+		return nil
+	}
+	if immutable(key, stored) && !bytes.Equal(value, stored) {
+		return ErrImmutable
+	}
+	current, _ := decodeRecord(key, stored)
+	if current == nil {
+		return nil
+	}
+	if record == nil {
+		return ErrInvalidRecord
+	}
+	if record.Seq < current.Seq || record.Seq == current.Seq && !bytes.Equal(record.Value, current.Value) {
+		return ErrStaleRecord
+	}
+	return nil
+}
+
+func (node *KadNode) PutRecord(record *Record, ttl time.Duration) error {
+	return node.PutRecordContext(context.Background(), record, ttl)
+}
+
+// PutRecordContext stores record at the k closest peers and republishes it, zero ttl means no expiration.
+func (node *KadNode) PutRecordContext(ctx context.Context, record *Record, ttl time.Duration) error {
+	err := record.Verify()
+	if err != nil {
+		return err
+	}
+	value, err := proto.Marshal(record)
+	if err != nil {
+		return err
+	}
+	key := record.Key()
+	node.publish(key, value, ttl)
+	return node.storeClosest(ctx, key, value, ttl)
+}
+
+func (node *KadNode) GetRecord(publicKey ed25519.PublicKey, salt []byte) (*Record, error) {
+	return node.GetRecordContext(context.Background(), publicKey, salt)
+}
+
+// GetRecordContext asks the k closest peers for the record and returns valid one with highest seq,
+// peers holding older versions are updated.
+func (node *KadNode) GetRecordContext(ctx context.Context, publicKey ed25519.PublicKey, salt []byte) (*Record, error) {
+	key := RecordKey(publicKey, salt)
+	findResult, err := node.LookupContext(ctx, key, false)
+	if err != nil {
+		return nil, err
+	}
+	var latest *Record
+	// remaining ttl of latest record
+	var ttl time.Duration
+	var mutex sync.Mutex
+	held := make(map[*Peer]uint64)
+	keep := func(record *Record, recordTTL time.Duration) {
+		if latest == nil || record.Seq > latest.Seq {
+			latest, ttl = record, recordTTL
+		}
+	}
+	if value, err := node.Storage.Get(key.Bytes()); err == nil {
+		expiry, err := node.Storage.Expiry(key.Bytes())
+		recordTTL, ok := remaining(expiry, node.Clock())
+		if record, _ := decodeRecord(key, value); record != nil && err == nil && ok {
+			keep(record, recordTTL)
+		}
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(findResult.peers))
+	parallelize(findResult.peers, func(peer *Peer) {
+		defer wg.Done()
+		result, err := peer.Proto.FindValueContext(ctx, node.Peer, key)
+		if err != nil {
+			if ctx.Err() == nil {
+				node.rpcFailed(peer)
+			}
+			return
+		}
+		node.rpcSucceeded(peer)
+		if result.value == nil {
+			return
+		}
+		record, err := decodeRecord(key, result.value)
+		if record == nil {
+			node.Logger.Log(logging.Debug, "peer returned invalid record",
+				logging.F("peer", hexId(peer.Id)), logging.F("key", hexId(key)), logging.F("error", err))
+			return
+		}
+		mutex.Lock()
+		keep(record, result.ttl)
+		held[peer] = record.Seq
+		mutex.Unlock()
+	})
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if latest == nil {
+		return nil, ErrNotFound
+	}
+	value, err := proto.Marshal(latest)
+	if err != nil {
+		return nil, err
+	}
+	for peer, seq := range held {
+		if seq < latest.Seq {
+			err := peer.Proto.StoreContext(ctx, node.Peer, key, value, ttl)
+			if err != nil {
+				node.Logger.Log(logging.Debug, "failed updating stale record",
+					logging.F("peer", hexId(peer.Id)), logging.F("key", hexId(key)), logging.F("error", err))
+			}
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,109 @@
+package dht
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/mduszyk/gopeers/store"
+	"testing"
+)
+
+func marshalRecord(t *testing.T, record *Record) []byte {
+	value, err := proto.Marshal(record)
+	if err != nil {
+		t.Fatalf("failed encoding record: %v\n", err)
+	}
+	return value
+}
+
+func TestRecord(t *testing.T) {
+	identity := MathRandIdentity()
+	record := NewRecord(identity, []byte("salt"), 1, []byte("value"))
+	if err := record.Verify(); err != nil {
+		t.Errorf("failed verifying record: %v\n", err)
+	}
+	if eq(record.Key(), RecordKey(identity.PublicKey, []byte("other"))) {
+		t.Errorf("salt should be part of the key\n")
+	}
+	record.Seq = 2
+	if err := record.Verify(); err != ErrInvalidRecord {
+		t.Errorf("record with changed seq should be rejected, got: %v\n", err)
+	}
+}
+
+func TestStoreRecord(t *testing.T) {
+	node := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	sender := NewKadNode(20, 5, 3, MathRandId(), nil).Peer
+	identity := MathRandIdentity()
+	salt := []byte("config")
+	key := RecordKey(identity.PublicKey, salt)
+
+	put := func(record *Record) error {
+		return node.Store(sender, key, marshalRecord(t, record), 0)
+	}
+	if err := put(NewRecord(identity, salt, 2, []byte("v2"))); err != nil {
+		t.Errorf("failed storing record: %v\n", err)
+	}
+	if err := put(NewRecord(identity, salt, 2, []byte("v2"))); err != nil {
+		t.Errorf("same record should be stored again: %v\n", err)
+	}
+	if err := put(NewRecord(identity, salt, 1, []byte("v1"))); err != ErrStaleRecord {
+		t.Errorf("lower seq should be rejected, got: %v\n", err)
+	}
+	if err := put(NewRecord(identity, salt, 2, []byte("other"))); err != ErrStaleRecord {
+		t.Errorf("same seq with different value should be rejected, got: %v\n", err)
+	}
+	forged := NewRecord(MathRandIdentity(), salt, 3, []byte("forged"))
+	forged.PublicKey = identity.PublicKey
+	if err := put(forged); err != ErrInvalidRecord {
+		t.Errorf("forged record should be rejected, got: %v\n", err)
+	}
+	if err := node.Store(sender, key, []byte("plain"), 0); err != ErrInvalidRecord {
+		t.Errorf("plain value should not replace record, got: %v\n", err)
+	}
+	if err := put(NewRecord(identity, salt, 3, []byte("v3"))); err != nil {
+		t.Errorf("higher seq should be stored: %v\n", err)
+	}
+	value, _ := node.Storage.Get(key.Bytes())
+	if record, _ := decodeRecord(key, value); record == nil || record.Seq != 3 {
+		t.Errorf("latest record should be stored\n")
+	}
+}
+
+func TestPutGetRecord(t *testing.T) {
+	k := 5
+	nodes := joinedNodes(t, 30, k)
+	identity := MathRandIdentity()
+	salt := []byte("config")
+	err := nodes[0].PutRecord(NewRecord(identity, salt, 1, []byte("v1")), 0)
+	if err != nil {
+		t.Fatalf("failed putting record: %v\n", err)
+	}
+
+	// update reaches only the closest holder
+	key := RecordKey(identity.PublicKey, salt)
+	peers := make([]*Peer, len(nodes))
+	for i, node := range nodes {
+		peers[i] = node.Peer
+	}
+	sortByDistance(peers, key)
+	latest := marshalRecord(t, NewRecord(identity, salt, 2, []byte("v2")))
+	err = peers[0].Proto.Store(peers[1], key, latest, 0)
+	if err != nil {
+		t.Fatalf("failed storing record: %v\n", err)
+	}
+
+	record, err := peers[1].Proto.(*KadNode).GetRecord(identity.PublicKey, salt)
+	if err != nil {
+		t.Fatalf("failed getting record: %v\n", err)
+	}
+	if record.Seq != 2 || string(record.Value) != "v2" {
+		t.Errorf("record with highest seq should be returned, got: %d\n", record.Seq)
+	}
+	if count := countStored(nodes, key.Bytes(), latest); count < k {
+		t.Errorf("stale holders should be updated, got: %d\n", count)
+	}
+
+	_, err = peers[1].Proto.(*KadNode).GetRecord(identity.PublicKey, []byte("missing"))
+	if err != ErrNotFound {
+		t.Errorf("missing record should not be found, got: %v\n", err)
+	}
+}
@@ -0,0 +1,42 @@
+package dht
+
+import (
+	"context"
+	"time"
+)
+
+// RefreshHook receives the outcome of refreshing bucket covering range [lo, hi).
+type RefreshHook func(lo, hi Id, err error)
+
+const maxCheckPeriod = time.Minute
+
+func checkPeriod(interval time.Duration) time.Duration {
+	if interval < maxCheckPeriod {
+		return interval
+	}
+	return maxCheckPeriod
+}
+
+func (node *KadNode) idleBuckets(now time.Time) []*bucket {
+	node.Tree.mutex.RLock()
+	defer node.Tree.mutex.RUnlock()
+	var idle []*bucket
+	for _, b := range node.Tree.buckets(node.Peer.Id) {
+		if b.idle(now, node.RefreshInterval) {
+			idle = append(idle, b)
+		}
+	}
+	return idle
+}
+
+func (node *KadNode) refreshIdle(ctx context.Context, now time.Time) {
+	for _, b := range node.idleBuckets(now) {
+		if ctx.Err() != nil {
+			return
+		}
+		err := node.refreshBucket(ctx, b)
+		if node.RefreshHook != nil {
+			node.RefreshHook(b.lo, b.hi, err)
+		}
+	}
+}
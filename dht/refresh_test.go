@@ -0,0 +1,127 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"github.com/mduszyk/gopeers/store"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestIdleBuckets(t *testing.T) {
+	node := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	now := time.Now()
+	if len(node.idleBuckets(now)) != 0 {
+		t.Errorf("fresh bucket should not be idle\n")
+	}
+	later := now.Add(node.RefreshInterval)
+	if len(node.idleBuckets(later)) != 1 {
+		t.Errorf("bucket should be idle\n")
+	}
+	node.Tree.root.Bucket.lastLookup = now.Add(-node.RefreshInterval)
+	_, err := node.Lookup(MathRandId(), false)
+	if err != nil {
+		t.Errorf("lookup failed: %v\n", err)
+	}
+	if len(node.idleBuckets(now)) != 0 {
+		t.Errorf("lookup should reset bucket idle time\n")
+	}
+}
+
+type failingProtocol struct {
+	Protocol
+}
+
+func (p failingProtocol) FindNodeContext(ctx context.Context, sender *Peer, id Id) (*FindResult, error) {
+	return nil, errors.New("find node failure")
+}
+
+func TestRefreshScheduler(t *testing.T) {
+	k := 2
+	half := new(big.Int).Rsh(maxId, 1)
+	node1 := NewKadNode(k, 5, 3, big.NewInt(0), store.NewMemStorage())
+	node2 := NewKadNode(k, 5, 3, big.NewInt(1), store.NewMemStorage())
+	node3 := NewKadNode(k, 5, 3, new(big.Int).Add(half, big.NewInt(1)), store.NewMemStorage())
+	node1.add(node2.Peer)
+	node1.add(&Peer{half, failingProtocol{}, time.Now()})
+	node1.add(node3.Peer)
+	if node1.Tree.size != 2 {
+		t.Fatalf("there should be 2 buckets\n")
+	}
+
+	results := make(chan error, 10)
+	node1.RefreshInterval = 10 * time.Millisecond
+	node1.RefreshHook = func(lo, hi Id, err error) {
+		select {
+		case results <- err:
+		default:
+		}
+	}
+	node1.Start()
+	defer node1.Stop()
+
+	failed, succeeded := 0, 0
+	timeout := time.After(time.Second)
+	for failed == 0 || succeeded == 0 {
+		select {
+		case err := <-results:
+			if err != nil {
+				failed++
+			} else {
+				succeeded++
+			}
+		case <-timeout:
+			t.Fatalf("refresh outcomes not reported, failed: %d, succeeded: %d\n", failed, succeeded)
+		}
+	}
+}
+
+func TestRefreshBucketPastFailure(t *testing.T) {
+	node1 := NewKadNode(20, 5, 3, big.NewInt(0), store.NewMemStorage())
+	node2 := NewKadNode(20, 5, 3, big.NewInt(2), store.NewMemStorage())
+	node3 := NewKadNode(20, 5, 3, big.NewInt(3), store.NewMemStorage())
+	node2.add(node3.Peer)
+	node1.add(&Peer{big.NewInt(1), failingProtocol{}, time.Now()})
+	node1.add(node2.Peer)
+
+	err := node1.refreshBucket(context.Background(), node1.Tree.root.Bucket)
+	if err == nil {
+		t.Errorf("failed peer should be reported\n")
+	}
+	if node1.Tree.Find(node3.Peer.Id).Bucket.find(node3.Peer.Id) < 0 {
+		t.Errorf("refresh should continue past failed peer\n")
+	}
+}
+
+func TestRefreshCanceled(t *testing.T) {
+	node1 := NewKadNode(20, 5, 3, big.NewInt(0), store.NewMemStorage())
+	node2 := NewKadNode(20, 5, 3, big.NewInt(2), store.NewMemStorage())
+	node1.add(node2.Peer)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := node1.RefreshContext(ctx)
+	if err != context.Canceled {
+		t.Errorf("refresh should be abandoned with canceled context: %v\n", err)
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	node := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	node.RefreshInterval = time.Millisecond
+	node.Start()
+	node.Start()
+	node.Stop()
+	node.Stop()
+	node.Start()
+	node.Stop()
+}
+
+func TestStartDisabledIntervals(t *testing.T) {
+	node := NewKadNode(20, 5, 3, MathRandId(), store.NewMemStorage())
+	node.RefreshInterval = 0
+	node.ReplicateInterval = -time.Second
+	node.SweepInterval = 0
+	node.Start()
+	node.Stop()
+}
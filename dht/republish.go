@@ -0,0 +1,127 @@
+package dht
+
+import (
+	"context"
+	"github.com/mduszyk/gopeers/logging"
+	"time"
+)
+
+type publication struct {
+	value     []byte
+	published time.Time
+	expiry    time.Time
+}
+
+func (node *KadNode) publish(key Id, value []byte, ttl time.Duration) {
+	now := node.Clock()
+	var expiry time.Time
+	if ttl > 0 {
+		expiry = now.Add(ttl)
+	}
+	node.keysMutex.Lock()
+	node.published[string(key.Bytes())] = &publication{value, now, expiry}
+	node.keysMutex.Unlock()
+}
+
+// Unpublish stops republishing value stored under key by Set, PutRecord or PutImmutable,
+// copies already stored in the network expire with their ttl.
+func (node *KadNode) Unpublish(key []byte) {
+	node.keysMutex.Lock()
+	delete(node.published, string(BytesId(key).Bytes()))
+	node.keysMutex.Unlock()
+}
+
+// remaining returns ttl left until expiry, false if already expired.
+func remaining(expiry time.Time, now time.Time) (time.Duration, bool) {
+	if expiry.IsZero() {
+		return 0, true
+	}
+	ttl := expiry.Sub(now)
+	return ttl, ttl > 0
+}
+
+func (node *KadNode) received(key Id) {
+	node.keysMutex.Lock()
+	node.stored[string(key.Bytes())] = node.Clock()
+	node.keysMutex.Unlock()
+}
+
+// replicate stores every key held by the node at the k closest peers,
+// keys received via Store within the last interval are skipped since
+// other peers are replicating them as well.
+func (node *KadNode) replicate(ctx context.Context, now time.Time) {
+	keys, err := node.Storage.Keys()
+	if err != nil {
+		node.Logger.Log(logging.Warn, "replicate failed listing keys", logging.F("error", err))
+		return
+	}
+
+	due := make([][]byte, 0, len(keys))
+	present := make(map[string]bool, len(keys))
+	node.keysMutex.Lock()
+	for _, key := range keys {
+		k := string(key)
+		present[k] = true
+		if last, ok := node.stored[k]; ok && now.Sub(last) < node.ReplicateInterval {
+			continue
+		}
+		node.stored[k] = now
+		due = append(due, key)
+	}
+	for k := range node.stored {
+		if !present[k] {
+			delete(node.stored, k)
+		}
+	}
+	node.keysMutex.Unlock()
+
+	for _, key := range due {
+		value, err := node.Storage.Get(key)
+		if err != nil {
+			continue
+		}
+		expiry, err := node.Storage.Expiry(key)
+		if err != nil {
+			continue
+		}
+		ttl, ok := remaining(expiry, now)
+		if !ok {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		err = node.storeClosest(ctx, BytesId(key), value, ttl)
+		if err != nil {
+			node.Logger.Log(logging.Warn, "replicate failed",
+				logging.F("key", hexId(BytesId(key))), logging.F("error", err))
+		}
+	}
+}
+
+// republish re-stores values originally published by the node.
+func (node *KadNode) republish(ctx context.Context, now time.Time) {
+	due := make(map[string]*publication)
+	node.keysMutex.Lock()
+	for k, p := range node.published {
+		if _, ok := remaining(p.expiry, now); !ok {
+			delete(node.published, k)
+		} else if now.Sub(p.published) >= node.RepublishInterval {
+			p.published = now
+			due[k] = p
+		}
+	}
+	node.keysMutex.Unlock()
+
+	for k, p := range due {
+		key := BytesId([]byte(k))
+		ttl, _ := remaining(p.expiry, now)
+		if ctx.Err() != nil {
+			return
+		}
+		err := node.storeClosest(ctx, key, p.value, ttl)
+		if err != nil {
+			node.Logger.Log(logging.Warn, "republish failed", logging.F("key", hexId(key)), logging.F("error", err))
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package dht
+
+import (
+	"context"
+	"github.com/mduszyk/gopeers/store"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func joinedNodes(t *testing.T, n, k int) []*KadNode {
+	nodes := make([]*KadNode, n)
+	for i := 0; i < n; i++ {
+		nodes[i] = NewKadNode(k, 5, 3, MathRandId(), store.NewMemStorage())
+	}
+	for i := 1; i < n; i++ {
+		err := nodes[i].Join(nodes[0].Peer)
+		if err != nil {
+			t.Fatalf("failed joining: %v\n", err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		err := nodes[i].Refresh()
+		if err != nil {
+			t.Fatalf("failed refreshing: %v\n", err)
+		}
+	}
+	return nodes
+}
+
+func countStored(nodes []*KadNode, key []byte, value []byte) int {
+	count := 0
+	for _, node := range nodes {
+		v, err := node.Storage.Get(key)
+		if err == nil && reflect.DeepEqual(v, value) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestReplicate(t *testing.T) {
+	k := 5
+	nodes := joinedNodes(t, 30, k)
+	key := MathRandId().Bytes()
+	value := []byte("replicated")
+
+	err := nodes[0].Storage.Set(key, value)
+	if err != nil {
+		t.Errorf("failed storing value: %v\n", err)
+	}
+	nodes[0].replicate(context.Background(), time.Now())
+	if count := countStored(nodes, key, value); count < k {
+		t.Errorf("value should be replicated to k peers, got: %d\n", count)
+	}
+}
+
+func TestReplicateSkipsRecentlyStored(t *testing.T) {
+	nodes := joinedNodes(t, 30, 5)
+	key := MathRandId()
+	value := []byte("received")
+
+	err := nodes[0].Store(nodes[1].Peer, key, value, 0)
+	if err != nil {
+		t.Errorf("failed storing value: %v\n", err)
+	}
+	nodes[0].replicate(context.Background(), time.Now())
+	if count := countStored(nodes[1:], key.Bytes(), value); count != 0 {
+		t.Errorf("recently stored key should not be replicated, got: %d\n", count)
+	}
+
+	nodes[0].replicate(context.Background(), time.Now().Add(nodes[0].ReplicateInterval))
+	if count := countStored(nodes[1:], key.Bytes(), value); count == 0 {
+		t.Errorf("key should be replicated after interval\n")
+	}
+}
+
+func TestRepublish(t *testing.T) {
+	k := 5
+	nodes := joinedNodes(t, 30, k)
+	key := MathRandId().Bytes()
+	value := []byte("published")
+
+	err := nodes[0].Set(key, value)
+	if err != nil {
+		t.Errorf("set failed: %v\n", err)
+	}
+	for _, node := range nodes {
+		node.Storage = store.NewMemStorage()
+	}
+
+	nodes[0].republish(context.Background(), time.Now())
+	if count := countStored(nodes, key, value); count != 0 {
+		t.Errorf("value should not be republished before interval, got: %d\n", count)
+	}
+
+	nodes[0].republish(context.Background(), time.Now().Add(nodes[0].RepublishInterval))
+	if count := countStored(nodes, key, value); count != k {
+		t.Errorf("value should be republished to k peers, got: %d\n", count)
+	}
+}
+
+func TestUnpublish(t *testing.T) {
+	nodes := joinedNodes(t, 10, 5)
+	key := MathRandId().Bytes()
+	value := []byte("unpublished")
+
+	err := nodes[0].Set(key, value)
+	if err != nil {
+		t.Errorf("set failed: %v\n", err)
+	}
+	for _, node := range nodes {
+		node.Storage = store.NewMemStorage()
+	}
+	nodes[0].Unpublish(key)
+	if len(nodes[0].published) != 0 {
+		t.Errorf("publication should be dropped\n")
+	}
+	nodes[0].republish(context.Background(), time.Now().Add(nodes[0].RepublishInterval))
+	if count := countStored(nodes, key, value); count != 0 {
+		t.Errorf("unpublished value should not be republished, got: %d\n", count)
+	}
+}
@@ -0,0 +1,217 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Snapshot captures tree shape and udp peers of the routing table.
+func (n *udpProtocolNode) Snapshot() *RoutingSnapshot {
+	node := n.dhtNode
+	node.Tree.mutex.RLock()
+	defer node.Tree.mutex.RUnlock()
+	buckets := node.Tree.buckets(node.Peer.Id)
+	snapshot := &RoutingSnapshot{
+		NodeId: node.Peer.Id.Bytes(),
+		Taken: time.Now().UnixNano(),
+		Buckets: make([]*BucketSnapshot, len(buckets)),
+	}
+	for i, b := range buckets {
+		peers := make([]*PeerSnapshot, 0, len(b.peers))
+		for _, peer := range b.peers {
+			protocol, ok := peer.Proto.(*udpProtocol)
+			if !ok || eq(peer.Id, node.Peer.Id) {
+				continue
+			}
+			addr := &UDPAddr{
+				IP: protocol.addr.IP,
+				Port: int32(protocol.addr.Port),
+				Zone: protocol.addr.Zone,
+			}
+			peers = append(peers, &PeerSnapshot{
+				Node: &UdpNode{Addr: addr, NodeId: peer.Id.Bytes()},
+				LastSeen: peer.LastSeen.UnixNano(),
+			})
+		}
+		snapshot.Buckets[i] = &BucketSnapshot{
+			Depth: int32(b.depth),
+			Lo: b.lo.Bytes(),
+			Hi: b.hi.Bytes(),
+			Peers: peers,
+		}
+	}
+	return snapshot
+}
+
+// SaveSnapshot atomically replaces file at path with current routing table snapshot.
+func (n *udpProtocolNode) SaveSnapshot(path string) error {
+	data, err := proto.Marshal(n.Snapshot())
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+func LoadSnapshot(path string) (*RoutingSnapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot RoutingSnapshot
+	err = proto.Unmarshal(data, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// Restore rebuilds tree shape from snapshot, adds peers which respond to ping
+// and looks up own id. It returns number of restored peers, snapshot of different node is rejected.
+func (n *udpProtocolNode) Restore(ctx context.Context, snapshot *RoutingSnapshot) (int, error) {
+	node := n.dhtNode
+	if !eq(BytesId(snapshot.NodeId), node.Peer.Id) {
+		return 0, errors.New("snapshot of different node")
+	}
+
+	var peers []*Peer
+	var splits []Event
+	node.Tree.mutex.Lock()
+	for _, b := range snapshot.Buckets {
+		lo := BytesId(b.Lo)
+		if !lt(lo, maxId) || b.Depth < 0 || int(b.Depth) > IdBits {
+			continue
+		}
+		t := node.Tree.Find(lo)
+		for t.Bucket.depth < int(b.Depth) {
+			splits = append(splits, Event{Type: BucketSplit, Lo: t.Bucket.lo, Hi: t.Bucket.hi})
+			node.Tree.split(t)
+			t = node.Tree.Find(lo)
+		}
+		for _, p := range b.Peers {
+			if p.Node == nil || p.Node.Addr == nil {
+				continue
+			}
+			peer := &Peer{Id: BytesId(p.Node.NodeId), LastSeen: time.Unix(0, p.LastSeen)}
+			addr := &net.UDPAddr{
+				IP: p.Node.Addr.IP,
+				Port: int(p.Node.Addr.Port),
+				Zone: p.Node.Addr.Zone,
+			}
+			n.Connect(addr, peer)
+			peers = append(peers, peer)
+		}
+	}
+	node.Tree.mutex.Unlock()
+	for _, event := range splits {
+		node.emit(event)
+	}
+
+	var wg sync.WaitGroup
+	alive := make([]bool, len(peers))
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer *Peer) {
+			defer wg.Done()
+			alive[i] = node.callPing(ctx, peer) == nil
+		}(i, peer)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// least recently seen peers are added first to keep bucket order
+	restored := make([]*Peer, 0, len(peers))
+	for i, peer := range peers {
+		if alive[i] {
+			restored = append(restored, peer)
+		}
+	}
+	sort.SliceStable(restored, func(i, j int) bool {
+		return restored[i].LastSeen.Before(restored[j].LastSeen)
+	})
+	for _, peer := range restored {
+		node.add(peer)
+	}
+	if len(restored) > 0 {
+		// self lookup lets neighbours learn about us again, like Join does
+		_, err := node.LookupContext(ctx, node.Peer.Id, false)
+		if err != nil {
+			return len(restored), err
+		}
+	}
+	return len(restored), nil
+}
+
+// RestoreFile restores routing table from snapshot file at path.
+func (n *udpProtocolNode) RestoreFile(ctx context.Context, path string) (int, error) {
+	snapshot, err := LoadSnapshot(path)
+	if err != nil {
+		return 0, err
+	}
+	return n.Restore(ctx, snapshot)
+}
+
+// StartSnapshots saves routing table to path every interval and once more on Shutdown or Close.
+func (n *udpProtocolNode) StartSnapshots(path string, interval time.Duration) {
+	n.snapshotMutex.Lock()
+	defer n.snapshotMutex.Unlock()
+	if n.snapshotStop != nil {
+		return
+	}
+	n.snapshotPath = path
+	n.snapshotStop = make(chan struct{})
+	n.snapshots.Add(1)
+	go func(stop chan struct{}) {
+		defer n.snapshots.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = n.SaveSnapshot(path)
+			case <-stop:
+				return
+			}
+		}
+	}(n.snapshotStop)
+}
+
+// stopSnapshots stops periodic snapshots and takes the final one.
+func (n *udpProtocolNode) stopSnapshots() error {
+	n.snapshotMutex.Lock()
+	defer n.snapshotMutex.Unlock()
+	if n.snapshotStop == nil {
+		return nil
+	}
+	close(n.snapshotStop)
+	n.snapshots.Wait()
+	n.snapshotStop = nil
+	return n.SaveSnapshot(n.snapshotPath)
+}
@@ -0,0 +1,79 @@
+package dht
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	identity := MathRandIdentity()
+	node := startUdpNode(t, identity)
+	others := make([]*udpProtocolNode, 3)
+	for i := range others {
+		others[i] = startUdpNode(t, MathRandIdentity())
+		defer others[i].Close()
+		peer := NewPeer(others[i].dhtNode.Peer.Id)
+		node.Connect(others[i].rpcNode.Addr, peer)
+		node.dhtNode.add(peer)
+	}
+	path := filepath.Join(t.TempDir(), "routing")
+	node.StartSnapshots(path, time.Hour)
+	err := node.Close()
+	if err != nil {
+		t.Errorf("failed closing node: %v\n", err)
+	}
+	// dead peer should not be restored
+	others[2].Close()
+
+	restarted := startUdpNode(t, identity)
+	defer restarted.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	n, err := restarted.RestoreFile(ctx, path)
+	if err != nil {
+		t.Errorf("failed restoring: %v\n", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 restored peers, got: %d\n", n)
+	}
+	for i, other := range others {
+		contains := restarted.dhtNode.Tree.Find(other.dhtNode.Peer.Id).Bucket.Contains(other.dhtNode.Peer.Id)
+		if contains != (i < 2) {
+			t.Errorf("peer %d restored: %v\n", i, contains)
+		}
+	}
+}
+
+func TestSnapshotTreeShape(t *testing.T) {
+	identity := MathRandIdentity()
+	id := identity.Id()
+	node := startUdpNode(t, identity)
+	defer node.Close()
+	for i := 0; i < 5; i++ {
+		node.dhtNode.Tree.split(node.dhtNode.Tree.Find(id))
+	}
+	snapshot := node.Snapshot()
+
+	restarted := startUdpNode(t, identity)
+	defer restarted.Close()
+	_, err := restarted.Restore(context.Background(), snapshot)
+	if err != nil {
+		t.Errorf("failed restoring: %v\n", err)
+	}
+	if restarted.dhtNode.Tree.size != node.dhtNode.Tree.size {
+		t.Errorf("tree shape not restored, size: %d, expected: %d\n",
+			restarted.dhtNode.Tree.size, node.dhtNode.Tree.size)
+	}
+	if restarted.dhtNode.Tree.Find(id).Bucket.depth != 5 {
+		t.Errorf("own bucket depth not restored\n")
+	}
+
+	other := startUdpNode(t, MathRandIdentity())
+	defer other.Close()
+	_, err = other.Restore(context.Background(), snapshot)
+	if err == nil {
+		t.Errorf("snapshot of different node should be rejected\n")
+	}
+}
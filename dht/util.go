@@ -1,5 +1,9 @@
 package dht
 
+import (
+    "context"
+)
+
 func min(a, b int) int {
     if a < b {
         return a
@@ -15,16 +19,28 @@ type ProcessResult struct {
 type ProcessFn = func(payload Payload) (Payload, error)
 
 func Pool(n int, f ProcessFn) (chan Payload, chan ProcessResult) {
+    return PoolContext(context.Background(), n, f)
+}
+
+// PoolContext workers exit when ctx is done, f should observe ctx as well.
+func PoolContext(ctx context.Context, n int, f ProcessFn) (chan Payload, chan ProcessResult) {
     input := make(chan Payload, n)
     output := make(chan ProcessResult, n)
     for i := 0; i < n; i++ {
        go func() {
            for {
-               payload, more := <-input
-               if more {
+               select {
+               case payload, more := <-input:
+                   if !more {
+                       return
+                   }
                    value, err := f(payload)
-                   output <- ProcessResult{value, err}
-               } else {
+                   select {
+                   case output <- ProcessResult{value, err}:
+                   case <-ctx.Done():
+                       return
+                   }
+               case <-ctx.Done():
                    return
                }
            }
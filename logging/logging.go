@@ -0,0 +1,94 @@
+// Package logging defines leveled structured logger nodes report into and a quiet default.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l >= Debug && l <= Error {
+		return levelNames[l]
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level: %s", s)
+}
+
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{key, value}
+}
+
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+	// Enabled lets callers skip building expensive fields.
+	Enabled(level Level) bool
+}
+
+// Nop discards all messages.
+var Nop Logger = nop{}
+
+type nop struct{}
+
+func (nop) Log(Level, string, ...Field) {}
+func (nop) Enabled(Level) bool          { return false }
+
+type stdLogger struct {
+	out   *log.Logger
+	level Level
+}
+
+// NewStdLogger writes messages at or above level to out as: level msg key=value ...
+func NewStdLogger(out *log.Logger, level Level) Logger {
+	return &stdLogger{out, level}
+}
+
+func (l *stdLogger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+func (l *stdLogger) Log(level Level, msg string, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for _, f := range fields {
+		b.WriteByte(' ')
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		value := fmt.Sprint(f.Value)
+		if strings.ContainsAny(value, " \"=") {
+			value = strconv.Quote(value)
+		}
+		b.WriteString(value)
+	}
+	l.out.Print(b.String())
+}
@@ -0,0 +1,34 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+)
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0), Info)
+	logger.Log(Debug, "hidden", F("a", 1))
+	logger.Log(Warn, "call failed", F("call", 7), F("error", errors.New("call timeout")))
+	expected := "warn call failed call=7 error=\"call timeout\"\n"
+	if buf.String() != expected {
+		t.Errorf("invalid output: %q, expected: %q\n", buf.String(), expected)
+	}
+	if logger.Enabled(Debug) || !logger.Enabled(Error) {
+		t.Errorf("invalid enabled levels\n")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, level := range []Level{Debug, Info, Warn, Error} {
+		parsed, err := ParseLevel(level.String())
+		if err != nil || parsed != level {
+			t.Errorf("failed parsing level %v: %v, %v\n", level, parsed, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("unknown level should be rejected\n")
+	}
+}
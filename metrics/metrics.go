@@ -0,0 +1,41 @@
+// Package metrics defines instruments nodes report into, a no-op default
+// and a registry exporting collected values in Prometheus text format.
+package metrics
+
+type Counter interface {
+	Add(delta float64)
+}
+
+type Gauge interface {
+	Set(value float64)
+	Add(delta float64)
+}
+
+type Histogram interface {
+	Observe(value float64)
+}
+
+// Metrics creates named instruments, asking twice for the same name returns the same instrument.
+type Metrics interface {
+	Counter(name, help string) Counter
+	Gauge(name, help string) Gauge
+	Histogram(name, help string, buckets []float64) Histogram
+}
+
+// Nop discards everything reported into it.
+var Nop Metrics = nop{}
+
+type nop struct{}
+
+func (nop) Counter(string, string) Counter                { return nop{} }
+func (nop) Gauge(string, string) Gauge                    { return nop{} }
+func (nop) Histogram(string, string, []float64) Histogram { return nop{} }
+func (nop) Add(float64)                                   {}
+func (nop) Set(float64)                                   {}
+func (nop) Observe(float64)                               {}
+
+// DurationBuckets are histogram buckets in seconds suitable for network calls.
+var DurationBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// CountBuckets are histogram buckets suitable for small counts like lookup hops.
+var CountBuckets = []float64{1, 2, 3, 4, 5, 6, 8, 10, 15, 20}
@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+type value struct {
+	bits uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		n := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&v.bits, old, n) {
+			return
+		}
+	}
+}
+
+func (v *value) set(f float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(f))
+}
+
+func (v *value) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+type counter struct {
+	value
+}
+
+func (c *counter) Add(delta float64) {
+	if delta < 0 {
+		panic("counter cannot decrease")
+	}
+	c.add(delta)
+}
+
+type gauge struct {
+	value
+}
+
+func (g *gauge) Set(value float64) {
+	g.set(value)
+}
+
+func (g *gauge) Add(delta float64) {
+	g.add(delta)
+}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+	mutex   sync.Mutex
+}
+
+func (h *histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.buckets, value)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += value
+}
+
+type metric struct {
+	name, help, kind string
+	instrument       interface{}
+}
+
+// Registry keeps instruments in memory, it implements Metrics.
+type Registry struct {
+	metrics map[string]*metric
+	mutex   sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]*metric)}
+}
+
+func (r *Registry) get(name, help, kind string, create func() interface{}) interface{} {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if m, ok := r.metrics[name]; ok {
+		if m.kind != kind {
+			panic(fmt.Sprintf("metric %s already registered as %s", name, m.kind))
+		}
+		return m.instrument
+	}
+	m := &metric{name, help, kind, create()}
+	r.metrics[name] = m
+	return m.instrument
+}
+
+func (r *Registry) Counter(name, help string) Counter {
+	return r.get(name, help, "counter", func() interface{} { return &counter{} }).(Counter)
+}
+
+func (r *Registry) Gauge(name, help string) Gauge {
+	return r.get(name, help, "gauge", func() interface{} { return &gauge{} }).(Gauge)
+}
+
+func (r *Registry) Histogram(name, help string, buckets []float64) Histogram {
+	return r.get(name, help, "histogram", func() interface{} {
+		sorted := append([]float64(nil), buckets...)
+		sort.Float64s(sorted)
+		return &histogram{buckets: sorted, counts: make([]uint64, len(sorted))}
+	}).(Histogram)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// WriteText writes all metrics sorted by name in Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.Lock()
+	metrics := make([]*metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mutex.Unlock()
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name < metrics[j].name
+	})
+
+	b := bufio.NewWriter(w)
+	for _, m := range metrics {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		switch i := m.instrument.(type) {
+		case *counter:
+			fmt.Fprintf(b, "%s %s\n", m.name, formatFloat(i.get()))
+		case *gauge:
+			fmt.Fprintf(b, "%s %s\n", m.name, formatFloat(i.get()))
+		case *histogram:
+			i.mutex.Lock()
+			var cumulative uint64
+			for j, le := range i.buckets {
+				cumulative += i.counts[j]
+				fmt.Fprintf(b, "%s_bucket{le=\"%s\"} %d\n", m.name, formatFloat(le), cumulative)
+			}
+			fmt.Fprintf(b, "%s_bucket{le=\"+Inf\"} %d\n", m.name, i.count)
+			fmt.Fprintf(b, "%s_sum %s\n", m.name, formatFloat(i.sum))
+			fmt.Fprintf(b, "%s_count %d\n", m.name, i.count)
+			i.mutex.Unlock()
+		}
+	}
+	return b.Flush()
+}
+
+// Handler serves metrics for Prometheus scraping.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_ = r.WriteText(w)
+	})
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("requests_total", "Requests.")
+	c.Add(1)
+	r.Counter("requests_total", "Requests.").Add(2)
+	g := r.Gauge("pending", "Pending.")
+	g.Set(5)
+	g.Add(-2)
+	h := r.Histogram("duration_seconds", "Duration.", []float64{1, 0.1})
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(3)
+
+	var buf bytes.Buffer
+	err := r.WriteText(&buf)
+	if err != nil {
+		t.Fatalf("failed writing metrics: %v\n", err)
+	}
+	expected := `# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{le="0.1"} 2
+duration_seconds_bucket{le="1"} 3
+duration_seconds_bucket{le="+Inf"} 4
+duration_seconds_sum 3.65
+duration_seconds_count 4
+# HELP pending Pending.
+# TYPE pending gauge
+pending 3
+# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total 3
+`
+	if buf.String() != expected {
+		t.Errorf("invalid text format:\n%s\nexpected:\n%s\n", buf.String(), expected)
+	}
+}
+
+func TestRegistryKindConflict(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("name", "help")
+	defer func() {
+		if p := recover(); p == nil || !strings.Contains(p.(string), "counter") {
+			t.Errorf("registering different kind should panic, got: %v\n", p)
+		}
+	}()
+	r.Gauge("name", "help")
+}
+
+func TestNop(t *testing.T) {
+	Nop.Counter("a", "").Add(1)
+	Nop.Gauge("b", "").Set(1)
+	Nop.Histogram("c", "", DurationBuckets).Observe(1)
+}
@@ -0,0 +1,275 @@
+package rpc
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/mduszyk/gopeers/logging"
+	"net"
+	"time"
+)
+
+// largest payload of udp datagram over ipv4
+const maxUdpPayload = 65507
+
+// room for fragment fields around chunk of marshalled message
+const fragmentOverhead = 64
+
+const defaultNackInterval = 100 * time.Millisecond
+
+// incomplete messages being reassembled, new ones are dropped above the limits
+const (
+	maxAssemblies        = 1024
+	maxAssembliesPerAddr = 32
+)
+
+// sending fragments pauses after each burst so that receive buffer of peer doesn't overflow
+const (
+	fragmentBurst = 64 << 10
+	fragmentPause = time.Millisecond
+)
+
+// fragmentKey identifies fragmented message, call ids of requests and responses
+// exchanged with a peer may collide so type of the message is part of the key.
+type fragmentKey struct {
+	addr   string
+	kind   Message_TypeEnum
+	callId CallId
+}
+
+// fragments of sent message kept for retransmission until reassembly timeout.
+type fragments struct {
+	chunks [][]byte
+}
+
+type assembly struct {
+	total  uint32
+	chunks map[uint32][]byte
+	size   int
+	// complete assembly is kept until timeout so that duplicated fragments are ignored
+	complete bool
+	nack     *time.Timer
+	expire   *time.Timer
+}
+
+// sendFragments splits marshalled message into chunks fitting into datagrams.
+func (node *UdpNode) sendFragments(message *Message, buf []byte, addr *net.UDPAddr) error {
+	if uint32(len(buf)) > node.MaxMessageSize {
+		return fmt.Errorf("message of %d bytes exceeds limit", len(buf))
+	}
+	size := node.datagramSize() - fragmentOverhead
+	if size <= 0 {
+		return fmt.Errorf("datagram size %d too small for fragments", node.MaxDatagramSize)
+	}
+	sent := &fragments{chunks: make([][]byte, 0, (len(buf)+size-1)/size)}
+	for len(buf) > 0 {
+		n := size
+		if n > len(buf) {
+			n = len(buf)
+		}
+		sent.chunks = append(sent.chunks, buf[:n])
+		buf = buf[n:]
+	}
+	key := fragmentKey{addr.String(), message.Type, message.CallId}
+	node.fragmentsMutex.Lock()
+	node.outgoing[key] = sent
+	node.fragmentsMutex.Unlock()
+	time.AfterFunc(node.ReassemblyTimeout, func() {
+		node.fragmentsMutex.Lock()
+		if node.outgoing[key] == sent {
+			delete(node.outgoing, key)
+		}
+		node.fragmentsMutex.Unlock()
+	})
+	burst := 0
+	for i := range sent.chunks {
+		burst = pace(burst, len(sent.chunks[i]))
+		err := node.sendFragment(key, sent, uint32(i), addr)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pace returns size of burst including next fragment, sleeping first if burst is full.
+func pace(burst, next int) int {
+	if burst+next > fragmentBurst {
+		time.Sleep(fragmentPause)
+		return next
+	}
+	return burst + next
+}
+
+func (node *UdpNode) sendFragment(key fragmentKey, sent *fragments, i uint32, addr *net.UDPAddr) error {
+	fragment := &Message{
+		Type:       Message_FRAGMENT,
+		CallId:     key.callId,
+		Fragmented: key.kind,
+		Fragment:   i,
+		Fragments:  uint32(len(sent.chunks)),
+		Payload:    sent.chunks[i],
+	}
+	buf, err := proto.Marshal(fragment)
+	if err != nil {
+		return err
+	}
+	node.metrics.fragmentsSent.Add(1)
+	return node.write(buf, addr)
+}
+
+// handleFragment dispatches message once all its fragments arrived, returns false when node is closed.
+func (node *UdpNode) handleFragment(fragment *Message, addr *net.UDPAddr) bool {
+	if fragment.Fragments == 0 || fragment.Fragment >= fragment.Fragments || fragment.Fragments > node.maxFragments() ||
+		(fragment.Fragmented != Message_REQUEST && fragment.Fragmented != Message_RESPONSE) {
+		node.Logger.Log(logging.Debug, "received invalid fragment", logging.F("addr", addr),
+			logging.F("call", fragment.CallId), logging.F("fragment", fragment.Fragment))
+		return true
+	}
+	key := fragmentKey{addr.String(), fragment.Fragmented, fragment.CallId}
+	node.fragmentsMutex.Lock()
+	a, ok := node.incoming[key]
+	if !ok {
+		if len(node.incoming) >= maxAssemblies || node.assemblies[key.addr] >= maxAssembliesPerAddr {
+			node.fragmentsMutex.Unlock()
+			node.Logger.Log(logging.Debug, "too many fragmented messages", logging.F("addr", addr),
+				logging.F("call", fragment.CallId))
+			return true
+		}
+		a = &assembly{total: fragment.Fragments, chunks: make(map[uint32][]byte)}
+		a.nack = time.AfterFunc(node.NackInterval, func() {
+			node.nack(key, a, addr)
+		})
+		a.expire = time.AfterFunc(node.ReassemblyTimeout, func() {
+			node.expire(key, a)
+		})
+		node.incoming[key] = a
+		node.assemblies[key.addr]++
+	}
+	if a.complete || fragment.Fragments != a.total {
+		node.fragmentsMutex.Unlock()
+		return true
+	}
+	if _, duplicate := a.chunks[fragment.Fragment]; !duplicate {
+		a.chunks[fragment.Fragment] = fragment.Payload
+		a.size += len(fragment.Payload)
+	}
+	if uint32(a.size) > node.MaxMessageSize {
+		node.drop(key, a)
+		node.fragmentsMutex.Unlock()
+		node.Logger.Log(logging.Debug, "fragmented message exceeds limit",
+			logging.F("addr", addr), logging.F("call", fragment.CallId))
+		return true
+	}
+	if uint32(len(a.chunks)) < a.total {
+		a.nack.Reset(node.NackInterval)
+		node.fragmentsMutex.Unlock()
+		return true
+	}
+	a.complete = true
+	a.nack.Stop()
+	buf := make([]byte, 0, a.size)
+	for i := uint32(0); i < a.total; i++ {
+		buf = append(buf, a.chunks[i]...)
+	}
+	a.chunks = nil
+	node.fragmentsMutex.Unlock()
+
+	message := &Message{}
+	err := proto.Unmarshal(buf, message)
+	if err != nil || message.Type != fragment.Fragmented || message.CallId != fragment.CallId {
+		node.Logger.Log(logging.Debug, "failed decoding fragmented message",
+			logging.F("addr", addr), logging.F("size", len(buf)), logging.F("error", err))
+		return true
+	}
+	return node.dispatch(message, addr)
+}
+
+// maxFragments returns number of fragments of message with MaxMessageSize.
+func (node *UdpNode) maxFragments() uint32 {
+	size := node.datagramSize() - fragmentOverhead
+	if size <= 0 {
+		return 0
+	}
+	return uint32((uint64(node.MaxMessageSize) + uint64(size) - 1) / uint64(size))
+}
+
+// nack asks sender for missing fragments, it repeats every NackInterval until message is complete.
+// Single nack is sent at a time, fragments missing above what fits into datagram are asked for next time.
+func (node *UdpNode) nack(key fragmentKey, a *assembly, addr *net.UDPAddr) {
+	limit := (node.datagramSize() - fragmentOverhead) / 5
+	node.fragmentsMutex.Lock()
+	if node.incoming[key] != a || a.complete {
+		node.fragmentsMutex.Unlock()
+		return
+	}
+	var missing []uint32
+	for i := uint32(0); i < a.total && len(missing) < limit; i++ {
+		if _, ok := a.chunks[i]; !ok {
+			missing = append(missing, i)
+		}
+	}
+	a.nack.Reset(node.NackInterval)
+	node.fragmentsMutex.Unlock()
+	if len(missing) == 0 || node.isClosed() {
+		return
+	}
+	message := &Message{Type: Message_NACK, CallId: key.callId, Fragmented: key.kind, Missing: missing}
+	err := node.send(message, addr)
+	if err != nil {
+		node.Logger.Log(logging.Debug, "failed sending nack", logging.F("addr", addr),
+			logging.F("call", key.callId), logging.F("error", err))
+	}
+}
+
+func (node *UdpNode) expire(key fragmentKey, a *assembly) {
+	node.fragmentsMutex.Lock()
+	defer node.fragmentsMutex.Unlock()
+	if node.incoming[key] != a {
+		return
+	}
+	if !a.complete {
+		node.metrics.reassemblyTimeouts.Add(1)
+		node.Logger.Log(logging.Debug, "dropped incomplete fragmented message", logging.F("addr", key.addr),
+			logging.F("call", key.callId), logging.F("received", len(a.chunks)), logging.F("fragments", a.total))
+	}
+	node.drop(key, a)
+}
+
+// drop must be called with fragmentsMutex held.
+func (node *UdpNode) drop(key fragmentKey, a *assembly) {
+	a.nack.Stop()
+	a.expire.Stop()
+	delete(node.incoming, key)
+	node.assemblies[key.addr]--
+	if node.assemblies[key.addr] <= 0 {
+		delete(node.assemblies, key.addr)
+	}
+}
+
+// handleNack retransmits fragments missing at receiver.
+func (node *UdpNode) handleNack(message *Message, addr *net.UDPAddr) {
+	key := fragmentKey{addr.String(), message.Fragmented, message.CallId}
+	node.fragmentsMutex.Lock()
+	sent, ok := node.outgoing[key]
+	node.fragmentsMutex.Unlock()
+	if !ok {
+		node.Logger.Log(logging.Debug, "received nack for unknown message",
+			logging.F("addr", addr), logging.F("call", message.CallId))
+		return
+	}
+	node.Logger.Log(logging.Debug, "nack", logging.F("missing", len(message.Missing)))
+	burst := 0
+	for _, i := range message.Missing {
+		if i >= uint32(len(sent.chunks)) {
+			continue
+		}
+		burst = pace(burst, len(sent.chunks[i]))
+		node.metrics.retransmits.Add(1)
+		err := node.sendFragment(key, sent, i, addr)
+		if err != nil {
+			node.Logger.Log(logging.Debug, "failed retransmitting fragment", logging.F("addr", addr),
+				logging.F("call", message.CallId), logging.F("fragment", i), logging.F("error", err))
+			return
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package rpc
+
+import (
+	"bytes"
+	"github.com/golang/protobuf/proto"
+	"github.com/mduszyk/gopeers/metrics"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFragmentedCall(t *testing.T) {
+	node1, err := NewUdpNode("localhost:", []Service{slow(0)}, callTimeout, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	go node1.Run()
+	defer node1.Close()
+	node2, err := NewUdpNode("localhost:", nil, callTimeout, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	registry := metrics.NewRegistry()
+	node2.SetMetrics(registry)
+	go node2.Run()
+	defer node2.Close()
+
+	payload := bytes.Repeat([]byte("fragment"), 100 * int(bufferSize))
+	response, err := node2.Call(node1.Addr, ServiceId(0), payload)
+	if err != nil {
+		t.Fatalf("failed calling rpc service: %v\n", err)
+	}
+	if !bytes.Equal(response, payload) {
+		t.Errorf("rpc service returned invalid response of %d bytes\n", len(response))
+	}
+
+	var buf bytes.Buffer
+	registry.WriteText(&buf)
+	if strings.Contains(buf.String(), "rpc_fragments_sent_total 0\n") {
+		t.Errorf("request should be sent in fragments:\n%s\n", buf.String())
+	}
+
+	node2.MaxMessageSize = uint32(len(payload) / 2)
+	_, err = node2.Call(node1.Addr, ServiceId(0), payload)
+	if err == nil {
+		t.Errorf("message over limit should be rejected\n")
+	}
+}
+
+// peer exchanges raw messages with node under test
+type peer struct {
+	t    *testing.T
+	conn *net.UDPConn
+}
+
+func newPeer(t *testing.T) *peer {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed listening: %v\n", err)
+	}
+	return &peer{t, conn}
+}
+
+func (p *peer) send(message *Message, addr *net.UDPAddr) {
+	buf, err := proto.Marshal(message)
+	if err != nil {
+		p.t.Fatalf("failed encoding message: %v\n", err)
+	}
+	_, err = p.conn.WriteToUDP(buf, addr)
+	if err != nil {
+		p.t.Fatalf("failed sending message: %v\n", err)
+	}
+}
+
+func (p *peer) receive() *Message {
+	buf := make([]byte, 65536)
+	_ = p.conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := p.conn.ReadFromUDP(buf)
+	if err != nil {
+		p.t.Fatalf("failed receiving message: %v\n", err)
+	}
+	message := &Message{}
+	err = proto.Unmarshal(buf[:n], message)
+	if err != nil {
+		p.t.Fatalf("failed decoding message: %v\n", err)
+	}
+	return message
+}
+
+func TestSelectiveRetransmit(t *testing.T) {
+	node, err := NewUdpNode("localhost:", nil, callTimeout, 256)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	node.NackInterval = 20 * time.Millisecond
+	go node.Run()
+	defer node.Close()
+	p := newPeer(t)
+	defer p.conn.Close()
+
+	payload := bytes.Repeat([]byte("x"), 1000)
+	responses := make(chan Payload, 1)
+	go func() {
+		response, err := node.Call(p.conn.LocalAddr().(*net.UDPAddr), ServiceId(0), payload)
+		if err != nil {
+			t.Errorf("call failed: %v\n", err)
+		}
+		responses <- response
+	}()
+
+	received := make(map[uint32][]byte)
+	var total uint32
+	var callId CallId
+	for i := 0; total == 0 || uint32(i) < total; i++ {
+		fragment := p.receive()
+		if fragment.Type != Message_FRAGMENT || fragment.Fragmented != Message_REQUEST {
+			t.Fatalf("expected request fragment, got: %v\n", fragment)
+		}
+		total, callId = fragment.Fragments, fragment.CallId
+		// lose odd fragments
+		if fragment.Fragment % 2 == 0 {
+			received[fragment.Fragment] = fragment.Payload
+		}
+	}
+	nack := &Message{Type: Message_NACK, CallId: callId, Fragmented: Message_REQUEST}
+	for i := uint32(0); i < total; i++ {
+		if _, ok := received[i]; !ok {
+			nack.Missing = append(nack.Missing, i)
+		}
+	}
+	p.send(nack, node.Addr)
+	for range nack.Missing {
+		fragment := p.receive()
+		if fragment.Fragment % 2 == 0 {
+			t.Errorf("only missing fragments should be retransmitted, got: %d\n", fragment.Fragment)
+		}
+		received[fragment.Fragment] = fragment.Payload
+	}
+	var buf []byte
+	for i := uint32(0); i < total; i++ {
+		buf = append(buf, received[i]...)
+	}
+	request := &Message{}
+	err = proto.Unmarshal(buf, request)
+	if err != nil || !bytes.Equal(request.Payload, payload) {
+		t.Fatalf("failed reassembling request: %v\n", err)
+	}
+
+	// respond with fragments, the first one lost until node asks for it
+	responseBuf, err := proto.Marshal(&Message{Type: Message_RESPONSE, CallId: callId, Payload: payload})
+	if err != nil {
+		t.Fatalf("failed encoding response: %v\n", err)
+	}
+	var chunks [][]byte
+	for len(responseBuf) > 0 {
+		n := 150
+		if n > len(responseBuf) {
+			n = len(responseBuf)
+		}
+		chunks = append(chunks, responseBuf[:n])
+		responseBuf = responseBuf[n:]
+	}
+	fragment := func(i int) *Message {
+		return &Message{Type: Message_FRAGMENT, CallId: callId, Fragmented: Message_RESPONSE,
+			Fragment: uint32(i), Fragments: uint32(len(chunks)), Payload: chunks[i]}
+	}
+	for i := 1; i < len(chunks); i++ {
+		p.send(fragment(i), node.Addr)
+	}
+	nack = p.receive()
+	if nack.Type != Message_NACK || nack.CallId != callId || len(nack.Missing) != 1 || nack.Missing[0] != 0 {
+		t.Fatalf("expected nack of first fragment, got: %v\n", nack)
+	}
+	p.send(fragment(0), node.Addr)
+	select {
+	case response := <-responses:
+		if !bytes.Equal(response, payload) {
+			t.Errorf("invalid reassembled response of %d bytes\n", len(response))
+		}
+	case <-time.After(time.Second):
+		t.Errorf("call should complete once all fragments arrived\n")
+	}
+}
+
+func TestReassemblyTimeout(t *testing.T) {
+	node, err := NewUdpNode("localhost:", []Service{slow(0)}, callTimeout, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	node.ReassemblyTimeout = 50 * time.Millisecond
+	registry := metrics.NewRegistry()
+	node.SetMetrics(registry)
+	go node.Run()
+	defer node.Close()
+	p := newPeer(t)
+	defer p.conn.Close()
+
+	p.send(&Message{Type: Message_FRAGMENT, CallId: 1, Fragment: 0, Fragments: 2, Payload: []byte("half")}, node.Addr)
+	time.Sleep(200 * time.Millisecond)
+	node.fragmentsMutex.Lock()
+	incomplete := len(node.incoming)
+	node.fragmentsMutex.Unlock()
+	if incomplete != 0 {
+		t.Errorf("incomplete message should be dropped\n")
+	}
+	var buf bytes.Buffer
+	registry.WriteText(&buf)
+	if !strings.Contains(buf.String(), "rpc_reassembly_timeouts_total 1\n") {
+		t.Errorf("timeout should be counted:\n%s\n", buf.String())
+	}
+}
+
+func TestFragmentLimits(t *testing.T) {
+	node, err := NewUdpNode("localhost:", nil, callTimeout, 256)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	node.NackInterval = 20 * time.Millisecond
+	go node.Run()
+	defer node.Close()
+	p := newPeer(t)
+	defer p.conn.Close()
+	assemblies := func() int {
+		node.fragmentsMutex.Lock()
+		defer node.fragmentsMutex.Unlock()
+		return len(node.incoming)
+	}
+
+	p.send(&Message{Type: Message_FRAGMENT, CallId: 1, Fragmented: Message_REQUEST,
+		Fragment: 0, Fragments: 0xFFFFFFFF, Payload: []byte("huge")}, node.Addr)
+	time.Sleep(50 * time.Millisecond)
+	if assemblies() != 0 {
+		t.Errorf("message with more fragments than allowed should be dropped\n")
+	}
+
+	// only one nack of datagram size is sent for message with many fragments missing
+	p.send(&Message{Type: Message_FRAGMENT, CallId: 2, Fragmented: Message_REQUEST,
+		Fragment: 0, Fragments: node.maxFragments(), Payload: []byte("first")}, node.Addr)
+	nack := p.receive()
+	limit := (node.datagramSize() - fragmentOverhead) / 5
+	if nack.Type != Message_NACK || len(nack.Missing) != limit || nack.Missing[0] != 1 {
+		t.Errorf("nack should ask for first %d missing fragments, got: %d\n", limit, len(nack.Missing))
+	}
+
+	for i := 0; i < 2*maxAssembliesPerAddr; i++ {
+		p.send(&Message{Type: Message_FRAGMENT, CallId: CallId(10 + i), Fragmented: Message_REQUEST,
+			Fragment: 0, Fragments: 2, Payload: []byte("half")}, node.Addr)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := assemblies(); n != maxAssembliesPerAddr {
+		t.Errorf("assemblies of single peer should be limited, got: %d\n", n)
+	}
+}
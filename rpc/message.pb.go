@@ -23,8 +23,12 @@ const (
 type Message_TypeEnum int32
 
 const (
-	Message_REQUEST  Message_TypeEnum = 0
-	Message_RESPONSE Message_TypeEnum = 1
+	Message_REQUEST   Message_TypeEnum = 0
+	Message_RESPONSE  Message_TypeEnum = 1
+	Message_FRAGMENT  Message_TypeEnum = 2
+	Message_NACK      Message_TypeEnum = 3
+	Message_HANDSHAKE Message_TypeEnum = 4
+	Message_SEALED    Message_TypeEnum = 5
 )
 
 // Enum value maps for Message_TypeEnum.
@@ -32,10 +36,18 @@ var (
 	Message_TypeEnum_name = map[int32]string{
 		0: "REQUEST",
 		1: "RESPONSE",
+		2: "FRAGMENT",
+		3: "NACK",
+		4: "HANDSHAKE",
+		5: "SEALED",
 	}
 	Message_TypeEnum_value = map[string]int32{
-		"REQUEST":  0,
-		"RESPONSE": 1,
+		"REQUEST":   0,
+		"RESPONSE":  1,
+		"FRAGMENT":  2,
+		"NACK":      3,
+		"HANDSHAKE": 4,
+		"SEALED":    5,
 	}
 )
 
@@ -71,11 +83,18 @@ type Message struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Type      Message_TypeEnum `protobuf:"varint,1,opt,name=Type,proto3,enum=rpc.Message_TypeEnum" json:"Type,omitempty"`
-	ServiceId uint32           `protobuf:"varint,2,opt,name=ServiceId,proto3" json:"ServiceId,omitempty"`
-	CallId    uint64           `protobuf:"varint,3,opt,name=CallId,proto3" json:"CallId,omitempty"`
-	Payload   []byte           `protobuf:"bytes,4,opt,name=Payload,proto3" json:"Payload,omitempty"`
-	Error     []byte           `protobuf:"bytes,5,opt,name=Error,proto3" json:"Error,omitempty"`
+	Type       Message_TypeEnum `protobuf:"varint,1,opt,name=Type,proto3,enum=rpc.Message_TypeEnum" json:"Type,omitempty"`
+	ServiceId  uint32           `protobuf:"varint,2,opt,name=ServiceId,proto3" json:"ServiceId,omitempty"`
+	CallId     uint64           `protobuf:"varint,3,opt,name=CallId,proto3" json:"CallId,omitempty"`
+	Payload    []byte           `protobuf:"bytes,4,opt,name=Payload,proto3" json:"Payload,omitempty"`
+	Error      []byte           `protobuf:"bytes,5,opt,name=Error,proto3" json:"Error,omitempty"`
+	Port       uint32           `protobuf:"varint,6,opt,name=Port,proto3" json:"Port,omitempty"`
+	Fragment   uint32           `protobuf:"varint,7,opt,name=Fragment,proto3" json:"Fragment,omitempty"`
+	Fragments  uint32           `protobuf:"varint,8,opt,name=Fragments,proto3" json:"Fragments,omitempty"`
+	Fragmented Message_TypeEnum `protobuf:"varint,9,opt,name=Fragmented,proto3,enum=rpc.Message_TypeEnum" json:"Fragmented,omitempty"`
+	Missing    []uint32         `protobuf:"varint,10,rep,packed,name=Missing,proto3" json:"Missing,omitempty"`
+	Session    uint64           `protobuf:"varint,11,opt,name=Session,proto3" json:"Session,omitempty"`
+	Nonce      uint64           `protobuf:"varint,12,opt,name=Nonce,proto3" json:"Nonce,omitempty"`
 }
 
 func (x *Message) Reset() {
@@ -145,11 +164,131 @@ func (x *Message) GetError() []byte {
 	return nil
 }
 
+func (x *Message) GetPort() uint32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *Message) GetFragment() uint32 {
+	if x != nil {
+		return x.Fragment
+	}
+	return 0
+}
+
+func (x *Message) GetFragments() uint32 {
+	if x != nil {
+		return x.Fragments
+	}
+	return 0
+}
+
+func (x *Message) GetFragmented() Message_TypeEnum {
+	if x != nil {
+		return x.Fragmented
+	}
+	return Message_REQUEST
+}
+
+func (x *Message) GetMissing() []uint32 {
+	if x != nil {
+		return x.Missing
+	}
+	return nil
+}
+
+func (x *Message) GetSession() uint64 {
+	if x != nil {
+		return x.Session
+	}
+	return 0
+}
+
+func (x *Message) GetNonce() uint64 {
+	if x != nil {
+		return x.Nonce
+	}
+	return 0
+}
+
+type Handshake struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Ephemeral []byte `protobuf:"bytes,1,opt,name=Ephemeral,proto3" json:"Ephemeral,omitempty"`
+	PublicKey []byte `protobuf:"bytes,2,opt,name=PublicKey,proto3" json:"PublicKey,omitempty"`
+	Signature []byte `protobuf:"bytes,3,opt,name=Signature,proto3" json:"Signature,omitempty"`
+	Response  bool   `protobuf:"varint,4,opt,name=Response,proto3" json:"Response,omitempty"`
+}
+
+func (x *Handshake) Reset() {
+	*x = Handshake{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_message_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Handshake) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Handshake) ProtoMessage() {}
+
+func (x *Handshake) ProtoReflect() protoreflect.Message {
+	mi := &file_message_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Handshake.ProtoReflect.Descriptor instead.
+func (*Handshake) Descriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Handshake) GetEphemeral() []byte {
+	if x != nil {
+		return x.Ephemeral
+	}
+	return nil
+}
+
+func (x *Handshake) GetPublicKey() []byte {
+	if x != nil {
+		return x.PublicKey
+	}
+	return nil
+}
+
+func (x *Handshake) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+func (x *Handshake) GetResponse() bool {
+	if x != nil {
+		return x.Response
+	}
+	return false
+}
+
 var File_message_proto protoreflect.FileDescriptor
 
 var file_message_proto_rawDesc = []byte{
 	0x0a, 0x0d, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12,
-	0x03, 0x72, 0x70, 0x63, 0x22, 0xc1, 0x01, 0x0a, 0x07, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65,
+	0x03, 0x72, 0x70, 0x63, 0x22, 0xc3, 0x03, 0x0a, 0x07, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65,
 	0x12, 0x29, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x15,
 	0x2e, 0x72, 0x70, 0x63, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2e, 0x54, 0x79, 0x70,
 	0x65, 0x45, 0x6e, 0x75, 0x6d, 0x52, 0x04, 0x54, 0x79, 0x70, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x53,
@@ -159,10 +298,34 @@ var file_message_proto_rawDesc = []byte{
 	0x64, 0x12, 0x18, 0x0a, 0x07, 0x50, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x18, 0x04, 0x20, 0x01,
 	0x28, 0x0c, 0x52, 0x07, 0x50, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x45,
 	0x72, 0x72, 0x6f, 0x72, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x45, 0x72, 0x72, 0x6f,
-	0x72, 0x22, 0x25, 0x0a, 0x08, 0x54, 0x79, 0x70, 0x65, 0x45, 0x6e, 0x75, 0x6d, 0x12, 0x0b, 0x0a,
-	0x07, 0x52, 0x45, 0x51, 0x55, 0x45, 0x53, 0x54, 0x10, 0x00, 0x12, 0x0c, 0x0a, 0x08, 0x52, 0x45,
-	0x53, 0x50, 0x4f, 0x4e, 0x53, 0x45, 0x10, 0x01, 0x42, 0x07, 0x5a, 0x05, 0x2e, 0x3b, 0x72, 0x70,
-	0x63, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x72, 0x12, 0x12, 0x0a, 0x04, 0x50, 0x6f, 0x72, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x04, 0x50, 0x6f, 0x72, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x46, 0x72, 0x61, 0x67, 0x6d, 0x65, 0x6e,
+	0x74, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x46, 0x72, 0x61, 0x67, 0x6d, 0x65, 0x6e,
+	0x74, 0x12, 0x1c, 0x0a, 0x09, 0x46, 0x72, 0x61, 0x67, 0x6d, 0x65, 0x6e, 0x74, 0x73, 0x18, 0x08,
+	0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x46, 0x72, 0x61, 0x67, 0x6d, 0x65, 0x6e, 0x74, 0x73, 0x12,
+	0x35, 0x0a, 0x0a, 0x46, 0x72, 0x61, 0x67, 0x6d, 0x65, 0x6e, 0x74, 0x65, 0x64, 0x18, 0x09, 0x20,
+	0x01, 0x28, 0x0e, 0x32, 0x15, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67,
+	0x65, 0x2e, 0x54, 0x79, 0x70, 0x65, 0x45, 0x6e, 0x75, 0x6d, 0x52, 0x0a, 0x46, 0x72, 0x61, 0x67,
+	0x6d, 0x65, 0x6e, 0x74, 0x65, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x4d, 0x69, 0x73, 0x73, 0x69, 0x6e,
+	0x67, 0x18, 0x0a, 0x20, 0x03, 0x28, 0x0d, 0x52, 0x07, 0x4d, 0x69, 0x73, 0x73, 0x69, 0x6e, 0x67,
+	0x12, 0x18, 0x0a, 0x07, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x0b, 0x20, 0x01, 0x28,
+	0x04, 0x52, 0x07, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x14, 0x0a, 0x05, 0x4e, 0x6f,
+	0x6e, 0x63, 0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05, 0x4e, 0x6f, 0x6e, 0x63, 0x65,
+	0x22, 0x58, 0x0a, 0x08, 0x54, 0x79, 0x70, 0x65, 0x45, 0x6e, 0x75, 0x6d, 0x12, 0x0b, 0x0a, 0x07,
+	0x52, 0x45, 0x51, 0x55, 0x45, 0x53, 0x54, 0x10, 0x00, 0x12, 0x0c, 0x0a, 0x08, 0x52, 0x45, 0x53,
+	0x50, 0x4f, 0x4e, 0x53, 0x45, 0x10, 0x01, 0x12, 0x0c, 0x0a, 0x08, 0x46, 0x52, 0x41, 0x47, 0x4d,
+	0x45, 0x4e, 0x54, 0x10, 0x02, 0x12, 0x08, 0x0a, 0x04, 0x4e, 0x41, 0x43, 0x4b, 0x10, 0x03, 0x12,
+	0x0d, 0x0a, 0x09, 0x48, 0x41, 0x4e, 0x44, 0x53, 0x48, 0x41, 0x4b, 0x45, 0x10, 0x04, 0x12, 0x0a,
+	0x0a, 0x06, 0x53, 0x45, 0x41, 0x4c, 0x45, 0x44, 0x10, 0x05, 0x22, 0x81, 0x01, 0x0a, 0x09, 0x48,
+	0x61, 0x6e, 0x64, 0x73, 0x68, 0x61, 0x6b, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x45, 0x70, 0x68, 0x65,
+	0x6d, 0x65, 0x72, 0x61, 0x6c, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x45, 0x70, 0x68,
+	0x65, 0x6d, 0x65, 0x72, 0x61, 0x6c, 0x12, 0x1c, 0x0a, 0x09, 0x50, 0x75, 0x62, 0x6c, 0x69, 0x63,
+	0x4b, 0x65, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x50, 0x75, 0x62, 0x6c, 0x69,
+	0x63, 0x4b, 0x65, 0x79, 0x12, 0x1c, 0x0a, 0x09, 0x53, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72,
+	0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x53, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75,
+	0x72, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x08, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x07,
+	0x5a, 0x05, 0x2e, 0x3b, 0x72, 0x70, 0x63, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -178,18 +341,20 @@ func file_message_proto_rawDescGZIP() []byte {
 }
 
 var file_message_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_message_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_message_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
 var file_message_proto_goTypes = []interface{}{
 	(Message_TypeEnum)(0), // 0: rpc.Message.TypeEnum
 	(*Message)(nil),       // 1: rpc.Message
+	(*Handshake)(nil),     // 2: rpc.Handshake
 }
 var file_message_proto_depIdxs = []int32{
 	0, // 0: rpc.Message.Type:type_name -> rpc.Message.TypeEnum
-	1, // [1:1] is the sub-list for method output_type
-	1, // [1:1] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	0, // 1: rpc.Message.Fragmented:type_name -> rpc.Message.TypeEnum
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_message_proto_init() }
@@ -210,6 +375,18 @@ func file_message_proto_init() {
 				return nil
 			}
 		}
+		file_message_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Handshake); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -217,7 +394,7 @@ func file_message_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_message_proto_rawDesc,
 			NumEnums:      1,
-			NumMessages:   1,
+			NumMessages:   2,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
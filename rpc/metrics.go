@@ -0,0 +1,61 @@
+package rpc
+
+import (
+	"github.com/mduszyk/gopeers/metrics"
+)
+
+type rpcMetrics struct {
+	calls         metrics.Counter
+	callErrors    metrics.Counter
+	callTimeouts  metrics.Counter
+	callDuration  metrics.Histogram
+	pending       metrics.Gauge
+	requests      metrics.Counter
+	requestErrors metrics.Counter
+	bytesSent     metrics.Counter
+	bytesReceived metrics.Counter
+	// udp only
+	fragmentsSent      metrics.Counter
+	retransmits        metrics.Counter
+	reassemblyTimeouts metrics.Counter
+	handshakes         metrics.Counter
+	sessionRejects     metrics.Counter
+}
+
+func newRpcMetrics(m metrics.Metrics, prefix, transport string) *rpcMetrics {
+	return &rpcMetrics{
+		calls:         m.Counter(prefix+"_calls_total", "Outgoing rpc calls over "+transport+"."),
+		callErrors:    m.Counter(prefix+"_call_errors_total", "Outgoing rpc calls over "+transport+" which failed, including timeouts."),
+		callTimeouts:  m.Counter(prefix+"_call_timeouts_total", "Outgoing rpc calls over "+transport+" which timed out."),
+		callDuration:  m.Histogram(prefix+"_call_duration_seconds", "Duration of successful outgoing rpc calls over "+transport+".", metrics.DurationBuckets),
+		pending:       m.Gauge(prefix+"_pending_calls", "Outgoing rpc calls over "+transport+" waiting for response."),
+		requests:      m.Counter(prefix+"_requests_total", "Handled incoming rpc requests over "+transport+"."),
+		requestErrors: m.Counter(prefix+"_request_errors_total", "Incoming rpc requests over "+transport+" for which service returned error."),
+		bytesSent:     m.Counter(prefix+"_sent_bytes_total", "Bytes sent over "+transport+"."),
+		bytesReceived: m.Counter(prefix+"_received_bytes_total", "Bytes received over "+transport+"."),
+	}
+}
+
+func newUdpMetrics(m metrics.Metrics) *rpcMetrics {
+	udp := newRpcMetrics(m, "rpc", "udp")
+	udp.fragmentsSent = m.Counter("rpc_fragments_sent_total", "Fragments of messages too large for single datagram sent over udp.")
+	udp.retransmits = m.Counter("rpc_fragment_retransmits_total", "Fragments sent again on request of receiver.")
+	udp.reassemblyTimeouts = m.Counter("rpc_reassembly_timeouts_total", "Fragmented messages dropped incomplete.")
+	udp.handshakes = m.Counter("rpc_session_handshakes_total", "Handshakes of encrypted sessions started.")
+	udp.sessionRejects = m.Counter("rpc_session_rejected_total", "Messages dropped by encrypted node, including plaintext ones.")
+	return udp
+}
+
+func newTcpMetrics(m metrics.Metrics) *rpcMetrics {
+	return newRpcMetrics(m, "rpc_tcp", "tcp")
+}
+
+// SetMetrics makes node report into m, it should be called before Run.
+func (node *UdpNode) SetMetrics(m metrics.Metrics) {
+	node.metrics = newUdpMetrics(m)
+}
+
+// SetMetrics makes node report into m, it should be called before Run.
+func (node *TcpNode) SetMetrics(m metrics.Metrics) {
+	node.metrics = newTcpMetrics(m)
+}
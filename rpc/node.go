@@ -1,9 +1,12 @@
 package rpc
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/golang/protobuf/proto"
-	"log"
+	"github.com/mduszyk/gopeers/logging"
+	"github.com/mduszyk/gopeers/metrics"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -20,20 +23,52 @@ type ServiceId = uint32
 
 type Service func(addr *net.UDPAddr, payload Payload) (Payload, error)
 
+var ErrClosed = errors.New("udp node closed")
+
 type pendingCall struct {
 	request *Message
 	response chan *Message
+	addr *net.UDPAddr
+	started time.Time
+}
+
+// PendingCall describes call waiting for response.
+type PendingCall struct {
+	CallId CallId
+	ServiceId ServiceId
+	Addr *net.UDPAddr
+	Started time.Time
 }
 
 type UdpNode struct {
-	Addr            *net.UDPAddr
-	Services        []Service
-	conn            *net.UDPConn
-	pendingRequests map[CallId]*pendingCall
-	pendingMutex    *sync.RWMutex
-	callTimeout     time.Duration
-	readBufferSize  uint32
-	lastCallId      uint64
+	Addr     *net.UDPAddr
+	Services []Service
+	// larger messages are sent in fragments, it must not exceed read buffer size of peers
+	MaxDatagramSize uint32
+	// larger fragmented messages are dropped
+	MaxMessageSize uint32
+	// incomplete fragmented message is dropped after ReassemblyTimeout,
+	// missing fragments are requested after NackInterval without receiving any
+	ReassemblyTimeout time.Duration
+	NackInterval      time.Duration
+	RekeyInterval     time.Duration // encrypted sessions are renegotiated after RekeyInterval
+	conn              *net.UDPConn
+	pendingRequests   map[CallId]*pendingCall
+	pendingMutex      *sync.RWMutex
+	callTimeout       time.Duration
+	readBufferSize    uint32
+	lastCallId        uint64
+	done              chan struct{}
+	closed            bool
+	closeMutex        *sync.Mutex
+	handlers          *sync.WaitGroup
+	incoming          map[fragmentKey]*assembly
+	assemblies        map[string]int
+	outgoing          map[fragmentKey]*fragments
+	fragmentsMutex    *sync.Mutex
+	secure            *sessions
+	metrics           *rpcMetrics
+	Logger            logging.Logger
 }
 
 func NewUdpNode(
@@ -54,70 +89,124 @@ func NewUdpNode(
 	if err != nil {
 		return nil, err
 	}
-	log.Printf("UdpNode addr: %v\n", addr)
+	maxDatagramSize := readBufferSize
+	if maxDatagramSize > maxUdpPayload {
+		maxDatagramSize = maxUdpPayload
+	}
 	node := &UdpNode{
-		callTimeout:     callTimeout,
-		readBufferSize:  readBufferSize,
-		pendingRequests: make(map[CallId]*pendingCall),
-		pendingMutex:    &sync.RWMutex{},
-		Services:        services,
-		Addr:            addr,
-		conn:            conn,
+		MaxDatagramSize:   maxDatagramSize,
+		MaxMessageSize:    DefaultMaxFrameSize,
+		ReassemblyTimeout: callTimeout,
+		NackInterval:      defaultNackInterval,
+		RekeyInterval:     defaultRekeyInterval,
+		callTimeout:       callTimeout,
+		readBufferSize:    readBufferSize,
+		pendingRequests:   make(map[CallId]*pendingCall),
+		pendingMutex:      &sync.RWMutex{},
+		Services:          services,
+		Addr:              addr,
+		conn:              conn,
+		done:              make(chan struct{}),
+		closeMutex:        &sync.Mutex{},
+		handlers:          &sync.WaitGroup{},
+		incoming:          make(map[fragmentKey]*assembly),
+		assemblies:        make(map[string]int),
+		outgoing:          make(map[fragmentKey]*fragments),
+		fragmentsMutex:    &sync.Mutex{},
+		metrics:           newUdpMetrics(metrics.Nop),
+		Logger:            logging.Nop,
 	}
 	return node, nil
 }
 
 func (node *UdpNode) Run() {
-	var message Message
+	node.Logger.Log(logging.Info, "udp node listening", logging.F("addr", node.Addr))
 	buf := make([]byte, node.readBufferSize)
 	for {
 		n, addr, err := node.conn.ReadFromUDP(buf)
 		if err != nil {
-			log.Printf("failed reading from udp conn, error: %s\n", err)
+			if node.isClosed() {
+				return
+			}
+			node.Logger.Log(logging.Warn, "failed reading from udp conn", logging.F("error", err))
 			continue
 		}
-		err = proto.Unmarshal(buf[:n], &message)
+		node.metrics.bytesReceived.Add(float64(n))
+		message := &Message{}
+		err = proto.Unmarshal(buf[:n], message)
 		if err != nil {
-			log.Printf("failed decoding message: %s, error: %s\n", string(buf[:n]), err)
+			node.Logger.Log(logging.Debug, "failed decoding message",
+				logging.F("addr", addr), logging.F("size", n), logging.F("error", err))
 			continue
 		}
-		switch message.Type {
-		case Message_REQUEST:
-			go node.handleRequest(message, addr)
-		case Message_RESPONSE:
-			go node.handleResponse(message)
-		default:
-			log.Printf("received unsupported message type: %v\n", &message)
+		if node.secure != nil {
+			message = node.open(message, addr)
+			if message == nil {
+				continue
+			}
+		}
+		if !node.dispatch(message, addr) {
+			return
+		}
+	}
+}
+
+// dispatch returns false when node is closed.
+func (node *UdpNode) dispatch(message *Message, addr *net.UDPAddr) bool {
+	switch message.Type {
+	case Message_REQUEST:
+		if !node.startHandler() {
+			return false
 		}
+		go node.handleRequest(message, addr)
+	case Message_RESPONSE:
+		go node.handleResponse(message)
+	case Message_FRAGMENT:
+		return node.handleFragment(message, addr)
+	case Message_NACK:
+		node.handleNack(message, addr)
+	default:
+		node.Logger.Log(logging.Debug, "received unsupported message type",
+			logging.F("addr", addr), logging.F("type", message.Type))
 	}
+	return true
 }
 
-func (node *UdpNode) handleRequest(request Message, addr *net.UDPAddr) {
-	service := node.Services[request.ServiceId]
-	result, err := service(addr, request.Payload)
+func (node *UdpNode) handleRequest(request *Message, addr *net.UDPAddr) {
+	defer node.handlers.Done()
+	var result Payload
+	var err error
+	if int(request.ServiceId) < len(node.Services) {
+		result, err = node.Services[request.ServiceId](addr, request.Payload)
+	} else {
+		err = fmt.Errorf("unknown service: %d", request.ServiceId)
+	}
+	node.metrics.requests.Add(1)
 	response := &Message{
 		Type: Message_RESPONSE,
 		CallId: request.CallId,
 		Payload: result,
 	}
 	if err != nil {
+		node.metrics.requestErrors.Add(1)
 		response.Payload = nil
 		response.Error = Error(err.Error())
 	}
 	err = node.send(response, addr)
 	if err != nil {
-		log.Printf("failed sending response, request: %v, error: %s", &request, err)
+		node.Logger.Log(logging.Warn, "failed sending response", logging.F("addr", addr),
+			logging.F("call", request.CallId), logging.F("service", request.ServiceId), logging.F("error", err))
 	}
 }
 
-func (node *UdpNode) handleResponse(response Message) {
+func (node *UdpNode) handleResponse(response *Message) {
 	node.pendingMutex.RLock()
 	pending, ok := node.pendingRequests[response.CallId]
 	node.pendingMutex.RUnlock()
 	if ok {
-		pending.response <- &response
+		pending.response <- response
 	} else {
-		log.Printf("received unexpected response: %v\n", &response)
+		node.Logger.Log(logging.Debug, "received unexpected response", logging.F("call", response.CallId))
 	}
 }
 
@@ -126,7 +215,26 @@ func (node *UdpNode) send(message *Message, addr *net.UDPAddr) error {
 	if err != nil {
 		return err
 	}
+	if len(buf) > node.datagramSize() {
+		return node.sendFragments(message, buf, addr)
+	}
+	return node.write(buf, addr)
+}
+
+func (node *UdpNode) write(buf []byte, addr *net.UDPAddr) error {
+	if node.secure != nil {
+		sealed, err := node.seal(buf, addr)
+		if err != nil {
+			return err
+		}
+		buf = sealed
+	}
+	return node.writeDatagram(buf, addr)
+}
+
+func (node *UdpNode) writeDatagram(buf []byte, addr *net.UDPAddr) error {
 	n, err := node.conn.WriteToUDP(buf, addr)
+	node.metrics.bytesSent.Add(float64(n))
 	if err == nil && len(buf) != n {
 		return errors.New("incomplete udp write")
 	}
@@ -137,12 +245,14 @@ func (node *UdpNode) addPending(id CallId, pending *pendingCall) {
 	node.pendingMutex.Lock()
 	node.pendingRequests[id] = pending
 	node.pendingMutex.Unlock()
+	node.metrics.pending.Add(1)
 }
 
 func (node *UdpNode) removePending(id CallId) {
 	node.pendingMutex.Lock()
 	delete(node.pendingRequests, id)
 	node.pendingMutex.Unlock()
+	node.metrics.pending.Add(-1)
 }
 
 func (node *UdpNode) nextCallId() CallId {
@@ -150,27 +260,126 @@ func (node *UdpNode) nextCallId() CallId {
 }
 
 func (node *UdpNode) Call(addr *net.UDPAddr, serviceId ServiceId, payload Payload) (Payload, error) {
+	return node.CallContext(context.Background(), addr, serviceId, payload)
+}
+
+// CallContext returns when response arrives, call times out or ctx is done.
+func (node *UdpNode) CallContext(
+	ctx context.Context,
+	addr *net.UDPAddr,
+	serviceId ServiceId,
+	payload Payload,
+) (Payload, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	request := &Message{
 		Type:      Message_REQUEST,
 		ServiceId: serviceId,
 		CallId:    node.nextCallId(),
 		Payload:   payload,
 	}
-	pending := &pendingCall{request, make(chan *Message, 1)}
+	if node.isClosed() {
+		return nil, ErrClosed
+	}
+	pending := &pendingCall{request, make(chan *Message, 1), addr, time.Now()}
+	node.metrics.calls.Add(1)
 	node.addPending(request.CallId, pending)
+	defer node.removePending(request.CallId)
 	err := node.send(request, addr)
 	if err != nil {
+		node.metrics.callErrors.Add(1)
 		return nil, err
 	}
 	select {
 	case response := <-pending.response:
-		node.removePending(request.CallId)
 		if response.Error != nil {
+			node.metrics.callErrors.Add(1)
 			return nil, errors.New(string(response.Error))
 		}
+		node.metrics.callDuration.Observe(time.Since(pending.started).Seconds())
 		return response.Payload, nil
 	case <-time.After(node.callTimeout):
-		node.removePending(request.CallId)
+		node.metrics.callErrors.Add(1)
+		node.metrics.callTimeouts.Add(1)
 		return nil, errors.New("call timeout")
+	case <-node.done:
+		node.metrics.callErrors.Add(1)
+		return nil, ErrClosed
+	case <-ctx.Done():
+		node.metrics.callErrors.Add(1)
+		return nil, ctx.Err()
+	}
+}
+
+func (node *UdpNode) PendingCalls() []PendingCall {
+	node.pendingMutex.RLock()
+	defer node.pendingMutex.RUnlock()
+	calls := make([]PendingCall, 0, len(node.pendingRequests))
+	for id, pending := range node.pendingRequests {
+		calls = append(calls, PendingCall{id, pending.request.ServiceId, pending.addr, pending.started})
+	}
+	return calls
+}
+
+func (node *UdpNode) isClosed() bool {
+	node.closeMutex.Lock()
+	defer node.closeMutex.Unlock()
+	return node.closed
+}
+
+func (node *UdpNode) startHandler() bool {
+	node.closeMutex.Lock()
+	defer node.closeMutex.Unlock()
+	if node.closed {
+		return false
+	}
+	node.handlers.Add(1)
+	return true
+}
+
+// stop terminates read loop and fails pending calls, returns false if already stopped.
+func (node *UdpNode) stop() bool {
+	node.closeMutex.Lock()
+	defer node.closeMutex.Unlock()
+	if node.closed {
+		return false
+	}
+	node.closed = true
+	close(node.done)
+	// unblock read loop
+	_ = node.conn.SetReadDeadline(time.Now())
+	return true
+}
+
+// Shutdown stops receiving and fails pending calls with ErrClosed,
+// then waits for in-flight requests to be handled until ctx is done.
+func (node *UdpNode) Shutdown(ctx context.Context) error {
+	if !node.stop() {
+		return ErrClosed
+	}
+	drained := make(chan struct{})
+	go func() {
+		node.handlers.Wait()
+		close(drained)
+	}()
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	closeErr := node.conn.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// Close stops node without waiting for in-flight requests.
+func (node *UdpNode) Close() error {
+	if !node.stop() {
+		return ErrClosed
 	}
+	return node.conn.Close()
 }
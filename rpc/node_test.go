@@ -2,9 +2,12 @@ package rpc
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"github.com/mduszyk/gopeers/metrics"
 	"log"
 	"net"
+	"strings"
 	"testing"
 	"time"
 )
@@ -105,4 +108,234 @@ func TestRpcNode(t *testing.T) {
 	if !bytes.Equal(failurePayloads[0], []byte("test4")) {
 		t.Errorf("rpc service was not called\n")
 	}
+
+	_, err = node1.Call(node2.Addr, ServiceId(2), []byte("test5"))
+	if err == nil || err.Error() != "unknown service: 2" {
+		t.Errorf("call to unknown service should fail, got: %v\n", err)
+	}
+}
+
+
+func slow(delay time.Duration) Service {
+	return func(addr *net.UDPAddr, payload Payload) (Payload, error) {
+		time.Sleep(delay)
+		return payload, nil
+	}
+}
+
+func TestShutdownDrainsHandlers(t *testing.T) {
+	node1, err := NewUdpNode("localhost:", []Service{slow(100 * time.Millisecond)}, callTimeout, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		node1.Run()
+		close(stopped)
+	}()
+	node2, err := NewUdpNode("localhost:", nil, callTimeout, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	go node2.Run()
+	defer node2.Close()
+
+	responses := make(chan error, 1)
+	go func() {
+		_, err := node2.Call(node1.Addr, ServiceId(0), []byte("slow"))
+		responses <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = node1.Shutdown(ctx)
+	if err != nil {
+		t.Errorf("failed shutting down: %v\n", err)
+	}
+	if err := <-responses; err != nil {
+		t.Errorf("in-flight call should be handled: %v\n", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Errorf("read loop should stop\n")
+	}
+
+	_, err = node2.Call(node1.Addr, ServiceId(0), []byte("closed"))
+	if err == nil {
+		t.Errorf("call to closed node should fail\n")
+	}
+	if node1.Close() != ErrClosed {
+		t.Errorf("closing twice should fail\n")
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	node1, err := NewUdpNode("localhost:", []Service{slow(time.Second)}, callTimeout, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	go node1.Run()
+	node2, err := NewUdpNode("localhost:", nil, callTimeout, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	go node2.Run()
+	defer node2.Close()
+
+	go func() {
+		_, _ = node2.Call(node1.Addr, ServiceId(0), []byte("slow"))
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+	defer cancel()
+	err = node1.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("shutdown should hit deadline, got: %v\n", err)
+	}
+}
+
+func TestClosePendingCall(t *testing.T) {
+	node1, err := NewUdpNode("localhost:", []Service{slow(time.Second)}, callTimeout, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	go node1.Run()
+	defer node1.Close()
+	node2, err := NewUdpNode("localhost:", nil, callTimeout, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	go node2.Run()
+
+	responses := make(chan error, 1)
+	go func() {
+		_, err := node2.Call(node1.Addr, ServiceId(0), []byte("pending"))
+		responses <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	err = node2.Close()
+	if err != nil {
+		t.Errorf("failed closing: %v\n", err)
+	}
+	select {
+	case err := <-responses:
+		if err != ErrClosed {
+			t.Errorf("pending call should fail with ErrClosed, got: %v\n", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Errorf("pending call should fail immediately\n")
+	}
+	_, err = node2.Call(node1.Addr, ServiceId(0), []byte("closed"))
+	if err != ErrClosed {
+		t.Errorf("call on closed node should fail with ErrClosed, got: %v\n", err)
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	node1, err := NewUdpNode("localhost:", []Service{slow(time.Second)}, callTimeout, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	go node1.Run()
+	defer node1.Close()
+	node2, err := NewUdpNode("localhost:", nil, callTimeout, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	go node2.Run()
+	defer node2.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = node2.CallContext(ctx, node1.Addr, ServiceId(0), []byte("slow"))
+	if err != context.DeadlineExceeded {
+		t.Errorf("call should fail with context error, got: %v\n", err)
+	}
+	if time.Since(start) > 500 * time.Millisecond {
+		t.Errorf("call should be aborted by context\n")
+	}
+
+	_, err = node2.CallContext(ctx, node1.Addr, ServiceId(0), []byte("done"))
+	if err != context.DeadlineExceeded {
+		t.Errorf("call with done context should fail, got: %v\n", err)
+	}
+}
+
+func TestPendingCalls(t *testing.T) {
+	node1, err := NewUdpNode("localhost:", []Service{slow(200 * time.Millisecond)}, callTimeout, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	go node1.Run()
+	defer node1.Close()
+	node2, err := NewUdpNode("localhost:", nil, callTimeout, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	go node2.Run()
+	defer node2.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := node2.Call(node1.Addr, ServiceId(0), []byte("slow"))
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	calls := node2.PendingCalls()
+	if len(calls) != 1 || calls[0].Addr.String() != node1.Addr.String() || calls[0].ServiceId != 0 {
+		t.Errorf("expected one pending call to node 1, got: %v\n", calls)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("call failed: %v\n", err)
+	}
+	if calls := node2.PendingCalls(); len(calls) != 0 {
+		t.Errorf("finished call should not be pending: %v\n", calls)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	node1, err := NewUdpNode("localhost:", []Service{slow(0)}, 100*time.Millisecond, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	go node1.Run()
+	defer node1.Close()
+	node2, err := NewUdpNode("localhost:", nil, 100*time.Millisecond, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	registry := metrics.NewRegistry()
+	node2.SetMetrics(registry)
+	go node2.Run()
+	defer node2.Close()
+
+	_, err = node2.Call(node1.Addr, ServiceId(0), []byte("fast"))
+	if err != nil {
+		t.Errorf("call failed: %v\n", err)
+	}
+	// nothing listens there, call times out
+	dead, err := net.ResolveUDPAddr("udp", "localhost:1")
+	if err != nil {
+		t.Fatalf("failed resolving address: %v\n", err)
+	}
+	_, err = node2.Call(dead, ServiceId(0), []byte("lost"))
+	if err == nil {
+		t.Errorf("call to dead address should fail\n")
+	}
+
+	var buf bytes.Buffer
+	registry.WriteText(&buf)
+	for _, line := range []string{
+		"rpc_calls_total 2", "rpc_call_errors_total 1", "rpc_call_timeouts_total 1",
+		"rpc_call_duration_seconds_count 1", "rpc_pending_calls 0",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s\n", line, buf.String())
+		}
+	}
 }
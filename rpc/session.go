@@ -0,0 +1,627 @@
+package rpc
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"github.com/mduszyk/gopeers/logging"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultRekeyInterval = 10 * time.Minute
+
+// session is rekeyed after sending rekeyMessages messages regardless of its age
+const rekeyMessages = 1 << 24
+
+// room for session fields and authentication tag around sealed message
+const sealOverhead = 48
+
+const handshakeRetry = 200 * time.Millisecond
+
+// width of window of recently received nonces, older nonces are rejected as replays
+const replayWindow = 64
+
+// handshakes with peers are rejected above these limits, sessions not confirmed by a sealed message
+// from the peer are dropped after call timeout
+const (
+	maxSessions          = 4096
+	maxSessionsPerAddr   = 8
+	maxPendingHandshakes = 256
+)
+
+// handshakes started because of sealed messages of unknown sessions, per second and burst
+const (
+	unknownSessionRate  = 10
+	unknownSessionBurst = 20
+)
+
+var (
+	handshakeInit     = []byte("gopeers handshake init")
+	handshakeResponse = []byte("gopeers handshake response")
+	sessionInfo       = []byte("gopeers session")
+)
+
+// session holds keys agreed with peer, each direction has its own key and nonce counter.
+type session struct {
+	id      uint64
+	addr    string
+	peerKey ed25519.PublicKey
+	out     cipher.AEAD
+	in      cipher.AEAD
+	created time.Time
+	// responder's session is used for sending once peer sealed a message with it
+	confirmed bool
+	expire    *time.Timer
+	// last nonce sent, accessed atomically
+	nonce  uint64
+	mutex  *sync.Mutex
+	latest uint64
+	seen   uint64
+}
+
+// handshake started by node, waiting for response of peer.
+type handshake struct {
+	private []byte
+	public  []byte
+	done    chan struct{}
+	session *session
+	err     error
+}
+
+type sessions struct {
+	key     ed25519.PrivateKey
+	mutex   *sync.Mutex
+	byId    map[uint64]*session
+	byAddr  map[string]*session
+	pending map[string]*handshake
+	// number of sessions by addr
+	perAddr map[string]int
+	// responses keyed by ephemeral key of initiator, so that repeated handshake gets the same answer
+	responses map[string][]byte
+	// token bucket limiting handshakes started by unknown sessions
+	tokens  float64
+	refills time.Time
+}
+
+// EnableEncryption makes node exchange messages only over sessions encrypted with AES-GCM,
+// keys are agreed with ECDH of ephemeral P-256 keys signed by Ed25519 identity key.
+// Plaintext messages are dropped so all peers have to enable it, it should be called before Run.
+func (node *UdpNode) EnableEncryption(key ed25519.PrivateKey) {
+	node.secure = &sessions{
+		key:       key,
+		mutex:     &sync.Mutex{},
+		byId:      make(map[uint64]*session),
+		byAddr:    make(map[string]*session),
+		pending:   make(map[string]*handshake),
+		perAddr:   make(map[string]int),
+		responses: make(map[string][]byte),
+		tokens:    unknownSessionBurst,
+		refills:   time.Now(),
+	}
+}
+
+// Encrypted tells whether messages are exchanged over encrypted sessions.
+func (node *UdpNode) Encrypted() bool {
+	return node.secure != nil
+}
+
+// Handshake returns identity key of peer at addr, it agrees session with the peer if there is none.
+// Callers expecting particular peer should check the key before sending, nothing is sealed by it.
+func (node *UdpNode) Handshake(addr *net.UDPAddr) (ed25519.PublicKey, error) {
+	if node.secure == nil {
+		return nil, errors.New("encryption not enabled")
+	}
+	s, err := node.session(addr)
+	if err != nil {
+		return nil, err
+	}
+	return s.peerKey, nil
+}
+
+// PeerKey returns identity key of peer at addr, nil if there is no session with it.
+func (node *UdpNode) PeerKey(addr *net.UDPAddr) ed25519.PublicKey {
+	if node.secure == nil {
+		return nil
+	}
+	node.secure.mutex.Lock()
+	defer node.secure.mutex.Unlock()
+	if s, ok := node.secure.byAddr[addr.String()]; ok {
+		return s.peerKey
+	}
+	return nil
+}
+
+// datagramSize is the largest marshalled message sent in single datagram.
+func (node *UdpNode) datagramSize() int {
+	if node.secure != nil {
+		return int(node.MaxDatagramSize) - sealOverhead
+	}
+	return int(node.MaxDatagramSize)
+}
+
+func (s *session) stale(rekeyInterval time.Duration) bool {
+	return time.Since(s.created) > rekeyInterval || atomic.LoadUint64(&s.nonce) > rekeyMessages
+}
+
+func (s *session) seal(buf []byte) *Message {
+	nonce := atomic.AddUint64(&s.nonce, 1)
+	return &Message{
+		Type:    Message_SEALED,
+		Session: s.id,
+		Nonce:   nonce,
+		Payload: s.out.Seal(nil, nonceBytes(nonce), buf, sessionData(s.id)),
+	}
+}
+
+func (s *session) open(message *Message) ([]byte, error) {
+	buf, err := s.in.Open(nil, nonceBytes(message.Nonce), message.Payload, sessionData(s.id))
+	if err != nil {
+		return nil, err
+	}
+	if !s.accept(message.Nonce) {
+		return nil, errors.New("replayed message")
+	}
+	return buf, nil
+}
+
+// accept marks nonce as received, it returns false for repeated and too old nonces.
+func (s *session) accept(nonce uint64) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch {
+	case nonce == 0:
+		return false
+	case nonce > s.latest:
+		shift := nonce - s.latest
+		if shift >= replayWindow {
+			s.seen = 0
+		} else {
+			s.seen <<= shift
+		}
+		s.seen |= 1
+		s.latest = nonce
+		return true
+	case s.latest-nonce >= replayWindow:
+		return false
+	}
+	bit := uint64(1) << (s.latest - nonce)
+	if s.seen&bit != 0 {
+		return false
+	}
+	s.seen |= bit
+	return true
+}
+
+func nonceBytes(nonce uint64) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint64(b[4:], nonce)
+	return b
+}
+
+func sessionData(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return b
+}
+
+func ephemeralKey() (private, public []byte, err error) {
+	curve := elliptic.P256()
+	private, x, y, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return private, elliptic.Marshal(curve, x, y), nil
+}
+
+// newSession derives keys of session from ephemeral keys exchanged by initiator and responder.
+func newSession(
+	private []byte,
+	peerEphemeral []byte,
+	initEphemeral, respEphemeral []byte,
+	initKey, respKey ed25519.PublicKey,
+	initiator bool,
+	addr string,
+) (*session, error) {
+	curve := elliptic.P256()
+	x, y := elliptic.Unmarshal(curve, peerEphemeral)
+	if x == nil {
+		return nil, errors.New("invalid ephemeral key")
+	}
+	shared, _ := curve.ScalarMult(x, y, private)
+	secret := make([]byte, 32)
+	shared.FillBytes(secret)
+	salt := append(append([]byte{}, initEphemeral...), respEphemeral...)
+	info := append(append(append([]byte{}, sessionInfo...), initKey...), respKey...)
+	material := hkdf(secret, salt, info, 32+32+8)
+	initCipher, err := newAead(material[:32])
+	if err != nil {
+		return nil, err
+	}
+	respCipher, err := newAead(material[32:64])
+	if err != nil {
+		return nil, err
+	}
+	s := &session{
+		id:      binary.BigEndian.Uint64(material[64:]),
+		addr:    addr,
+		created: time.Now(),
+		mutex:   &sync.Mutex{},
+	}
+	if initiator {
+		s.peerKey, s.out, s.in = respKey, initCipher, respCipher
+	} else {
+		s.peerKey, s.out, s.in = initKey, respCipher, initCipher
+	}
+	return s, nil
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// hkdf implements RFC 5869 with SHA-256.
+func hkdf(secret, salt, info []byte, size int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+	var out, block []byte
+	for i := byte(1); len(out) < size; i++ {
+		expand := hmac.New(sha256.New, prk)
+		expand.Write(block)
+		expand.Write(info)
+		expand.Write([]byte{i})
+		block = expand.Sum(nil)
+		out = append(out, block...)
+	}
+	return out[:size]
+}
+
+func signedData(prefix []byte, ephemerals ...[]byte) []byte {
+	return bytes.Join(append([][]byte{prefix}, ephemerals...), nil)
+}
+
+// seal encrypts marshalled message with session of addr, it waits for handshake if there is none.
+func (node *UdpNode) seal(buf []byte, addr *net.UDPAddr) ([]byte, error) {
+	s, err := node.session(addr)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(s.seal(buf))
+}
+
+// session returns session with addr, stale session is used until rekeying completes.
+func (node *UdpNode) session(addr *net.UDPAddr) (*session, error) {
+	key := addr.String()
+	node.secure.mutex.Lock()
+	current := node.secure.byAddr[key]
+	if current != nil && !current.stale(node.RekeyInterval) {
+		node.secure.mutex.Unlock()
+		return current, nil
+	}
+	h, err := node.startHandshake(addr)
+	node.secure.mutex.Unlock()
+	if current != nil {
+		return current, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-h.done:
+		return h.session, h.err
+	case <-node.done:
+		return nil, ErrClosed
+	}
+}
+
+// startHandshake must be called with sessions mutex held, it returns handshake already in progress if any.
+func (node *UdpNode) startHandshake(addr *net.UDPAddr) (*handshake, error) {
+	key := addr.String()
+	if h, ok := node.secure.pending[key]; ok {
+		return h, nil
+	}
+	if len(node.secure.pending) >= maxPendingHandshakes {
+		return nil, errors.New("too many pending handshakes")
+	}
+	private, public, err := ephemeralKey()
+	if err != nil {
+		return nil, err
+	}
+	h := &handshake{private: private, public: public, done: make(chan struct{})}
+	node.secure.pending[key] = h
+	go node.initiate(h, addr)
+	return h, nil
+}
+
+// initiate sends handshake repeatedly until peer responds or call timeout passes.
+func (node *UdpNode) initiate(h *handshake, addr *net.UDPAddr) {
+	node.metrics.handshakes.Add(1)
+	buf, err := node.handshakeMessage(handshakeInit, h.public, false)
+	if err != nil {
+		node.finishHandshake(addr.String(), h, nil, err)
+		return
+	}
+	timeout := time.After(node.callTimeout)
+	retry := time.NewTicker(handshakeRetry)
+	defer retry.Stop()
+	for {
+		err = node.writeDatagram(buf, addr)
+		if err != nil {
+			node.Logger.Log(logging.Debug, "failed sending handshake", logging.F("addr", addr), logging.F("error", err))
+		}
+		select {
+		case <-h.done:
+			return
+		case <-retry.C:
+		case <-timeout:
+			node.finishHandshake(addr.String(), h, nil, errors.New("handshake timeout"))
+			return
+		case <-node.done:
+			node.finishHandshake(addr.String(), h, nil, ErrClosed)
+			return
+		}
+	}
+}
+
+// finishHandshake returns false if handshake was already finished.
+func (node *UdpNode) finishHandshake(key string, h *handshake, s *session, err error) bool {
+	node.secure.mutex.Lock()
+	defer node.secure.mutex.Unlock()
+	if node.secure.pending[key] != h {
+		return false
+	}
+	delete(node.secure.pending, key)
+	if s != nil {
+		node.addSession(s, true)
+	}
+	h.session, h.err = s, err
+	close(h.done)
+	return true
+}
+
+// addSession must be called with sessions mutex held. Confirmed session is used for sending to its addr
+// and dropped once its peer had enough time to rekey, unconfirmed one is dropped after call timeout.
+func (node *UdpNode) addSession(s *session, confirmed bool) {
+	node.secure.byId[s.id] = s
+	node.secure.perAddr[s.addr]++
+	lifetime := node.callTimeout
+	if confirmed {
+		s.confirmed = true
+		node.secure.byAddr[s.addr] = s
+		lifetime = 2 * node.RekeyInterval
+	}
+	s.expire = time.AfterFunc(lifetime, func() {
+		node.secure.mutex.Lock()
+		defer node.secure.mutex.Unlock()
+		node.removeSession(s)
+	})
+}
+
+// confirm must be called with sessions mutex held, it switches sending to addr to the newest confirmed session.
+func (node *UdpNode) confirm(s *session) {
+	if !s.confirmed {
+		s.confirmed = true
+		s.expire.Reset(2 * node.RekeyInterval)
+	}
+	if current := node.secure.byAddr[s.addr]; current == nil || current.created.Before(s.created) {
+		node.secure.byAddr[s.addr] = s
+	}
+}
+
+// removeSession must be called with sessions mutex held.
+func (node *UdpNode) removeSession(s *session) {
+	if node.secure.byId[s.id] != s {
+		return
+	}
+	delete(node.secure.byId, s.id)
+	if node.secure.byAddr[s.addr] == s {
+		delete(node.secure.byAddr, s.addr)
+	}
+	node.secure.perAddr[s.addr]--
+	if node.secure.perAddr[s.addr] <= 0 {
+		delete(node.secure.perAddr, s.addr)
+	}
+}
+
+// full must be called with sessions mutex held, it tells whether new session with addr would exceed limits.
+func (node *UdpNode) full(addr string) bool {
+	return len(node.secure.byId) >= maxSessions || node.secure.perAddr[addr] >= maxSessionsPerAddr
+}
+
+// allowUnknownSession must be called with sessions mutex held, it takes token of the bucket if there is any.
+func (node *UdpNode) allowUnknownSession(now time.Time) bool {
+	secure := node.secure
+	secure.tokens += now.Sub(secure.refills).Seconds() * unknownSessionRate
+	if secure.tokens > unknownSessionBurst {
+		secure.tokens = unknownSessionBurst
+	}
+	secure.refills = now
+	if secure.tokens < 1 {
+		return false
+	}
+	secure.tokens--
+	return true
+}
+
+func (node *UdpNode) handshakeMessage(prefix []byte, ephemeral []byte, response bool, signed ...[]byte) ([]byte, error) {
+	payload, err := proto.Marshal(&Handshake{
+		Ephemeral: ephemeral,
+		PublicKey: node.secure.key.Public().(ed25519.PublicKey),
+		Signature: ed25519.Sign(node.secure.key, signedData(prefix, append(signed, ephemeral)...)),
+		Response:  response,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&Message{Type: Message_HANDSHAKE, Payload: payload})
+}
+
+// open returns message to be dispatched, nil if message was consumed or rejected.
+func (node *UdpNode) open(message *Message, addr *net.UDPAddr) *Message {
+	switch message.Type {
+	case Message_HANDSHAKE:
+		err := node.handleHandshake(message, addr)
+		if err != nil {
+			node.metrics.sessionRejects.Add(1)
+			node.Logger.Log(logging.Debug, "rejected handshake", logging.F("addr", addr), logging.F("error", err))
+		}
+		return nil
+	case Message_SEALED:
+		inner, err := node.unseal(message, addr)
+		if err != nil {
+			node.metrics.sessionRejects.Add(1)
+			node.Logger.Log(logging.Debug, "rejected sealed message", logging.F("addr", addr),
+				logging.F("session", message.Session), logging.F("error", err))
+			return nil
+		}
+		return inner
+	default:
+		node.metrics.sessionRejects.Add(1)
+		node.Logger.Log(logging.Debug, "dropped plaintext message", logging.F("addr", addr), logging.F("type", message.Type))
+		return nil
+	}
+}
+
+func (node *UdpNode) unseal(message *Message, addr *net.UDPAddr) (*Message, error) {
+	key := addr.String()
+	node.secure.mutex.Lock()
+	s, ok := node.secure.byId[message.Session]
+	if !ok || s.addr != key {
+		// peer holds session this node doesn't, e.g. after restart, new handshake replaces it
+		// source of the message may be spoofed, handshakes it starts are rate limited
+		current := node.secure.byAddr[key]
+		if (current == nil || time.Since(current.created) > node.callTimeout) &&
+			node.secure.pending[key] == nil && node.allowUnknownSession(time.Now()) {
+			_, _ = node.startHandshake(addr)
+		}
+		node.secure.mutex.Unlock()
+		return nil, errors.New("unknown session")
+	}
+	node.secure.mutex.Unlock()
+	buf, err := s.open(message)
+	if err != nil {
+		return nil, err
+	}
+	node.secure.mutex.Lock()
+	node.confirm(s)
+	node.secure.mutex.Unlock()
+	inner := &Message{}
+	err = proto.Unmarshal(buf, inner)
+	if err != nil {
+		return nil, err
+	}
+	if inner.Type == Message_HANDSHAKE {
+		// initiator confirms session it started using
+		return nil, nil
+	}
+	if inner.Type == Message_SEALED {
+		return nil, errors.New("nested session message")
+	}
+	return inner, nil
+}
+
+func (node *UdpNode) handleHandshake(message *Message, addr *net.UDPAddr) error {
+	hs := &Handshake{}
+	err := proto.Unmarshal(message.Payload, hs)
+	if err != nil {
+		return err
+	}
+	if len(hs.PublicKey) != ed25519.PublicKeySize {
+		return errors.New("invalid public key")
+	}
+	if hs.Response {
+		return node.handleHandshakeResponse(hs, addr)
+	}
+	key := addr.String()
+	node.secure.mutex.Lock()
+	buf, repeated := node.secure.responses[string(hs.Ephemeral)]
+	full := node.full(key)
+	node.secure.mutex.Unlock()
+	if repeated {
+		return node.writeDatagram(buf, addr)
+	}
+	// limits are checked before signature and key agreement, so that rejecting is cheap
+	if full {
+		return errors.New("too many sessions")
+	}
+	if !ed25519.Verify(hs.PublicKey, signedData(handshakeInit, hs.Ephemeral), hs.Signature) {
+		return errors.New("invalid signature")
+	}
+	private, public, err := ephemeralKey()
+	if err != nil {
+		return err
+	}
+	ownKey := node.secure.key.Public().(ed25519.PublicKey)
+	s, err := newSession(private, hs.Ephemeral, hs.Ephemeral, public, hs.PublicKey, ownKey, false, key)
+	if err != nil {
+		return err
+	}
+	buf, err = node.handshakeMessage(handshakeResponse, public, true, hs.Ephemeral)
+	if err != nil {
+		return err
+	}
+	node.secure.mutex.Lock()
+	if node.full(key) {
+		node.secure.mutex.Unlock()
+		return errors.New("too many sessions")
+	}
+	// session is used for sending once initiator proves it holds it, spoofed handshakes don't take addr over
+	node.addSession(s, false)
+	node.secure.responses[string(hs.Ephemeral)] = buf
+	node.secure.mutex.Unlock()
+	time.AfterFunc(node.callTimeout, func() {
+		node.secure.mutex.Lock()
+		delete(node.secure.responses, string(hs.Ephemeral))
+		node.secure.mutex.Unlock()
+	})
+	return node.writeDatagram(buf, addr)
+}
+
+func (node *UdpNode) handleHandshakeResponse(hs *Handshake, addr *net.UDPAddr) error {
+	key := addr.String()
+	node.secure.mutex.Lock()
+	h, ok := node.secure.pending[key]
+	full := node.full(key)
+	node.secure.mutex.Unlock()
+	if !ok {
+		return errors.New("unexpected handshake response")
+	}
+	if full {
+		return errors.New("too many sessions")
+	}
+	if !ed25519.Verify(hs.PublicKey, signedData(handshakeResponse, h.public, hs.Ephemeral), hs.Signature) {
+		return errors.New("invalid signature")
+	}
+	ownKey := node.secure.key.Public().(ed25519.PublicKey)
+	s, err := newSession(h.private, hs.Ephemeral, h.public, hs.Ephemeral, ownKey, hs.PublicKey, true, key)
+	if err != nil {
+		return err
+	}
+	if !node.finishHandshake(key, h, s, nil) {
+		return nil
+	}
+	buf, err := proto.Marshal(&Message{Type: Message_HANDSHAKE})
+	if err != nil {
+		return err
+	}
+	confirmation, err := proto.Marshal(s.seal(buf))
+	if err != nil {
+		return err
+	}
+	return node.writeDatagram(confirmation, addr)
+}
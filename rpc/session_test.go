@@ -0,0 +1,178 @@
+package rpc
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/golang/protobuf/proto"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startEncryptedNode(t *testing.T, address string, services []Service) (*UdpNode, ed25519.PublicKey) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed generating key: %v\n", err)
+	}
+	node, err := NewUdpNode(address, services, callTimeout, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	node.EnableEncryption(privateKey)
+	go node.Run()
+	return node, publicKey
+}
+
+func sessionId(node *UdpNode, other *UdpNode) uint64 {
+	node.secure.mutex.Lock()
+	defer node.secure.mutex.Unlock()
+	if s, ok := node.secure.byAddr[other.Addr.String()]; ok {
+		return s.id
+	}
+	return 0
+}
+
+func TestEncryptedCall(t *testing.T) {
+	node1, key1 := startEncryptedNode(t, "localhost:", []Service{slow(0)})
+	defer node1.Close()
+	node2, key2 := startEncryptedNode(t, "localhost:", nil)
+	defer node2.Close()
+
+	for _, payload := range [][]byte{[]byte("sealed"), bytes.Repeat([]byte("fragment"), 10*int(bufferSize))} {
+		response, err := node2.Call(node1.Addr, ServiceId(0), payload)
+		if err != nil {
+			t.Fatalf("failed calling rpc service: %v\n", err)
+		}
+		if !bytes.Equal(response, payload) {
+			t.Errorf("rpc service returned invalid response of %d bytes\n", len(response))
+		}
+	}
+	if !key1.Equal(node2.PeerKey(node1.Addr)) || !key2.Equal(node1.PeerKey(node2.Addr)) {
+		t.Errorf("session should be bound to identity keys of peers\n")
+	}
+	if sessionId(node1, node2) != sessionId(node2, node1) {
+		t.Errorf("peers should agree on session\n")
+	}
+
+	plain, err := NewUdpNode("localhost:", nil, 100*time.Millisecond, bufferSize)
+	if err != nil {
+		t.Fatalf("failed creating rpc node: %v\n", err)
+	}
+	go plain.Run()
+	defer plain.Close()
+	_, err = plain.Call(node1.Addr, ServiceId(0), []byte("plaintext"))
+	if err == nil {
+		t.Errorf("plaintext call should be dropped\n")
+	}
+}
+
+func TestRekey(t *testing.T) {
+	node1, _ := startEncryptedNode(t, "localhost:", []Service{slow(0)})
+	defer node1.Close()
+	node2, _ := startEncryptedNode(t, "localhost:", nil)
+	node2.RekeyInterval = 100 * time.Millisecond
+	defer node2.Close()
+
+	call := func() {
+		_, err := node2.Call(node1.Addr, ServiceId(0), []byte("rekey"))
+		if err != nil {
+			t.Fatalf("failed calling rpc service: %v\n", err)
+		}
+	}
+	call()
+	first := sessionId(node2, node1)
+	time.Sleep(150 * time.Millisecond)
+	// stale session is used while new one is negotiated
+	call()
+	time.Sleep(50 * time.Millisecond)
+	call()
+	second := sessionId(node2, node1)
+	if first == second {
+		t.Errorf("session should be rekeyed\n")
+	}
+	if sessionId(node1, node2) != second {
+		t.Errorf("peer should switch to new session\n")
+	}
+}
+
+func TestSessionAfterRestart(t *testing.T) {
+	node1, _ := startEncryptedNode(t, "localhost:", []Service{slow(0)})
+	node2, _ := startEncryptedNode(t, "localhost:", nil)
+	defer node2.Close()
+	_, err := node2.Call(node1.Addr, ServiceId(0), []byte("before"))
+	if err != nil {
+		t.Fatalf("failed calling rpc service: %v\n", err)
+	}
+	node1.Close()
+
+	restarted, _ := startEncryptedNode(t, node1.Addr.String(), []Service{slow(0)})
+	defer restarted.Close()
+	// restarted node doesn't know the session, it negotiates a new one
+	_, _ = node2.Call(restarted.Addr, ServiceId(0), []byte("unknown session"))
+	_, err = node2.Call(restarted.Addr, ServiceId(0), []byte("after"))
+	if err != nil {
+		t.Errorf("failed calling restarted node: %v\n", err)
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	s := &session{mutex: &sync.Mutex{}}
+	for _, c := range []struct {
+		nonce    uint64
+		accepted bool
+	}{
+		{0, false}, {1, true}, {1, false}, {3, true}, {2, true}, {2, false},
+		{100, true}, {36, false}, {37, true}, {37, false}, {200, true}, {99, false},
+	} {
+		if s.accept(c.nonce) != c.accepted {
+			t.Errorf("nonce %d accepted should be %v\n", c.nonce, c.accepted)
+		}
+	}
+}
+
+func TestHandshakeLimits(t *testing.T) {
+	node, _ := startEncryptedNode(t, "localhost:", nil)
+	defer node.Close()
+	p := newPeer(t)
+	defer p.conn.Close()
+
+	// each handshake is signed with throwaway key
+	for i := 0; i < 2*maxSessionsPerAddr; i++ {
+		publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+		_, ephemeral, _ := ephemeralKey()
+		payload, err := proto.Marshal(&Handshake{
+			Ephemeral: ephemeral,
+			PublicKey: publicKey,
+			Signature: ed25519.Sign(privateKey, signedData(handshakeInit, ephemeral)),
+		})
+		if err != nil {
+			t.Fatalf("failed encoding handshake: %v\n", err)
+		}
+		p.send(&Message{Type: Message_HANDSHAKE, Payload: payload}, node.Addr)
+	}
+	time.Sleep(100 * time.Millisecond)
+	node.secure.mutex.Lock()
+	sessions, used := len(node.secure.byId), len(node.secure.byAddr)
+	node.secure.mutex.Unlock()
+	if sessions != maxSessionsPerAddr {
+		t.Errorf("sessions with single addr should be limited, got: %d\n", sessions)
+	}
+	if used != 0 {
+		t.Errorf("unconfirmed sessions should not be used for sending\n")
+	}
+
+	now := time.Now()
+	node.secure.mutex.Lock()
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		if node.allowUnknownSession(now) {
+			allowed++
+		}
+	}
+	refilled := node.allowUnknownSession(now.Add(time.Second))
+	node.secure.mutex.Unlock()
+	if allowed != unknownSessionBurst || !refilled {
+		t.Errorf("handshakes of unknown sessions should be rate limited, allowed: %d\n", allowed)
+	}
+}
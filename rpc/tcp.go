@@ -0,0 +1,555 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/mduszyk/gopeers/logging"
+	"github.com/mduszyk/gopeers/metrics"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultMaxFrameSize = 16 << 20
+
+const defaultMaxIncoming = 256
+
+const defaultMaxConnRequests = 32
+
+var errConnClosed = errors.New("tcp connection closed")
+
+// TcpNode serves the same services as UdpNode over tcp, it is meant for payloads which
+// don't fit into a datagram. Messages are framed with 4 byte big endian length.
+// Connections are reused for subsequent calls and closed when idle.
+//
+// Services get udp address of the caller built from its ip and port it claims to listen on,
+// so a node running UdpNode and TcpNode on the same port is reachable over both.
+// The port isn't verified, services have to check it before trusting the address.
+type TcpNode struct {
+	Addr     *net.TCPAddr
+	Services []Service
+	// larger frames are rejected by closing the connection
+	MaxFrameSize uint32
+	// more accepted connections are closed right away
+	MaxIncoming int
+	// requests of a connection handled concurrently, the connection isn't read while at the limit
+	MaxConnRequests int
+	IdleTimeout     time.Duration
+	listener        *net.TCPListener
+	callTimeout     time.Duration
+	// outgoing connections by address
+	outgoing map[string]*tcpConn
+	// connections being dialed by address, concurrent calls wait for them
+	dialing map[string]*dial
+	// all open connections, incoming and outgoing
+	conns      map[*tcpConn]bool
+	incoming   int
+	connsMutex sync.Mutex
+	lastCallId uint64
+	done       chan struct{}
+	closed     bool
+	closeMutex sync.Mutex
+	handlers   sync.WaitGroup
+	readers    sync.WaitGroup
+	metrics    *rpcMetrics
+	Logger     logging.Logger
+}
+
+type dial struct {
+	done chan struct{}
+	c    *tcpConn
+	err  error
+}
+
+type tcpConn struct {
+	conn       *net.TCPConn
+	reader     *bufio.Reader
+	writeMutex sync.Mutex
+	pending    map[CallId]chan *Message
+	// slots of requests being handled
+	handling chan struct{}
+	// set when connection is closed, pending calls fail afterwards
+	closed       bool
+	pendingMutex sync.Mutex
+	done         chan struct{}
+	// used for dialed connections, dropped from outgoing when closed
+	key string
+}
+
+func NewTcpNode(address string, services []Service, callTimeout time.Duration) (*TcpNode, error) {
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.ListenTCP("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	addr, err = net.ResolveTCPAddr("tcp", listener.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	node := &TcpNode{
+		Addr:            addr,
+		Services:        services,
+		MaxFrameSize:    DefaultMaxFrameSize,
+		MaxIncoming:     defaultMaxIncoming,
+		MaxConnRequests: defaultMaxConnRequests,
+		IdleTimeout:     time.Minute,
+		listener:        listener,
+		callTimeout:     callTimeout,
+		outgoing:        make(map[string]*tcpConn),
+		dialing:         make(map[string]*dial),
+		conns:           make(map[*tcpConn]bool),
+		done:            make(chan struct{}),
+		metrics:         newTcpMetrics(metrics.Nop),
+		Logger:          logging.Nop,
+	}
+	return node, nil
+}
+
+// Run accepts connections until node is closed.
+func (node *TcpNode) Run() {
+	node.Logger.Log(logging.Info, "tcp node listening", logging.F("addr", node.Addr))
+	for {
+		conn, err := node.listener.AcceptTCP()
+		if err != nil {
+			if node.isClosed() {
+				return
+			}
+			node.Logger.Log(logging.Warn, "failed accepting tcp connection", logging.F("error", err))
+			continue
+		}
+		node.connsMutex.Lock()
+		full := node.incoming >= node.MaxIncoming
+		node.connsMutex.Unlock()
+		if full {
+			node.Logger.Log(logging.Debug, "too many tcp connections", logging.F("addr", conn.RemoteAddr()))
+			conn.Close()
+			continue
+		}
+		c := node.register(conn, "")
+		if c == nil {
+			return
+		}
+	}
+}
+
+// register starts reading connection, it returns nil when node is already closed.
+func (node *TcpNode) register(conn *net.TCPConn, key string) *tcpConn {
+	c := &tcpConn{
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		pending:  make(map[CallId]chan *Message),
+		handling: make(chan struct{}, node.MaxConnRequests),
+		done:     make(chan struct{}),
+		key:      key,
+	}
+	node.closeMutex.Lock()
+	defer node.closeMutex.Unlock()
+	if node.closed {
+		conn.Close()
+		return nil
+	}
+	node.connsMutex.Lock()
+	node.conns[c] = true
+	if key != "" {
+		node.outgoing[key] = c
+	} else {
+		node.incoming++
+	}
+	node.connsMutex.Unlock()
+	node.readers.Add(1)
+	go node.read(c)
+	return c
+}
+
+func (node *TcpNode) read(c *tcpConn) {
+	defer node.readers.Done()
+	for {
+		if node.IdleTimeout > 0 {
+			_ = c.conn.SetReadDeadline(time.Now().Add(node.IdleTimeout))
+		}
+		message, err := node.readMessage(c)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && !node.isClosed() && c.waiting() {
+				// calls are still waiting for responses
+				continue
+			}
+			if node.isClosed() {
+				// closed by Shutdown or Close once in-flight requests are handled
+				return
+			}
+			if err != io.EOF {
+				node.Logger.Log(logging.Debug, "closing tcp connection",
+					logging.F("addr", c.conn.RemoteAddr()), logging.F("error", err))
+			}
+			node.closeConn(c)
+			return
+		}
+		switch message.Type {
+		case Message_REQUEST:
+			select {
+			case c.handling <- struct{}{}:
+			case <-node.done:
+				return
+			}
+			if !node.startHandler() {
+				return
+			}
+			go node.handleRequest(c, message)
+		case Message_RESPONSE:
+			c.deliver(message)
+		default:
+			node.Logger.Log(logging.Debug, "received unsupported message type",
+				logging.F("addr", c.conn.RemoteAddr()), logging.F("type", message.Type))
+		}
+	}
+}
+
+func (node *TcpNode) readMessage(c *tcpConn) (*Message, error) {
+	var header [4]byte
+	_, err := io.ReadFull(c.reader, header[:])
+	if err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > node.MaxFrameSize {
+		return nil, fmt.Errorf("frame of %d bytes exceeds limit", size)
+	}
+	// buffer grows as data arrives, header alone doesn't make node allocate the whole frame
+	var buf bytes.Buffer
+	_, err = io.CopyN(&buf, c.reader, int64(size))
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	node.metrics.bytesReceived.Add(float64(len(header) + buf.Len()))
+	message := &Message{}
+	err = proto.Unmarshal(buf.Bytes(), message)
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func (node *TcpNode) write(c *tcpConn, message *Message) error {
+	buf, err := proto.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if uint32(len(buf)) > node.MaxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds limit", len(buf))
+	}
+	frame := make([]byte, 4+len(buf))
+	binary.BigEndian.PutUint32(frame, uint32(len(buf)))
+	copy(frame[4:], buf)
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if node.callTimeout > 0 {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(node.callTimeout))
+	}
+	n, err := c.conn.Write(frame)
+	node.metrics.bytesSent.Add(float64(n))
+	return err
+}
+
+func (node *TcpNode) handleRequest(c *tcpConn, request *Message) {
+	defer node.handlers.Done()
+	defer func() { <-c.handling }()
+	response := &Message{
+		Type:   Message_RESPONSE,
+		CallId: request.CallId,
+	}
+	var result Payload
+	var err error
+	if int(request.ServiceId) < len(node.Services) {
+		result, err = node.Services[request.ServiceId](node.callerAddr(c, request), request.Payload)
+	} else {
+		err = fmt.Errorf("unknown service: %d", request.ServiceId)
+	}
+	node.metrics.requests.Add(1)
+	if err != nil {
+		node.metrics.requestErrors.Add(1)
+		response.Error = Error(err.Error())
+	} else {
+		response.Payload = result
+	}
+	err = node.write(c, response)
+	if err != nil {
+		node.Logger.Log(logging.Warn, "failed sending response", logging.F("addr", c.conn.RemoteAddr()),
+			logging.F("call", request.CallId), logging.F("service", request.ServiceId), logging.F("error", err))
+	}
+}
+
+// callerAddr returns address the caller claims to listen on.
+func (node *TcpNode) callerAddr(c *tcpConn, request *Message) *net.UDPAddr {
+	remote := c.conn.RemoteAddr().(*net.TCPAddr)
+	port := remote.Port
+	if request.Port != 0 {
+		port = int(request.Port)
+	}
+	return &net.UDPAddr{IP: remote.IP, Port: port, Zone: remote.Zone}
+}
+
+func (c *tcpConn) deliver(response *Message) {
+	c.pendingMutex.Lock()
+	ch, ok := c.pending[response.CallId]
+	c.pendingMutex.Unlock()
+	if ok {
+		// duplicated response must not block the reader
+		select {
+		case ch <- response:
+		default:
+		}
+	}
+}
+
+func (c *tcpConn) waiting() bool {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	return len(c.pending) > 0
+}
+
+func (c *tcpConn) addPending(id CallId, ch chan *Message) bool {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	if c.closed {
+		return false
+	}
+	c.pending[id] = ch
+	return true
+}
+
+func (c *tcpConn) removePending(id CallId) {
+	c.pendingMutex.Lock()
+	delete(c.pending, id)
+	c.pendingMutex.Unlock()
+}
+
+func (node *TcpNode) closeConn(c *tcpConn) {
+	c.pendingMutex.Lock()
+	if c.closed {
+		c.pendingMutex.Unlock()
+		return
+	}
+	c.closed = true
+	close(c.done)
+	c.pendingMutex.Unlock()
+
+	node.connsMutex.Lock()
+	delete(node.conns, c)
+	if c.key == "" {
+		node.incoming--
+	} else if node.outgoing[c.key] == c {
+		delete(node.outgoing, c.key)
+	}
+	node.connsMutex.Unlock()
+	c.conn.Close()
+}
+
+// connect returns open connection to addr, dialing it if needed.
+// Concurrent calls to the same addr wait for single dial.
+func (node *TcpNode) connect(ctx context.Context, addr *net.TCPAddr) (*tcpConn, error) {
+	key := addr.String()
+	node.connsMutex.Lock()
+	if c, ok := node.outgoing[key]; ok {
+		node.connsMutex.Unlock()
+		return c, nil
+	}
+	d, ok := node.dialing[key]
+	if ok {
+		node.connsMutex.Unlock()
+		select {
+		case <-d.done:
+			return d.c, d.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	d = &dial{done: make(chan struct{})}
+	node.dialing[key] = d
+	node.connsMutex.Unlock()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", key)
+	if err == nil {
+		d.c = node.register(conn.(*net.TCPConn), key)
+		if d.c == nil {
+			err = ErrClosed
+		}
+	}
+	d.err = err
+	node.connsMutex.Lock()
+	delete(node.dialing, key)
+	node.connsMutex.Unlock()
+	close(d.done)
+	return d.c, d.err
+}
+
+func (node *TcpNode) nextCallId() CallId {
+	return atomic.AddUint64(&node.lastCallId, 1)
+}
+
+func (node *TcpNode) Call(addr *net.TCPAddr, serviceId ServiceId, payload Payload) (Payload, error) {
+	return node.CallContext(context.Background(), addr, serviceId, payload)
+}
+
+// CallContext returns when response arrives, call times out or ctx is done.
+// Call timeout includes establishing connection.
+func (node *TcpNode) CallContext(
+	ctx context.Context,
+	addr *net.TCPAddr,
+	serviceId ServiceId,
+	payload Payload,
+) (Payload, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if node.isClosed() {
+		return nil, ErrClosed
+	}
+	started := time.Now()
+	callCtx, cancel := context.WithTimeout(ctx, node.callTimeout)
+	defer cancel()
+	node.metrics.calls.Add(1)
+	node.metrics.pending.Add(1)
+	defer node.metrics.pending.Add(-1)
+
+	fail := func(err error) (Payload, error) {
+		node.metrics.callErrors.Add(1)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if callCtx.Err() != nil {
+			node.metrics.callTimeouts.Add(1)
+			return nil, errors.New("call timeout")
+		}
+		return nil, err
+	}
+
+	c, err := node.connect(callCtx, addr)
+	if err != nil {
+		return fail(err)
+	}
+	request := &Message{
+		Type:      Message_REQUEST,
+		ServiceId: serviceId,
+		CallId:    node.nextCallId(),
+		Payload:   payload,
+		Port:      uint32(node.Addr.Port),
+	}
+	response := make(chan *Message, 1)
+	if !c.addPending(request.CallId, response) {
+		return fail(errConnClosed)
+	}
+	defer c.removePending(request.CallId)
+	err = node.write(c, request)
+	if err != nil {
+		node.closeConn(c)
+		return fail(err)
+	}
+	select {
+	case response := <-response:
+		if response.Error != nil {
+			node.metrics.callErrors.Add(1)
+			return nil, errors.New(string(response.Error))
+		}
+		node.metrics.callDuration.Observe(time.Since(started).Seconds())
+		return response.Payload, nil
+	case <-c.done:
+		return fail(errConnClosed)
+	case <-node.done:
+		node.metrics.callErrors.Add(1)
+		return nil, ErrClosed
+	case <-callCtx.Done():
+		return fail(callCtx.Err())
+	}
+}
+
+func (node *TcpNode) isClosed() bool {
+	node.closeMutex.Lock()
+	defer node.closeMutex.Unlock()
+	return node.closed
+}
+
+func (node *TcpNode) startHandler() bool {
+	node.closeMutex.Lock()
+	defer node.closeMutex.Unlock()
+	if node.closed {
+		return false
+	}
+	node.handlers.Add(1)
+	return true
+}
+
+// stop terminates accepting and reading, returns false if already stopped.
+func (node *TcpNode) stop() bool {
+	node.closeMutex.Lock()
+	defer node.closeMutex.Unlock()
+	if node.closed {
+		return false
+	}
+	node.closed = true
+	close(node.done)
+	node.listener.Close()
+	// unblock readers, connections stay open for responses of in-flight requests
+	node.connsMutex.Lock()
+	for c := range node.conns {
+		_ = c.conn.SetReadDeadline(time.Now())
+	}
+	node.connsMutex.Unlock()
+	return true
+}
+
+func (node *TcpNode) closeAll() {
+	node.connsMutex.Lock()
+	conns := make([]*tcpConn, 0, len(node.conns))
+	for c := range node.conns {
+		conns = append(conns, c)
+	}
+	node.connsMutex.Unlock()
+	for _, c := range conns {
+		node.closeConn(c)
+	}
+	node.readers.Wait()
+}
+
+// Shutdown stops accepting connections and fails pending calls with ErrClosed,
+// then waits for in-flight requests to be handled until ctx is done.
+func (node *TcpNode) Shutdown(ctx context.Context) error {
+	if !node.stop() {
+		return ErrClosed
+	}
+	drained := make(chan struct{})
+	go func() {
+		node.handlers.Wait()
+		close(drained)
+	}()
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	node.closeAll()
+	return err
+}
+
+// Close stops node without waiting for in-flight requests.
+func (node *TcpNode) Close() error {
+	if !node.stop() {
+		return ErrClosed
+	}
+	node.closeAll()
+	return nil
+}
@@ -0,0 +1,290 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTcpPair(t *testing.T, services []Service) (*TcpNode, *TcpNode) {
+	node1, err := NewTcpNode("localhost:", services, callTimeout)
+	if err != nil {
+		t.Fatalf("failed creating tcp node: %v\n", err)
+	}
+	go node1.Run()
+	node2, err := NewTcpNode("localhost:", nil, callTimeout)
+	if err != nil {
+		t.Fatalf("failed creating tcp node: %v\n", err)
+	}
+	go node2.Run()
+	return node1, node2
+}
+
+func TestTcpCall(t *testing.T) {
+	var caller *net.UDPAddr
+	node1, node2 := newTcpPair(t, []Service{
+		func(addr *net.UDPAddr, payload Payload) (Payload, error) {
+			caller = addr
+			return payload, nil
+		},
+		failure,
+	})
+	defer node1.Close()
+	defer node2.Close()
+
+	response, err := node2.Call(node1.Addr, ServiceId(0), []byte("test1"))
+	if err != nil {
+		t.Fatalf("failed calling rpc service: %v\n", err)
+	}
+	if !bytes.Equal(response, []byte("test1")) {
+		t.Errorf("rpc service returned invalid response: %s\n", response)
+	}
+	if caller == nil || caller.Port != node2.Addr.Port {
+		t.Errorf("service should get listening address of caller, got: %v\n", caller)
+	}
+
+	// connection is reused
+	_, err = node2.Call(node1.Addr, ServiceId(0), []byte("test2"))
+	if err != nil {
+		t.Errorf("failed calling rpc service: %v\n", err)
+	}
+	if len(node2.outgoing) != 1 {
+		t.Errorf("expected one outgoing connection, got: %d\n", len(node2.outgoing))
+	}
+
+	_, err = node2.Call(node1.Addr, ServiceId(1), []byte("test3"))
+	if err == nil || err.Error() != "rpc service failure" {
+		t.Errorf("rpc service error should be returned, got: %v\n", err)
+	}
+	_, err = node2.Call(node1.Addr, ServiceId(7), []byte("test4"))
+	if err == nil {
+		t.Errorf("call to unknown service should fail\n")
+	}
+}
+
+func TestTcpLargePayload(t *testing.T) {
+	node1, node2 := newTcpPair(t, []Service{slow(0)})
+	defer node1.Close()
+	defer node2.Close()
+
+	payload := bytes.Repeat([]byte("large"), 200000)
+	response, err := node2.Call(node1.Addr, ServiceId(0), payload)
+	if err != nil {
+		t.Fatalf("failed calling rpc service: %v\n", err)
+	}
+	if !bytes.Equal(response, payload) {
+		t.Errorf("rpc service returned invalid response of %d bytes\n", len(response))
+	}
+
+	node1.MaxFrameSize = 1024
+	_, err = node2.Call(node1.Addr, ServiceId(0), payload)
+	if err == nil {
+		t.Errorf("call with frame over limit should fail\n")
+	}
+	_, err = node2.Call(node1.Addr, ServiceId(0), []byte("small"))
+	if err != nil {
+		t.Errorf("call after rejected frame should reconnect: %v\n", err)
+	}
+}
+
+func TestTcpOversizedHeader(t *testing.T) {
+	node1, err := NewTcpNode("localhost:", []Service{slow(0)}, callTimeout)
+	if err != nil {
+		t.Fatalf("failed creating tcp node: %v\n", err)
+	}
+	go node1.Run()
+	defer node1.Close()
+
+	conn, err := net.Dial("tcp", node1.Addr.String())
+	if err != nil {
+		t.Fatalf("failed connecting: %v\n", err)
+	}
+	defer conn.Close()
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], DefaultMaxFrameSize+1)
+	_, err = conn.Write(header[:])
+	if err != nil {
+		t.Fatalf("failed writing: %v\n", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(header[:])
+	if err == nil {
+		t.Errorf("connection should be closed\n")
+	}
+}
+
+func TestTcpShutdown(t *testing.T) {
+	node1, node2 := newTcpPair(t, []Service{slow(100 * time.Millisecond)})
+	defer node2.Close()
+
+	responses := make(chan error, 1)
+	go func() {
+		_, err := node2.Call(node1.Addr, ServiceId(0), []byte("slow"))
+		responses <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := node1.Shutdown(ctx)
+	if err != nil {
+		t.Errorf("failed shutting down: %v\n", err)
+	}
+	if err := <-responses; err != nil {
+		t.Errorf("in-flight call should be handled: %v\n", err)
+	}
+	_, err = node2.Call(node1.Addr, ServiceId(0), []byte("closed"))
+	if err == nil {
+		t.Errorf("call to closed node should fail\n")
+	}
+	if node1.Close() != ErrClosed {
+		t.Errorf("closing twice should fail\n")
+	}
+}
+
+func TestTcpClosePendingCall(t *testing.T) {
+	node1, node2 := newTcpPair(t, []Service{slow(time.Second)})
+	defer node1.Close()
+
+	responses := make(chan error, 1)
+	go func() {
+		_, err := node2.Call(node1.Addr, ServiceId(0), []byte("pending"))
+		responses <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	err := node2.Close()
+	if err != nil {
+		t.Errorf("failed closing: %v\n", err)
+	}
+	select {
+	case err := <-responses:
+		if err != ErrClosed {
+			t.Errorf("pending call should fail with ErrClosed, got: %v\n", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Errorf("pending call should fail immediately\n")
+	}
+	_, err = node2.Call(node1.Addr, ServiceId(0), []byte("closed"))
+	if err != ErrClosed {
+		t.Errorf("call on closed node should fail with ErrClosed, got: %v\n", err)
+	}
+}
+
+func TestTcpConcurrentConnect(t *testing.T) {
+	node1, node2 := newTcpPair(t, []Service{slow(0)})
+	defer node1.Close()
+	defer node2.Close()
+
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := node2.Call(node1.Addr, ServiceId(0), []byte("concurrent"))
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("failed calling rpc service: %v\n", err)
+		}
+	}
+	node2.connsMutex.Lock()
+	outgoing := len(node2.conns)
+	node2.connsMutex.Unlock()
+	node1.connsMutex.Lock()
+	incoming := node1.incoming
+	node1.connsMutex.Unlock()
+	if outgoing != 1 || incoming != 1 {
+		t.Errorf("single connection should be dialed, got: %d outgoing, %d incoming\n", outgoing, incoming)
+	}
+}
+
+func TestTcpMaxIncoming(t *testing.T) {
+	node1, err := NewTcpNode("localhost:", []Service{slow(0)}, callTimeout)
+	if err != nil {
+		t.Fatalf("failed creating tcp node: %v\n", err)
+	}
+	node1.MaxIncoming = 2
+	go node1.Run()
+	defer node1.Close()
+
+	conns := make([]net.Conn, 3)
+	for i := range conns {
+		conns[i], err = net.Dial("tcp", node1.Addr.String())
+		if err != nil {
+			t.Fatalf("failed connecting: %v\n", err)
+		}
+		defer conns[i].Close()
+		// header of large frame which never comes
+		var header [4]byte
+		binary.BigEndian.PutUint32(header[:], DefaultMaxFrameSize)
+		_, _ = conns[i].Write(header[:])
+	}
+	var buf [1]byte
+	_ = conns[2].SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conns[2].Read(buf[:])
+	if ne, ok := err.(net.Error); err == nil || ok && ne.Timeout() {
+		t.Errorf("connection over limit should be closed, got: %v\n", err)
+	}
+	node1.connsMutex.Lock()
+	incoming := node1.incoming
+	node1.connsMutex.Unlock()
+	if incoming != 2 {
+		t.Errorf("expected 2 incoming connections, got: %d\n", incoming)
+	}
+}
+
+func TestTcpMaxConnRequests(t *testing.T) {
+	release := make(chan struct{})
+	var mutex sync.Mutex
+	running, maxRunning := 0, 0
+	node1, err := NewTcpNode("localhost:", []Service{
+		func(addr *net.UDPAddr, payload Payload) (Payload, error) {
+			mutex.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mutex.Unlock()
+			<-release
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+			return payload, nil
+		},
+	}, callTimeout)
+	if err != nil {
+		t.Fatalf("failed creating tcp node: %v\n", err)
+	}
+	node1.MaxConnRequests = 2
+	go node1.Run()
+	defer node1.Close()
+	node2, err := NewTcpNode("localhost:", nil, callTimeout)
+	if err != nil {
+		t.Fatalf("failed creating tcp node: %v\n", err)
+	}
+	go node2.Run()
+	defer node2.Close()
+
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			_, err := node2.Call(node1.Addr, ServiceId(0), []byte("test"))
+			errs <- err
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	for i := 0; i < 5; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("failed calling rpc service: %v\n", err)
+		}
+	}
+	if maxRunning != 2 {
+		t.Errorf("expected 2 requests handled concurrently, got: %d\n", maxRunning)
+	}
+}
@@ -0,0 +1,29 @@
+package sim
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is virtual time which moves only when advanced.
+type Clock struct {
+	now   time.Time
+	mutex sync.Mutex
+}
+
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *Clock) Advance(d time.Duration) time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
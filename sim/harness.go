@@ -0,0 +1,327 @@
+package sim
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"github.com/mduszyk/gopeers/dht"
+	"math/big"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Churn is a step of schedule applied after values are stored.
+type Churn struct {
+	// virtual time passing before the step, maintenance runs once it passed
+	After time.Duration
+	// number of online nodes going offline
+	Leave int
+	// number of new nodes joining the network
+	Join int
+}
+
+type Scenario struct {
+	Config Config
+	Nodes  int
+	// parameters of NewKadNode
+	K, B, Alpha int
+	// number of values stored after bootstrap and their ttl
+	Keys  int
+	TTL   time.Duration
+	Churn []Churn
+	// value lookups of stored keys and node lookups of random ids run after churn
+	Gets    int
+	Lookups int
+}
+
+type Report struct {
+	Nodes             int
+	Online            int
+	JoinFailures      int
+	Sets, SetFailures int
+	Gets, GetFailures int
+	Lookups           int
+	// fraction of k closest online nodes returned by node lookups
+	LookupAccuracy float64
+	// hops of successful value and node lookups
+	HopsMean float64
+	HopsMax  int
+	// messages sent per Set, Get and Lookup
+	SetMessages, GetMessages, LookupMessages float64
+	// fraction of k closest online nodes present in routing tables of online nodes
+	TableCompleteness float64
+	// fraction of routing table entries pointing to offline nodes
+	TableStale float64
+	Stats      Stats
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "nodes: %d online: %d join failures: %d\n", r.Nodes, r.Online, r.JoinFailures)
+	fmt.Fprintf(&b, "sets: %d failed: %d messages/set: %.1f\n", r.Sets, r.SetFailures, r.SetMessages)
+	fmt.Fprintf(&b, "gets: %d failed: %d success: %.3f messages/get: %.1f\n",
+		r.Gets, r.GetFailures, ratio(r.Gets-r.GetFailures, r.Gets), r.GetMessages)
+	fmt.Fprintf(&b, "lookups: %d accuracy: %.3f messages/lookup: %.1f\n", r.Lookups, r.LookupAccuracy, r.LookupMessages)
+	fmt.Fprintf(&b, "hops mean: %.2f max: %d\n", r.HopsMean, r.HopsMax)
+	fmt.Fprintf(&b, "table completeness: %.3f stale: %.3f\n", r.TableCompleteness, r.TableStale)
+	fmt.Fprintf(&b, "calls: %d messages: %d dropped: %d timeouts: %d\n",
+		r.Stats.Calls, r.Stats.Messages, r.Stats.Dropped, r.Stats.Timeouts)
+	return b.String()
+}
+
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+// Harness runs scenarios, it keeps every node created so far including offline ones.
+type Harness struct {
+	Network *Network
+	Nodes   []*dht.KadNode
+	// joins which returned error, such nodes stay online with peers learned so far
+	JoinFailures int
+	k, b, alpha  int
+	rnd          *rand.Rand
+}
+
+func NewHarness(config Config, k, b, alpha int) *Harness {
+	return &Harness{
+		Network: NewNetwork(config),
+		k:       k, b: b, alpha: alpha,
+		rnd: rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+// Bootstrap joins n new nodes through the first one and refreshes all of them afterwards,
+// so that early nodes learn about late ones.
+func (h *Harness) Bootstrap(ctx context.Context, n int) error {
+	start := len(h.Nodes)
+	for i := 0; i < n; i++ {
+		node := h.Network.NewNode(h.k, h.b, h.alpha)
+		h.Nodes = append(h.Nodes, node)
+		if len(h.Nodes) > 1 {
+			h.join(node, h.Nodes[0])
+		}
+	}
+	for _, node := range h.Nodes[start:] {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// failed refresh of some buckets is expected on lossy network
+		_ = node.Refresh()
+	}
+	return nil
+}
+
+func (h *Harness) join(node, bootstrap *dht.KadNode) {
+	if err := node.Join(h.Network.Peer(bootstrap.Peer.Id)); err != nil {
+		h.JoinFailures++
+	}
+}
+
+// Online returns online nodes ordered as created.
+func (h *Harness) Online() []*dht.KadNode {
+	online := make([]*dht.KadNode, 0, len(h.Nodes))
+	for _, node := range h.Nodes {
+		if h.Network.Online(node.Peer.Id) {
+			online = append(online, node)
+		}
+	}
+	return online
+}
+
+func (h *Harness) randomOnline() *dht.KadNode {
+	online := h.Online()
+	return online[h.rnd.Intn(len(online))]
+}
+
+// Churn takes leave random online nodes down and joins new ones through random online nodes.
+// The last online node never leaves.
+func (h *Harness) Churn(c Churn) {
+	online := h.Online()
+	h.rnd.Shuffle(len(online), func(i, j int) {
+		online[i], online[j] = online[j], online[i]
+	})
+	for i := 0; i < c.Leave && i < len(online)-1; i++ {
+		h.Network.SetOnline(online[i].Peer.Id, false)
+	}
+	for i := 0; i < c.Join; i++ {
+		bootstrap := h.randomOnline()
+		node := h.Network.NewNode(h.k, h.b, h.alpha)
+		h.Nodes = append(h.Nodes, node)
+		h.join(node, bootstrap)
+	}
+}
+
+// Key returns i-th key of workload.
+func Key(i int) []byte {
+	hash := sha1.Sum([]byte("key-" + strconv.Itoa(i)))
+	return hash[:]
+}
+
+func value(i int) []byte {
+	return []byte("value-" + strconv.Itoa(i))
+}
+
+// closest returns ids of k online nodes closest to id, node with id excluded.
+func closest(online []*dht.KadNode, id dht.Id, k int) []dht.Id {
+	type candidate struct {
+		id, distance *big.Int
+	}
+	candidates := make([]candidate, 0, len(online))
+	for _, node := range online {
+		if node.Peer.Id.Cmp(id) != 0 {
+			candidates = append(candidates, candidate{node.Peer.Id, new(big.Int).Xor(node.Peer.Id, id)})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].distance.Cmp(candidates[j].distance) < 0
+	})
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	ids := make([]dht.Id, len(candidates))
+	for i, c := range candidates {
+		ids[i] = c.id
+	}
+	return ids
+}
+
+func contains(ids []dht.Id, id dht.Id) bool {
+	for _, i := range ids {
+		if i.Cmp(id) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// tableSamples bounds number of routing tables measured, finding closest nodes is quadratic.
+const tableSamples = 500
+
+// tableQuality measures routing tables of sample of online nodes.
+func (h *Harness) tableQuality(online []*dht.KadNode) (completeness, stale float64) {
+	sample := online
+	if len(sample) > tableSamples {
+		sample = make([]*dht.KadNode, tableSamples)
+		for i, j := range h.rnd.Perm(len(online))[:tableSamples] {
+			sample[i] = online[j]
+		}
+	}
+	var found, expected, entries, offline int
+	for _, node := range sample {
+		var known []dht.Id
+		for _, b := range node.Buckets() {
+			for _, peer := range b.Peers {
+				known = append(known, peer.Id)
+				if !h.Network.Online(peer.Id) {
+					offline++
+				}
+			}
+		}
+		entries += len(known)
+		for _, id := range closest(online, node.Peer.Id, h.k) {
+			expected++
+			if contains(known, id) {
+				found++
+			}
+		}
+	}
+	return ratio(found, expected), ratio(offline, entries)
+}
+
+// Run bootstraps network, stores values, applies churn schedule and measures lookups.
+func Run(ctx context.Context, s Scenario) (*Report, error) {
+	h := NewHarness(s.Config, s.K, s.B, s.Alpha)
+	if err := h.Bootstrap(ctx, s.Nodes); err != nil {
+		return nil, err
+	}
+	report := &Report{}
+	var hops, successes int
+
+	before := h.Network.Stats()
+	for i := 0; i < s.Keys; i++ {
+		report.Sets++
+		if err := h.randomOnline().SetTTLContext(ctx, Key(i), value(i), s.TTL); err != nil {
+			report.SetFailures++
+		}
+	}
+	report.SetMessages = perOp(h.Network.Stats(), before, report.Sets)
+
+	for _, c := range s.Churn {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		h.Network.Clock.Advance(c.After)
+		h.Churn(c)
+		h.Network.Tick(ctx)
+	}
+
+	before = h.Network.Stats()
+	for i := 0; i < s.Gets && s.Keys > 0; i++ {
+		report.Gets++
+		j := h.rnd.Intn(s.Keys)
+		node := h.randomOnline()
+		result, err := node.GetResultContext(ctx, Key(j))
+		// caching found value runs in background, it has to finish before the next operation
+		// for messages to be counted and runs with the same seed to be repeatable
+		node.Wait()
+		if err != nil || !bytes.Equal(result.Value(), value(j)) {
+			report.GetFailures++
+			continue
+		}
+		hops += result.Hops()
+		successes++
+		if result.Hops() > report.HopsMax {
+			report.HopsMax = result.Hops()
+		}
+	}
+	report.GetMessages = perOp(h.Network.Stats(), before, report.Gets)
+
+	online := h.Online()
+	before = h.Network.Stats()
+	var found, expected int
+	for i := 0; i < s.Lookups; i++ {
+		report.Lookups++
+		id := h.Network.RandomId()
+		result, err := h.randomOnline().LookupContext(ctx, id, false)
+		closest := closest(online, id, s.K)
+		expected += len(closest)
+		if err != nil {
+			continue
+		}
+		for _, peer := range result.Peers() {
+			if contains(closest, peer.Id) {
+				found++
+			}
+		}
+		hops += result.Hops()
+		successes++
+		if result.Hops() > report.HopsMax {
+			report.HopsMax = result.Hops()
+		}
+	}
+	report.LookupMessages = perOp(h.Network.Stats(), before, report.Lookups)
+	report.LookupAccuracy = ratio(found, expected)
+	report.HopsMean = ratio(hops, successes)
+
+	report.Nodes = len(h.Nodes)
+	report.Online = len(online)
+	report.JoinFailures = h.JoinFailures
+	report.TableCompleteness, report.TableStale = h.tableQuality(online)
+	report.Stats = h.Network.Stats()
+	return report, nil
+}
+
+func perOp(after, before Stats, ops int) float64 {
+	if ops == 0 {
+		return 0
+	}
+	return float64(after.Messages-before.Messages) / float64(ops)
+}
@@ -0,0 +1,45 @@
+package sim
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	scenario := Scenario{
+		Config:  Config{Latency: 20 * time.Millisecond, Jitter: 30 * time.Millisecond, Loss: 0.01, Timeout: time.Second, Seed: 1},
+		Nodes:   300,
+		K:       8,
+		B:       5,
+		Alpha:   3,
+		Keys:    30,
+		TTL:     24 * time.Hour,
+		Churn:   []Churn{{After: time.Hour, Leave: 30, Join: 30}, {After: time.Hour, Leave: 30, Join: 30}},
+		Gets:    100,
+		Lookups: 50,
+	}
+	report, err := Run(context.Background(), scenario)
+	if err != nil {
+		t.Fatalf("run failed: %v\n", err)
+	}
+	t.Logf("report:\n%s", report)
+	if report.Nodes != 360 || report.Online != 300 {
+		t.Errorf("churn not applied, nodes: %d, online: %d\n", report.Nodes, report.Online)
+	}
+	if report.Sets != 30 || report.Gets != 100 || report.Lookups != 50 {
+		t.Errorf("workload not run: %+v\n", report)
+	}
+	if success := ratio(report.Gets-report.GetFailures, report.Gets); success < 0.9 {
+		t.Errorf("too many failed gets: %.3f\n", success)
+	}
+	if report.LookupAccuracy < 0.7 || report.TableCompleteness < 0.5 {
+		t.Errorf("poor routing: %+v\n", report)
+	}
+	if report.HopsMean < 1 || report.HopsMax < 1 || report.GetMessages == 0 {
+		t.Errorf("lookups not measured: %+v\n", report)
+	}
+	if report.Stats.Dropped == 0 {
+		t.Errorf("lossy network should drop messages\n")
+	}
+}
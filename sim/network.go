@@ -0,0 +1,290 @@
+// Package sim connects many dht nodes through a simulated network running in a single process.
+package sim
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/mduszyk/gopeers/dht"
+	"github.com/mduszyk/gopeers/store"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrTimeout = errors.New("simulated call timeout")
+
+type Config struct {
+	// one way delivery delay, every message gets extra random delay up to Jitter
+	Latency time.Duration
+	Jitter  time.Duration
+	// probability of losing a message
+	Loss float64
+	// calls whose round trip exceeds Timeout fail, zero disables timeouts
+	Timeout time.Duration
+	// seeds ids and message fate, same seed replays the same run
+	Seed int64
+}
+
+type Stats struct {
+	Calls    int64
+	Messages int64
+	Dropped  int64
+	Timeouts int64
+	// sum of round trips of answered calls
+	RoundTrip time.Duration
+}
+
+type host struct {
+	node   *dht.KadNode
+	remote *remote
+	online bool
+	group  int
+}
+
+// Network delivers rpcs between nodes by direct calls, there are no sockets
+// and nothing sleeps, latency only decides which calls time out.
+type Network struct {
+	config Config
+	Clock  *Clock
+	hosts  map[string]*host
+	// messages sent over every directed link, message fate depends only on its link
+	sequence map[string]uint64
+	rnd      *rand.Rand
+	stats    Stats
+	mutex    sync.Mutex
+}
+
+func NewNetwork(config Config) *Network {
+	return &Network{
+		config:   config,
+		Clock:    NewClock(time.Now()),
+		hosts:    make(map[string]*host),
+		sequence: make(map[string]uint64),
+		rnd:      rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+func key(id dht.Id) string {
+	return string(id.Bytes())
+}
+
+// RandomId draws id from seeded source, so that node ids are the same in every run.
+func (n *Network) RandomId() dht.Id {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	bytes := make([]byte, dht.IdBits/8)
+	n.rnd.Read(bytes)
+	return dht.BytesId(bytes)
+}
+
+// NewNode creates node with random id and memory storage attached to the network.
+func (n *Network) NewNode(k, b, alpha int) *dht.KadNode {
+	node := dht.NewKadNode(k, b, alpha, n.RandomId(), store.NewMemStorage())
+	n.Attach(node)
+	return node
+}
+
+// Attach makes node reachable through the network and switches it and its memory storage to virtual clock.
+func (n *Network) Attach(node *dht.KadNode) {
+	r := &remote{n, node.Peer.Id}
+	node.Peer.Proto = r
+	node.Clock = n.Clock.Now
+	if storage, ok := node.Storage.(*store.MemStorage); ok {
+		storage.Clock = n.Clock.Now
+	}
+	n.mutex.Lock()
+	n.hosts[key(node.Peer.Id)] = &host{node: node, remote: r, online: true}
+	n.mutex.Unlock()
+}
+
+// Remove detaches node, calls to it time out from now on.
+func (n *Network) Remove(id dht.Id) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	delete(n.hosts, key(id))
+}
+
+// SetOnline takes node down or brings it back with its state preserved.
+func (n *Network) SetOnline(id dht.Id, online bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if h, ok := n.hosts[key(id)]; ok {
+		h.online = online
+	}
+}
+
+func (n *Network) Online(id dht.Id) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	h, ok := n.hosts[key(id)]
+	return ok && h.online
+}
+
+// Partition splits network into given groups, nodes which are not listed form one more group.
+// Messages between different groups are lost.
+func (n *Network) Partition(groups ...[]dht.Id) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for _, h := range n.hosts {
+		h.group = 0
+	}
+	for i, group := range groups {
+		for _, id := range group {
+			if h, ok := n.hosts[key(id)]; ok {
+				h.group = i + 1
+			}
+		}
+	}
+}
+
+// Heal removes all partitions.
+func (n *Network) Heal() {
+	n.Partition()
+}
+
+// Peer returns contact of attached node, it is used to join the network.
+func (n *Network) Peer(id dht.Id) *dht.Peer {
+	return &dht.Peer{Id: id, Proto: &remote{n, id}, LastSeen: n.Clock.Now()}
+}
+
+func (n *Network) Node(id dht.Id) *dht.KadNode {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if h, ok := n.hosts[key(id)]; ok {
+		return h.node
+	}
+	return nil
+}
+
+// Nodes returns attached nodes ordered by id.
+func (n *Network) Nodes() []*dht.KadNode {
+	n.mutex.Lock()
+	nodes := make([]*dht.KadNode, 0, len(n.hosts))
+	for _, h := range n.hosts {
+		nodes = append(nodes, h.node)
+	}
+	n.mutex.Unlock()
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Peer.Id.Cmp(nodes[j].Peer.Id) < 0
+	})
+	return nodes
+}
+
+// Tick runs maintenance of online nodes one after another at current virtual time.
+func (n *Network) Tick(ctx context.Context) {
+	now := n.Clock.Now()
+	for _, node := range n.Nodes() {
+		if ctx.Err() != nil {
+			return
+		}
+		if n.Online(node.Peer.Id) {
+			node.Tick(ctx, now)
+		}
+	}
+}
+
+func (n *Network) Stats() Stats {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.stats
+}
+
+func (n *Network) ResetStats() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.stats = Stats{}
+}
+
+// send decides fate of message from one node to another, it returns delivery delay
+// or false when message is lost. Caller must hold the mutex.
+func (n *Network) send(from, to *host) (time.Duration, bool) {
+	n.stats.Messages++
+	link := key(from.node.Peer.Id) + key(to.node.Peer.Id)
+	seq := n.sequence[link]
+	n.sequence[link] = seq + 1
+
+	h := fnv.New64a()
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(n.config.Seed))
+	h.Write(buf[:])
+	h.Write([]byte(link))
+	binary.BigEndian.PutUint64(buf[:], seq)
+	h.Write(buf[:])
+	r1 := mix(h.Sum64())
+	r2 := mix(r1)
+
+	lost := float64(r1>>11)/(1<<53) < n.config.Loss
+	if !from.online || !to.online || from.group != to.group || lost {
+		n.stats.Dropped++
+		return 0, false
+	}
+	delay := n.config.Latency
+	if n.config.Jitter > 0 {
+		delay += time.Duration(r2 % uint64(n.config.Jitter))
+	}
+	return delay, true
+}
+
+// mix is splitmix64 finalizer, it turns hash into well distributed random number.
+func mix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// request delivers call from sender to id, it returns receiving node and sender
+// as seen by it.
+func (n *Network) request(sender *dht.Peer, id dht.Id) (*dht.KadNode, *dht.Peer, time.Duration, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.stats.Calls++
+	from, ok1 := n.hosts[key(sender.Id)]
+	to, ok2 := n.hosts[key(id)]
+	if !ok1 || !ok2 {
+		n.stats.Messages++
+		n.stats.Dropped++
+		n.stats.Timeouts++
+		return nil, nil, 0, ErrTimeout
+	}
+	delay, ok := n.send(from, to)
+	if !ok {
+		n.stats.Timeouts++
+		return nil, nil, 0, ErrTimeout
+	}
+	peer := &dht.Peer{Id: sender.Id, Proto: from.remote, LastSeen: n.Clock.Now()}
+	return to.node, peer, delay, nil
+}
+
+// respond delivers response of call which took delay so far.
+func (n *Network) respond(sender *dht.Peer, id dht.Id, delay time.Duration) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	from, ok1 := n.hosts[key(id)]
+	to, ok2 := n.hosts[key(sender.Id)]
+	if !ok1 || !ok2 {
+		n.stats.Timeouts++
+		return ErrTimeout
+	}
+	d, ok := n.send(from, to)
+	delay += d
+	if !ok || (n.config.Timeout > 0 && delay > n.config.Timeout) {
+		n.stats.Timeouts++
+		return ErrTimeout
+	}
+	n.stats.RoundTrip += delay
+	return nil
+}
+
+// peers copies peers returned by remote node, the same way they would be decoded from the wire.
+func (n *Network) peers(peers []*dht.Peer) []*dht.Peer {
+	now := n.Clock.Now()
+	copies := make([]*dht.Peer, len(peers))
+	for i, peer := range peers {
+		copies[i] = &dht.Peer{Id: peer.Id, Proto: &remote{n, peer.Id}, LastSeen: now}
+	}
+	return copies
+}
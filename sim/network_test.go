@@ -0,0 +1,176 @@
+package sim
+
+import (
+	"bytes"
+	"context"
+	"github.com/mduszyk/gopeers/dht"
+	"testing"
+	"time"
+)
+
+func joinedNetwork(t *testing.T, config Config, n, k int) (*Network, []*dht.KadNode) {
+	network := NewNetwork(config)
+	nodes := make([]*dht.KadNode, n)
+	for i := 0; i < n; i++ {
+		nodes[i] = network.NewNode(k, 5, 3)
+	}
+	for i := 1; i < n; i++ {
+		err := nodes[i].Join(network.Peer(nodes[0].Peer.Id))
+		if err != nil {
+			t.Fatalf("failed joining: %v\n", err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		err := nodes[i].Refresh()
+		if err != nil {
+			t.Fatalf("failed refreshing: %v\n", err)
+		}
+	}
+	return network, nodes
+}
+
+func ping(network *Network, from, to *dht.KadNode) error {
+	_, err := network.Peer(to.Peer.Id).Proto.Ping(from.Peer, network.RandomId())
+	return err
+}
+
+func TestLookupConvergence(t *testing.T) {
+	k := 8
+	network, nodes := joinedNetwork(t, Config{Seed: 1}, 1000, k)
+	for i := 0; i < 20; i++ {
+		id := network.RandomId()
+		result, err := nodes[i*37].Lookup(id, false)
+		if err != nil {
+			t.Fatalf("lookup failed: %v\n", err)
+		}
+		expected := closest(nodes, id, k)
+		if len(result.Peers()) != k {
+			t.Fatalf("lookup returned %d peers\n", len(result.Peers()))
+		}
+		if result.Peers()[0].Id.Cmp(expected[0]) != 0 {
+			t.Errorf("lookup %d missed the closest node\n", i)
+		}
+		// lookup stops after querying k peers, so the farthest results may be approximate
+		found := 0
+		for _, peer := range result.Peers() {
+			for _, e := range expected {
+				if peer.Id.Cmp(e) == 0 {
+					found++
+				}
+			}
+		}
+		if found < k/2 {
+			t.Errorf("lookup %d found only %d of %d closest nodes\n", i, found, k)
+		}
+	}
+}
+
+func TestLossAndTimeouts(t *testing.T) {
+	network := NewNetwork(Config{Loss: 0.5, Seed: 1})
+	node1 := network.NewNode(20, 5, 3)
+	node2 := network.NewNode(20, 5, 3)
+	failed := 0
+	for i := 0; i < 100; i++ {
+		if _, err := node1.Peer.Proto.Ping(node2.Peer, network.RandomId()); err == ErrTimeout {
+			failed++
+		}
+	}
+	// call succeeds only if both request and response are delivered
+	if failed < 60 || failed > 90 {
+		t.Errorf("unexpected number of failed calls: %d\n", failed)
+	}
+	stats := network.Stats()
+	if stats.Calls != 100 || stats.Timeouts != int64(failed) || stats.Dropped == 0 {
+		t.Errorf("invalid stats: %+v\n", stats)
+	}
+
+	network = NewNetwork(Config{Latency: 100 * time.Millisecond, Timeout: 150 * time.Millisecond})
+	node1 = network.NewNode(20, 5, 3)
+	node2 = network.NewNode(20, 5, 3)
+	if err := ping(network, node1, node2); err == nil {
+		t.Errorf("call with round trip above timeout should fail\n")
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	run := func() []bool {
+		network := NewNetwork(Config{Loss: 0.3, Seed: 7})
+		node1 := network.NewNode(20, 5, 3)
+		node2 := network.NewNode(20, 5, 3)
+		results := make([]bool, 50)
+		for i := range results {
+			_, err := node2.Peer.Proto.Ping(node1.Peer, network.RandomId())
+			results[i] = err == nil
+		}
+		return results
+	}
+	first, second := run(), run()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("runs with the same seed differ at call %d\n", i)
+		}
+	}
+}
+
+func TestPartition(t *testing.T) {
+	network, nodes := joinedNetwork(t, Config{Seed: 2}, 50, 5)
+	var left, right []dht.Id
+	for i, node := range nodes {
+		if i < 25 {
+			left = append(left, node.Peer.Id)
+		} else {
+			right = append(right, node.Peer.Id)
+		}
+	}
+	network.Partition(left, right)
+	err := ping(network, nodes[0], nodes[49])
+	if err != ErrTimeout {
+		t.Errorf("partitioned nodes should not communicate, got: %v\n", err)
+	}
+	err = ping(network, nodes[0], nodes[24])
+	if err != nil {
+		t.Errorf("nodes of the same partition should communicate, got: %v\n", err)
+	}
+	network.Heal()
+	err = ping(network, nodes[0], nodes[49])
+	if err != nil {
+		t.Errorf("healed network should deliver, got: %v\n", err)
+	}
+
+	network.SetOnline(nodes[49].Peer.Id, false)
+	if err := ping(network, nodes[0], nodes[49]); err != ErrTimeout {
+		t.Errorf("offline node should not respond, got: %v\n", err)
+	}
+}
+
+func TestVirtualClock(t *testing.T) {
+	network, nodes := joinedNetwork(t, Config{Seed: 3}, 50, 5)
+	key := []byte("key")
+	value := []byte("value")
+	err := nodes[0].SetTTL(key, value, time.Hour)
+	if err != nil {
+		t.Fatalf("set failed: %v\n", err)
+	}
+	got, err := nodes[10].Get(key)
+	if err != nil || !bytes.Equal(got, value) {
+		t.Fatalf("get failed: %v\n", err)
+	}
+	// let background caching of the get finish before time moves
+	nodes[10].Wait()
+
+	network.Clock.Advance(2 * time.Hour)
+	for _, node := range nodes {
+		if keys, _ := node.Storage.Keys(); len(keys) > 0 {
+			t.Fatalf("expired value should not be listed before sweep\n")
+		}
+	}
+	if _, err := nodes[10].Get(key); err == nil {
+		t.Fatalf("expired value should not be found before sweep\n")
+	}
+	network.Tick(context.Background())
+	for _, node := range nodes {
+		if _, err := node.Storage.Get(key); err == nil {
+			t.Fatalf("value should expire in virtual time\n")
+		}
+	}
+}
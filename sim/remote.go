@@ -0,0 +1,145 @@
+package sim
+
+import (
+	"context"
+	"github.com/mduszyk/gopeers/dht"
+	"time"
+)
+
+// remote implements dht.Protocol by calling node with given id through the network.
+type remote struct {
+	network *Network
+	id      dht.Id
+}
+
+// maxDepth limits calls made by request handlers, like liveness checks of full buckets.
+// Over real network long chains of such calls are cut by timeouts, here they would recurse forever.
+const maxDepth = 4
+
+type depthKey struct{}
+
+// enter returns context of request handled by remote node.
+func (r *remote) enter(ctx context.Context) (context.Context, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	depth, _ := ctx.Value(depthKey{}).(int)
+	if depth >= maxDepth {
+		r.network.mutex.Lock()
+		r.network.stats.Calls++
+		r.network.stats.Timeouts++
+		r.network.mutex.Unlock()
+		return nil, ErrTimeout
+	}
+	return context.WithValue(ctx, depthKey{}, depth+1), nil
+}
+
+func (r *remote) Ping(sender *dht.Peer, randomId dht.Id) (dht.Id, error) {
+	return r.PingContext(context.Background(), sender, randomId)
+}
+
+func (r *remote) FindNode(sender *dht.Peer, id dht.Id) (*dht.FindResult, error) {
+	return r.FindNodeContext(context.Background(), sender, id)
+}
+
+func (r *remote) FindValue(sender *dht.Peer, key dht.Id) (*dht.FindResult, error) {
+	return r.FindValueContext(context.Background(), sender, key)
+}
+
+func (r *remote) Store(sender *dht.Peer, key dht.Id, value []byte, ttl time.Duration) error {
+	return r.StoreContext(context.Background(), sender, key, value, ttl)
+}
+
+func (r *remote) Announce(sender *dht.Peer, key dht.Id, ttl time.Duration) error {
+	return r.AnnounceContext(context.Background(), sender, key, ttl)
+}
+
+func (r *remote) PingContext(ctx context.Context, sender *dht.Peer, randomId dht.Id) (dht.Id, error) {
+	ctx, err := r.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	node, peer, delay, err := r.network.request(sender, r.id)
+	if err != nil {
+		return nil, err
+	}
+	echoId, err := node.PingContext(ctx, peer, randomId)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.network.respond(sender, r.id, delay); err != nil {
+		return nil, err
+	}
+	return echoId, nil
+}
+
+func (r *remote) FindNodeContext(ctx context.Context, sender *dht.Peer, id dht.Id) (*dht.FindResult, error) {
+	ctx, err := r.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	node, peer, delay, err := r.network.request(sender, r.id)
+	if err != nil {
+		return nil, err
+	}
+	result, err := node.FindNodeContext(ctx, peer, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.network.respond(sender, r.id, delay); err != nil {
+		return nil, err
+	}
+	return dht.NewFindResult(r.network.peers(result.Peers()), nil, 0, nil), nil
+}
+
+func (r *remote) FindValueContext(ctx context.Context, sender *dht.Peer, key dht.Id) (*dht.FindResult, error) {
+	ctx, err := r.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	node, peer, delay, err := r.network.request(sender, r.id)
+	if err != nil {
+		return nil, err
+	}
+	result, err := node.FindValueContext(ctx, peer, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.network.respond(sender, r.id, delay); err != nil {
+		return nil, err
+	}
+	return dht.NewFindResult(
+		r.network.peers(result.Peers()), result.Value(), result.TTL(), r.network.peers(result.Providers())), nil
+}
+
+func (r *remote) StoreContext(ctx context.Context, sender *dht.Peer, key dht.Id, value []byte, ttl time.Duration) error {
+	ctx, err := r.enter(ctx)
+	if err != nil {
+		return err
+	}
+	node, peer, delay, err := r.network.request(sender, r.id)
+	if err != nil {
+		return err
+	}
+	err = node.StoreContext(ctx, peer, key, value, ttl)
+	if err != nil {
+		return err
+	}
+	return r.network.respond(sender, r.id, delay)
+}
+
+func (r *remote) AnnounceContext(ctx context.Context, sender *dht.Peer, key dht.Id, ttl time.Duration) error {
+	ctx, err := r.enter(ctx)
+	if err != nil {
+		return err
+	}
+	node, peer, delay, err := r.network.request(sender, r.id)
+	if err != nil {
+		return err
+	}
+	err = node.AnnounceContext(ctx, peer, key, ttl)
+	if err != nil {
+		return err
+	}
+	return r.network.respond(sender, r.id, delay)
+}
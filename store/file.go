@@ -0,0 +1,482 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs the log after every write.
+	SyncAlways SyncPolicy = iota
+	// SyncPeriodic fsyncs the log every sync interval.
+	SyncPeriodic
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+const (
+	opSet    = byte(1)
+	opDelete = byte(2)
+	// crc, op, expiry, key length, value length
+	headerSize = 4 + 1 + 8 + 4 + 4
+	// log is compacted when at least half of it and more than this is garbage
+	compactMinGarbage = 1 << 20
+)
+
+type fileEntry struct {
+	offset int64
+	size   int64
+	expiry time.Time
+}
+
+// FileStorage keeps values in an append-only log, index of live values is kept in memory.
+// Torn records at the end of the log are truncated on open, corrupted records followed by
+// valid ones are skipped.
+type FileStorage struct {
+	path    string
+	file    *os.File
+	policy  SyncPolicy
+	index   map[string]fileEntry
+	size    int64
+	garbage int64
+	// corrupted bytes found by load
+	skipped   int64
+	truncated int64
+	mutex   sync.RWMutex
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+func NewFileStorage(path string, policy SyncPolicy, syncInterval time.Duration) (*FileStorage, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStorage{
+		path:   path,
+		file:   file,
+		policy: policy,
+		index:  make(map[string]fileEntry),
+	}
+	err = s.load()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if policy == SyncPeriodic {
+		s.stop = make(chan struct{})
+		s.stopped.Add(1)
+		go s.syncLoop(syncInterval)
+	}
+	return s, nil
+}
+
+func encodeRecord(op byte, key []byte, value []byte, expiry time.Time) []byte {
+	buf := make([]byte, headerSize+len(key)+len(value))
+	buf[4] = op
+	var nanos int64
+	if !expiry.IsZero() {
+		nanos = expiry.UnixNano()
+	}
+	binary.BigEndian.PutUint64(buf[5:13], uint64(nanos))
+	binary.BigEndian.PutUint32(buf[13:17], uint32(len(key)))
+	binary.BigEndian.PutUint32(buf[17:21], uint32(len(value)))
+	copy(buf[headerSize:], key)
+	copy(buf[headerSize+len(key):], value)
+	binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+// recordLengths returns key and value length of record with given header at offset,
+// false if the record would reach past the end of the log.
+func recordLengths(header []byte, offset, size int64) (int64, int64, bool) {
+	keyLen := int64(binary.BigEndian.Uint32(header[13:17]))
+	valueLen := int64(binary.BigEndian.Uint32(header[17:21]))
+	// lengths aren't verified until crc is checked, corrupted ones must not cause huge allocation
+	return keyLen, valueLen, offset+headerSize+keyLen+valueLen <= size
+}
+
+func validRecord(header []byte, body []byte) bool {
+	if header[4] != opSet && header[4] != opDelete {
+		return false
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(body)
+	return crc.Sum32() == binary.BigEndian.Uint32(header[0:4])
+}
+
+// resync returns offset of the first valid record at or after from, -1 if there is none.
+func (s *FileStorage) resync(from, size int64) int64 {
+	header := make([]byte, headerSize)
+	for offset := from; offset+headerSize <= size; offset++ {
+		if _, err := s.file.ReadAt(header, offset); err != nil {
+			return -1
+		}
+		keyLen, valueLen, ok := recordLengths(header, offset, size)
+		if !ok || (header[4] != opSet && header[4] != opDelete) {
+			continue
+		}
+		body := make([]byte, keyLen+valueLen)
+		if _, err := s.file.ReadAt(body, offset+headerSize); err != nil {
+			return -1
+		}
+		if validRecord(header, body) {
+			return offset
+		}
+	}
+	return -1
+}
+
+// load rebuilds index from the log. Corrupted records followed by valid ones are skipped,
+// only torn tail without any valid record after it is truncated.
+func (s *FileStorage) load() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	reader := bufio.NewReader(io.NewSectionReader(s.file, 0, size))
+	now := time.Now()
+	header := make([]byte, headerSize)
+	var offset int64
+	for offset < size {
+		_, err := io.ReadFull(reader, header)
+		valid := err == nil
+		var keyLen, valueLen int64
+		var body []byte
+		if valid {
+			keyLen, valueLen, valid = recordLengths(header, offset, size)
+		}
+		if valid {
+			body = make([]byte, keyLen+valueLen)
+			_, err = io.ReadFull(reader, body)
+			valid = err == nil && validRecord(header, body)
+		}
+		if !valid {
+			next := s.resync(offset+1, size)
+			if next < 0 {
+				break
+			}
+			// skipped bytes are reclaimed by compaction
+			s.skipped += next - offset
+			s.garbage += next - offset
+			offset = next
+			reader.Reset(io.NewSectionReader(s.file, offset, size-offset))
+			continue
+		}
+
+		recordSize := headerSize + keyLen + valueLen
+		key := string(body[:keyLen])
+		if old, ok := s.index[key]; ok {
+			s.garbage += old.size
+			delete(s.index, key)
+		}
+		var expiry time.Time
+		if nanos := int64(binary.BigEndian.Uint64(header[5:13])); nanos != 0 {
+			expiry = time.Unix(0, nanos)
+		}
+		e := fileEntry{offset, recordSize, expiry}
+		if header[4] == opSet && !e.expired(now) {
+			s.index[key] = e
+		} else {
+			s.garbage += recordSize
+		}
+		offset += recordSize
+	}
+	s.size = offset
+	s.truncated = size - offset
+	err = s.file.Truncate(offset)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// Recovered returns bytes of corrupted records skipped in the middle of the log
+// and bytes of torn tail truncated when the storage was opened.
+func (s *FileStorage) Recovered() (skipped int64, truncated int64) {
+	return s.skipped, s.truncated
+}
+
+func (e fileEntry) expired(now time.Time) bool {
+	return !e.expiry.IsZero() && !now.Before(e.expiry)
+}
+
+func (s *FileStorage) append(record []byte) (int64, error) {
+	offset := s.size
+	_, err := s.file.Write(record)
+	if err != nil {
+		// drop partially written record
+		_ = s.file.Truncate(offset)
+		_, _ = s.file.Seek(offset, io.SeekStart)
+		return 0, err
+	}
+	if s.policy == SyncAlways {
+		err = s.file.Sync()
+		if err != nil {
+			// record isn't indexed, it must not appear after restart
+			_ = s.file.Truncate(offset)
+			_, _ = s.file.Seek(offset, io.SeekStart)
+			return 0, err
+		}
+	}
+	s.size += int64(len(record))
+	return offset, nil
+}
+
+func (s *FileStorage) Set(key []byte, value []byte) error {
+	return s.SetWithExpiry(key, value, time.Time{})
+}
+
+func (s *FileStorage) SetWithExpiry(key []byte, value []byte, expiry time.Time) error {
+	record := encodeRecord(opSet, key, value, expiry)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return errors.New("storage closed")
+	}
+	offset, err := s.append(record)
+	if err != nil {
+		return err
+	}
+	k := string(key)
+	if old, ok := s.index[k]; ok {
+		s.garbage += old.size
+	}
+	s.index[k] = fileEntry{offset, int64(len(record)), expiry}
+	return s.maybeCompact()
+}
+
+func (s *FileStorage) Delete(key []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return errors.New("storage closed")
+	}
+	k := string(key)
+	old, ok := s.index[k]
+	if !ok {
+		return nil
+	}
+	record := encodeRecord(opDelete, key, nil, time.Time{})
+	_, err := s.append(record)
+	if err != nil {
+		return err
+	}
+	delete(s.index, k)
+	s.garbage += old.size + int64(len(record))
+	return s.maybeCompact()
+}
+
+func (s *FileStorage) get(key []byte) (fileEntry, error) {
+	e, ok := s.index[string(key)]
+	if !ok || e.expired(time.Now()) {
+		return fileEntry{}, errors.New("key not found")
+	}
+	return e, nil
+}
+
+func (s *FileStorage) Get(key []byte) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.file == nil {
+		return nil, errors.New("storage closed")
+	}
+	e, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, e.size-headerSize-int64(len(key)))
+	_, err = s.file.ReadAt(value, e.offset+headerSize+int64(len(key)))
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (s *FileStorage) Expiry(key []byte) (time.Time, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.file == nil {
+		return time.Time{}, errors.New("storage closed")
+	}
+	e, err := s.get(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return e.expiry, nil
+}
+
+func (s *FileStorage) Size(key []byte) (int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.file == nil {
+		return 0, errors.New("storage closed")
+	}
+	e, err := s.get(key)
+	if err != nil {
+		return 0, err
+	}
+	return int(e.size - headerSize - int64(len(key))), nil
+}
+
+func (s *FileStorage) Keys() ([][]byte, error) {
+	now := time.Now()
+	s.mutex.RLock()
+	keys := make([][]byte, 0, len(s.index))
+	for k, e := range s.index {
+		if !e.expired(now) {
+			keys = append(keys, []byte(k))
+		}
+	}
+	s.mutex.RUnlock()
+	return keys, nil
+}
+
+// Expire drops expired entries, their records are reclaimed by compaction.
+func (s *FileStorage) Expire(now time.Time) ([][]byte, error) {
+	var keys [][]byte
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return nil, errors.New("storage closed")
+	}
+	for k, e := range s.index {
+		if e.expired(now) {
+			record := encodeRecord(opDelete, []byte(k), nil, time.Time{})
+			_, err := s.append(record)
+			if err != nil {
+				return keys, err
+			}
+			delete(s.index, k)
+			s.garbage += e.size + int64(len(record))
+			keys = append(keys, []byte(k))
+		}
+	}
+	return keys, s.maybeCompact()
+}
+
+func (s *FileStorage) maybeCompact() error {
+	if s.garbage > compactMinGarbage && s.garbage > s.size/2 {
+		return s.compact()
+	}
+	return nil
+}
+
+func (s *FileStorage) Compact() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return errors.New("storage closed")
+	}
+	return s.compact()
+}
+
+// compact copies live records into new log which atomically replaces the old one.
+func (s *FileStorage) compact() error {
+	tmpPath := s.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	index := make(map[string]fileEntry, len(s.index))
+	var offset int64
+	for k, e := range s.index {
+		record := make([]byte, e.size)
+		_, err = s.file.ReadAt(record, e.offset)
+		if err == nil {
+			_, err = writer.Write(record)
+		}
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		index[k] = fileEntry{offset, e.size, e.expiry}
+		offset += e.size
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, s.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	syncDir(filepath.Dir(s.path))
+	s.file.Close()
+	s.file = tmp
+	s.index = index
+	s.size = offset
+	s.garbage = 0
+	_, err = s.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	d.Close()
+}
+
+func (s *FileStorage) Sync() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return errors.New("storage closed")
+	}
+	return s.file.Sync()
+}
+
+func (s *FileStorage) syncLoop(interval time.Duration) {
+	defer s.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = s.Sync()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *FileStorage) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		s.stopped.Wait()
+		s.stop = nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return errors.New("storage closed")
+	}
+	err := s.file.Sync()
+	closeErr := s.file.Close()
+	s.file = nil
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
@@ -0,0 +1,255 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openFileStorage(t *testing.T, path string) *FileStorage {
+	store, err := NewFileStorage(path, SyncAlways, 0)
+	if err != nil {
+		t.Fatalf("failed opening file storage: %v\n", err)
+	}
+	return store
+}
+
+func TestFileStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	store := openFileStorage(t, path)
+
+	key := []byte("key")
+	value := []byte("value")
+	err := store.Set(key, value)
+	if err != nil {
+		t.Errorf("failed storing value: %v\n", err)
+	}
+	err = store.Set(key, []byte("value2"))
+	if err != nil {
+		t.Errorf("failed overwriting value: %v\n", err)
+	}
+	expiry := time.Now().Add(time.Hour).Round(0)
+	err = store.SetWithExpiry([]byte("ttl"), value, expiry)
+	if err != nil {
+		t.Errorf("failed storing value with expiry: %v\n", err)
+	}
+	err = store.Close()
+	if err != nil {
+		t.Errorf("failed closing storage: %v\n", err)
+	}
+
+	store = openFileStorage(t, path)
+	defer store.Close()
+	value2, err := store.Get(key)
+	if err != nil || !reflect.DeepEqual(value2, []byte("value2")) {
+		t.Errorf("overwritten value should survive restart: %s, %v\n", value2, err)
+	}
+	size, err := store.Size(key)
+	if err != nil || size != len("value2") {
+		t.Errorf("got invalid size: %d, %v\n", size, err)
+	}
+	e, err := store.Expiry([]byte("ttl"))
+	if err != nil || !e.Equal(expiry) {
+		t.Errorf("expiry should survive restart: %v, %v\n", e, err)
+	}
+	keys, _ := store.Keys()
+	if len(keys) != 2 {
+		t.Errorf("expected 2 keys, got: %d\n", len(keys))
+	}
+	_, err = store.Get([]byte("missing"))
+	if err == nil {
+		t.Errorf("got unexpected value\n")
+	}
+}
+
+func TestFileStorageExpiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	store := openFileStorage(t, path)
+
+	now := time.Now()
+	store.SetWithExpiry([]byte("a"), []byte("1"), now.Add(time.Minute))
+	store.SetWithExpiry([]byte("b"), []byte("2"), now.Add(time.Hour))
+	store.SetWithExpiry([]byte("c"), []byte("3"), now.Add(-time.Second))
+	if _, err := store.Get([]byte("c")); err == nil {
+		t.Errorf("expired value should not be returned\n")
+	}
+	expired, err := store.Expire(now.Add(2 * time.Minute))
+	if err != nil || len(expired) != 2 {
+		t.Errorf("expected 2 expired keys, got: %d, %v\n", len(expired), err)
+	}
+	store.Close()
+
+	store = openFileStorage(t, path)
+	defer store.Close()
+	keys, _ := store.Keys()
+	if len(keys) != 1 || string(keys[0]) != "b" {
+		t.Errorf("only unexpired value should be loaded: %q\n", keys)
+	}
+}
+
+func TestFileStorageRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	store := openFileStorage(t, path)
+	store.Set([]byte("a"), []byte("1"))
+	store.Set([]byte("b"), []byte("2"))
+	store.Close()
+
+	info, _ := os.Stat(path)
+	size := info.Size()
+	// simulate torn write of the last record
+	err := os.Truncate(path, size-1)
+	if err != nil {
+		t.Fatalf("failed truncating log: %v\n", err)
+	}
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{1, 2, 3})
+	f.Close()
+
+	store = openFileStorage(t, path)
+	if _, err := store.Get([]byte("a")); err != nil {
+		t.Errorf("intact record should be recovered: %v\n", err)
+	}
+	if _, err := store.Get([]byte("b")); err == nil {
+		t.Errorf("torn record should be dropped\n")
+	}
+	store.Set([]byte("c"), []byte("3"))
+	store.Close()
+
+	store = openFileStorage(t, path)
+	defer store.Close()
+	value, err := store.Get([]byte("c"))
+	if err != nil || string(value) != "3" {
+		t.Errorf("record written after recovery should be readable: %s, %v\n", value, err)
+	}
+}
+
+func TestFileStorageCorruptedLength(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	store := openFileStorage(t, path)
+	store.Set([]byte("a"), []byte("1"))
+	store.Set([]byte("b"), []byte("2"))
+	store.Close()
+
+	// value length of the second record claims 4 GiB
+	intact := int64(headerSize + 2)
+	f, _ := os.OpenFile(path, os.O_WRONLY, 0644)
+	_, err := f.WriteAt([]byte{0xFF, 0xFF, 0xFF, 0xFF}, intact+17)
+	f.Close()
+	if err != nil {
+		t.Fatalf("failed corrupting log: %v\n", err)
+	}
+
+	store = openFileStorage(t, path)
+	defer store.Close()
+	if _, err := store.Get([]byte("a")); err != nil {
+		t.Errorf("intact record should be recovered: %v\n", err)
+	}
+	if _, err := store.Get([]byte("b")); err == nil {
+		t.Errorf("corrupted record should be dropped\n")
+	}
+	info, _ := os.Stat(path)
+	if info.Size() != intact {
+		t.Errorf("log should be truncated to %d bytes, got: %d\n", intact, info.Size())
+	}
+	if _, truncated := store.Recovered(); truncated != intact {
+		t.Errorf("truncated bytes should be reported, got: %d\n", truncated)
+	}
+}
+
+func TestFileStorageCorruptedMiddle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	store := openFileStorage(t, path)
+	store.Set([]byte("a"), []byte("1"))
+	store.Set([]byte("b"), []byte("2"))
+	store.Set([]byte("c"), []byte("3"))
+	store.Set([]byte("d"), []byte("4"))
+	store.Close()
+	info, _ := os.Stat(path)
+	size := info.Size()
+
+	record := int64(headerSize + 2)
+	f, _ := os.OpenFile(path, os.O_WRONLY, 0644)
+	// flip a bit in value of the second record and claim 4 GiB key of the third one
+	_, err := f.WriteAt([]byte{'3'}, 2*record-1)
+	if err == nil {
+		_, err = f.WriteAt([]byte{0xFF, 0xFF, 0xFF, 0xFF}, 2*record+13)
+	}
+	f.Close()
+	if err != nil {
+		t.Fatalf("failed corrupting log: %v\n", err)
+	}
+
+	store = openFileStorage(t, path)
+	defer store.Close()
+	for _, key := range []string{"a", "d"} {
+		if _, err := store.Get([]byte(key)); err != nil {
+			t.Errorf("intact record %s should be recovered: %v\n", key, err)
+		}
+	}
+	for _, key := range []string{"b", "c"} {
+		if _, err := store.Get([]byte(key)); err == nil {
+			t.Errorf("corrupted record %s should be dropped\n", key)
+		}
+	}
+	if skipped, truncated := store.Recovered(); skipped != 2*record || truncated != 0 {
+		t.Errorf("corrupted records should be skipped, got skipped: %d, truncated: %d\n", skipped, truncated)
+	}
+	info, _ = os.Stat(path)
+	if info.Size() != size {
+		t.Errorf("log with valid tail should not be truncated, got: %d\n", info.Size())
+	}
+}
+
+func TestFileStorageCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	store := openFileStorage(t, path)
+	for i := 0; i < 100; i++ {
+		store.Set([]byte("key"), []byte{byte(i)})
+	}
+	store.Set([]byte("other"), []byte("x"))
+	store.Delete([]byte("other"))
+	before, _ := os.Stat(path)
+
+	err := store.Compact()
+	if err != nil {
+		t.Errorf("failed compacting: %v\n", err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Errorf("compaction should shrink log: %d >= %d\n", after.Size(), before.Size())
+	}
+	store.Set([]byte("new"), []byte("y"))
+	store.Close()
+
+	store = openFileStorage(t, path)
+	defer store.Close()
+	value, err := store.Get([]byte("key"))
+	if err != nil || value[0] != 99 {
+		t.Errorf("latest value should survive compaction: %v, %v\n", value, err)
+	}
+	if _, err := store.Get([]byte("other")); err == nil {
+		t.Errorf("deleted value should not survive compaction\n")
+	}
+	if _, err := store.Get([]byte("new")); err != nil {
+		t.Errorf("value written after compaction should be readable: %v\n", err)
+	}
+}
+
+func TestFileStoragePeriodicSync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	store, err := NewFileStorage(path, SyncPeriodic, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed opening file storage: %v\n", err)
+	}
+	store.Set([]byte("a"), []byte("1"))
+	time.Sleep(30 * time.Millisecond)
+	err = store.Close()
+	if err != nil {
+		t.Errorf("failed closing storage: %v\n", err)
+	}
+	if err = store.Close(); err == nil {
+		t.Errorf("second close should fail\n")
+	}
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"github.com/mduszyk/gopeers/metrics"
+	"time"
+)
+
+type meteredStorage struct {
+	Storage
+	sets    metrics.Counter
+	gets    metrics.Counter
+	misses  metrics.Counter
+	expired metrics.Counter
+	keys    metrics.Gauge
+}
+
+// NewMeteredStorage wraps storage reporting operations into m,
+// number of keys is counted on writes of new keys and recounted on every Expire.
+func NewMeteredStorage(storage Storage, m metrics.Metrics) Storage {
+	s := &meteredStorage{
+		Storage: storage,
+		sets:    m.Counter("store_sets_total", "Values written to local storage."),
+		gets:    m.Counter("store_gets_total", "Values read from local storage."),
+		misses:  m.Counter("store_misses_total", "Reads of keys missing in local storage."),
+		expired: m.Counter("store_expired_total", "Values expired from local storage."),
+		keys:    m.Gauge("store_keys", "Keys in local storage."),
+	}
+	// storage may be loaded from disk
+	s.countKeys()
+	return s
+}
+
+func (s *meteredStorage) countKeys() {
+	if keys, err := s.Storage.Keys(); err == nil {
+		s.keys.Set(float64(len(keys)))
+	}
+}
+
+func (s *meteredStorage) Set(key []byte, value []byte) error {
+	return s.SetWithExpiry(key, value, time.Time{})
+}
+
+func (s *meteredStorage) SetWithExpiry(key []byte, value []byte, expiry time.Time) error {
+	s.sets.Add(1)
+	// key missing or expired before write and present after it is new one,
+	// miscount of concurrent writes of the same key is corrected by Expire
+	_, missing := s.Storage.Size(key)
+	err := s.Storage.SetWithExpiry(key, value, expiry)
+	if err == nil && missing != nil {
+		if _, err := s.Storage.Size(key); err == nil {
+			s.keys.Add(1)
+		}
+	}
+	return err
+}
+
+func (s *meteredStorage) Get(key []byte) ([]byte, error) {
+	s.gets.Add(1)
+	value, err := s.Storage.Get(key)
+	if err != nil {
+		s.misses.Add(1)
+	}
+	return value, err
+}
+
+func (s *meteredStorage) Expire(now time.Time) ([][]byte, error) {
+	expired, err := s.Storage.Expire(now)
+	s.expired.Add(float64(len(expired)))
+	s.countKeys()
+	return expired, err
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"bytes"
+	"github.com/mduszyk/gopeers/metrics"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMeteredStorage(t *testing.T) {
+	registry := metrics.NewRegistry()
+	storage := NewMeteredStorage(NewMemStorage(), registry)
+	now := time.Now()
+	storage.Set([]byte("a"), []byte("1"))
+	storage.SetWithExpiry([]byte("b"), []byte("2"), now.Add(time.Minute))
+	storage.Get([]byte("a"))
+	storage.Get([]byte("missing"))
+	storage.Expire(now.Add(time.Hour))
+
+	var buf bytes.Buffer
+	registry.WriteText(&buf)
+	for _, line := range []string{
+		"store_sets_total 2", "store_gets_total 2", "store_misses_total 1",
+		"store_expired_total 1", "store_keys 1",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s\n", line, buf.String())
+		}
+	}
+}
+
+func TestMeteredStorageKeys(t *testing.T) {
+	mem := NewMemStorage()
+	mem.Set([]byte("a"), []byte("1"))
+	registry := metrics.NewRegistry()
+	storage := NewMeteredStorage(mem, registry)
+	now := time.Now()
+	storage.Set([]byte("a"), []byte("2"))
+	storage.Set([]byte("b"), []byte("1"))
+	storage.SetWithExpiry([]byte("c"), []byte("1"), now.Add(-time.Second))
+	storage.SetWithExpiry([]byte("c"), []byte("2"), now.Add(time.Hour))
+
+	var buf bytes.Buffer
+	registry.WriteText(&buf)
+	if !strings.Contains(buf.String(), "store_keys 3\n") {
+		t.Errorf("keys should be counted on writes:\n%s\n", buf.String())
+	}
+}
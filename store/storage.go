@@ -3,40 +3,115 @@ package store
 import (
 	"errors"
 	"sync"
+	"time"
 )
 
 type Storage interface {
 	Set(key []byte, value []byte) error
+	// SetWithExpiry stores value until expiry, zero expiry means no expiration.
+	SetWithExpiry(key []byte, value []byte, expiry time.Time) error
 	Get(key []byte) ([]byte, error)
+	Expiry(key []byte) (time.Time, error)
+	// Size returns length of value without reading it.
+	Size(key []byte) (int, error)
+	Keys() ([][]byte, error)
+	// Expire removes entries expired at given time and returns their keys.
+	Expire(now time.Time) ([][]byte, error)
+}
+
+type entry struct {
+	value  string
+	expiry time.Time
+}
+
+func (e entry) expired(now time.Time) bool {
+	return !e.expiry.IsZero() && !now.Before(e.expiry)
 }
 
 type MemStorage struct {
-	mapping map[string]string
+	mapping map[string]entry
 	mutex sync.RWMutex
+	// Clock returns current time values expire at, simulations replace it with virtual clock.
+	Clock func() time.Time
 }
 
 func NewMemStorage() *MemStorage {
 	return &MemStorage{
-		mapping: make(map[string]string),
+		mapping: make(map[string]entry),
+		Clock: time.Now,
 	}
 }
 
 func (s *MemStorage) Set(key []byte, value []byte) error {
+	return s.SetWithExpiry(key, value, time.Time{})
+}
+
+func (s *MemStorage) SetWithExpiry(key []byte, value []byte, expiry time.Time) error {
 	k := string(key)
 	v := string(value)
 	s.mutex.Lock()
-	s.mapping[k] = v
+	s.mapping[k] = entry{v, expiry}
 	s.mutex.Unlock()
 	return nil
 }
 
-func (s *MemStorage) Get(key []byte) ([]byte, error) {
+func (s *MemStorage) get(key []byte) (entry, error) {
 	k := string(key)
 	s.mutex.RLock()
-	v, ok := s.mapping[k]
+	e, ok := s.mapping[k]
 	s.mutex.RUnlock()
-	if !ok {
-		return nil, errors.New("key not found")
+	if !ok || e.expired(s.Clock()) {
+		return entry{}, errors.New("key not found")
+	}
+	return e, nil
+}
+
+func (s *MemStorage) Get(key []byte) ([]byte, error) {
+	e, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(e.value), nil
+}
+
+func (s *MemStorage) Expiry(key []byte) (time.Time, error) {
+	e, err := s.get(key)
+	if err != nil {
+		return time.Time{}, err
 	}
-	return []byte(v), nil
+	return e.expiry, nil
+}
+
+func (s *MemStorage) Size(key []byte) (int, error) {
+	e, err := s.get(key)
+	if err != nil {
+		return 0, err
+	}
+	return len(e.value), nil
+}
+
+func (s *MemStorage) Keys() ([][]byte, error) {
+	now := s.Clock()
+	s.mutex.RLock()
+	keys := make([][]byte, 0, len(s.mapping))
+	for k, e := range s.mapping {
+		if !e.expired(now) {
+			keys = append(keys, []byte(k))
+		}
+	}
+	s.mutex.RUnlock()
+	return keys, nil
+}
+
+func (s *MemStorage) Expire(now time.Time) ([][]byte, error) {
+	var keys [][]byte
+	s.mutex.Lock()
+	for k, e := range s.mapping {
+		if e.expired(now) {
+			delete(s.mapping, k)
+			keys = append(keys, []byte(k))
+		}
+	}
+	s.mutex.Unlock()
+	return keys, nil
 }
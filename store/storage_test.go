@@ -3,6 +3,7 @@ package store
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestMemStorage(t *testing.T) {
@@ -25,8 +26,95 @@ func TestMemStorage(t *testing.T) {
 		t.Errorf("got invalid value")
 	}
 
+	size, err := store.Size(key)
+	if err != nil || size != len(value) {
+		t.Errorf("got invalid size: %d, %v\n", size, err)
+	}
+
 	_, err = store.Get([]byte("missing"))
 	if err == nil {
 		t.Errorf("got unexpected value")
 	}
 }
+
+func TestMemStorageKeys(t *testing.T) {
+	store := NewMemStorage()
+
+	keys, err := store.Keys()
+	if err != nil || len(keys) != 0 {
+		t.Errorf("empty storage should have no keys\n")
+	}
+
+	_ = store.Set([]byte("key1"), []byte("value1"))
+	_ = store.Set([]byte("key2"), []byte("value2"))
+	_ = store.Set([]byte("key1"), []byte("value3"))
+
+	keys, err = store.Keys()
+	if err != nil {
+		t.Errorf("failed listing keys: %v\n", err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("got invalid number of keys: %d\n", len(keys))
+	}
+}
+
+func TestMemStorageExpiry(t *testing.T) {
+	store := NewMemStorage()
+	now := time.Now()
+
+	_ = store.Set([]byte("forever"), []byte("value"))
+	_ = store.SetWithExpiry([]byte("expired"), []byte("value"), now.Add(-time.Second))
+	_ = store.SetWithExpiry([]byte("later"), []byte("value"), now.Add(time.Hour))
+
+	_, err := store.Get([]byte("expired"))
+	if err == nil {
+		t.Errorf("got expired value\n")
+	}
+	expiry, err := store.Expiry([]byte("later"))
+	if err != nil || !expiry.Equal(now.Add(time.Hour)) {
+		t.Errorf("got invalid expiry: %v\n", expiry)
+	}
+	expiry, err = store.Expiry([]byte("forever"))
+	if err != nil || !expiry.IsZero() {
+		t.Errorf("got invalid expiry: %v\n", expiry)
+	}
+	keys, _ := store.Keys()
+	if len(keys) != 2 {
+		t.Errorf("got invalid number of keys: %d\n", len(keys))
+	}
+
+	expired, err := store.Expire(now)
+	if err != nil {
+		t.Errorf("failed expiring: %v\n", err)
+	}
+	if len(expired) != 1 || string(expired[0]) != "expired" {
+		t.Errorf("got invalid expired keys: %v\n", expired)
+	}
+
+	expired, _ = store.Expire(now.Add(2 * time.Hour))
+	if len(expired) != 1 || string(expired[0]) != "later" {
+		t.Errorf("got invalid expired keys: %v\n", expired)
+	}
+	_, err = store.Get([]byte("forever"))
+	if err != nil {
+		t.Errorf("value without expiry should be kept\n")
+	}
+}
+
+func TestMemStorageClock(t *testing.T) {
+	store := NewMemStorage()
+	now := time.Now()
+	store.Clock = func() time.Time { return now }
+	_ = store.SetWithExpiry([]byte("key"), []byte("value"), now.Add(time.Hour))
+
+	if _, err := store.Get([]byte("key")); err != nil {
+		t.Errorf("value should not expire yet: %v\n", err)
+	}
+	now = now.Add(2 * time.Hour)
+	if _, err := store.Get([]byte("key")); err == nil {
+		t.Errorf("value should expire by storage clock\n")
+	}
+	if keys, _ := store.Keys(); len(keys) != 0 {
+		t.Errorf("expired key should not be listed: %v\n", keys)
+	}
+}